@@ -45,6 +45,21 @@ func IsDebug() bool {
 	return os.Getenv("XUI_DEBUG") == "true"
 }
 
+// GetDBFolder 返回数据库等数据文件所在的目录，可以通过 XUI_DB_FOLDER 修改，
+// 以便在同一台机器上运行多个实例，例如测试多节点管理
+func GetDBFolder() string {
+	folder := os.Getenv("XUI_DB_FOLDER")
+	if folder == "" {
+		folder = fmt.Sprintf("/etc/%s", GetName())
+	}
+	return folder
+}
+
 func GetDBPath() string {
-	return fmt.Sprintf("/etc/%s/%s.db", GetName(), GetName())
+	return fmt.Sprintf("%s/%s.db", GetDBFolder(), GetName())
+}
+
+// GetDecoyDir 返回伪装站点文件所在的目录
+func GetDecoyDir() string {
+	return fmt.Sprintf("%s/decoy", GetDBFolder())
 }
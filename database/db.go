@@ -7,8 +7,11 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"time"
 	"x-ui/config"
 	"x-ui/database/model"
+	"x-ui/util/random"
+	"x-ui/xray"
 )
 
 var db *gorm.DB
@@ -34,7 +37,108 @@ func initUser() error {
 }
 
 func initInbound() error {
-	return db.AutoMigrate(&model.Inbound{})
+	err := db.AutoMigrate(&model.Inbound{})
+	if err != nil {
+		return err
+	}
+	// 升级前创建的入站没有创建时间，以升级时间作为按天数间隔重置的起点
+	return db.Model(&model.Inbound{}).
+		Where("created_at = 0 or created_at is null").
+		Update("created_at", time.Now().UnixMilli()).Error
+}
+
+func initClientTraffic() error {
+	err := db.AutoMigrate(&xray.ClientTraffic{})
+	if err != nil {
+		return err
+	}
+	err = db.Model(&xray.ClientTraffic{}).
+		Where("created_at = 0 or created_at is null").
+		Update("created_at", time.Now().UnixMilli()).Error
+	if err != nil {
+		return err
+	}
+	// 为升级前已存在的用户补上订阅 token
+	var ids []int
+	err = db.Model(&xray.ClientTraffic{}).Where("sub_token = '' or sub_token is null").Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = db.Model(&xray.ClientTraffic{}).Where("id = ?", id).Update("sub_token", random.Seq(16)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func initTgClientBinding() error {
+	return db.AutoMigrate(&model.TgClientBinding{})
+}
+
+func initTrafficReset() error {
+	return db.AutoMigrate(&model.TrafficReset{})
+}
+
+func initBlockedIp() error {
+	return db.AutoMigrate(&model.BlockedIp{})
+}
+
+func initServerStat() error {
+	return db.AutoMigrate(&model.ServerStat{})
+}
+
+func initAlert() error {
+	return db.AutoMigrate(&model.AlertRule{}, &model.Alert{})
+}
+
+func initNotifyChannel() error {
+	return db.AutoMigrate(&model.NotifyChannel{})
+}
+
+func initWebhook() error {
+	return db.AutoMigrate(&model.WebhookEndpoint{}, &model.WebhookDelivery{})
+}
+
+func initNode() error {
+	return db.AutoMigrate(&model.Node{}, &model.NodeClientTraffic{})
+}
+
+// initGeoFile 添加 xray 自带的两个 geo 文件，默认使用 Loyalsoldier 的规则作为更新地址
+func initGeoFile() error {
+	err := db.AutoMigrate(&model.GeoFile{})
+	if err != nil {
+		return err
+	}
+	defaults := []*model.GeoFile{
+		{
+			Name:   "geoip.dat",
+			Type:   model.GeoTypeIP,
+			Url:    "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geoip.dat",
+			Verify: true,
+		},
+		{
+			Name:   "geosite.dat",
+			Type:   model.GeoTypeSite,
+			Url:    "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geosite.dat",
+			Verify: true,
+		},
+	}
+	for _, geoFile := range defaults {
+		var count int64
+		err = db.Model(&model.GeoFile{}).Where("name = ?", geoFile.Name).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			err = db.Create(geoFile).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func initSetting() error {
@@ -72,10 +176,50 @@ func InitDB(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	err = initClientTraffic()
+	if err != nil {
+		return err
+	}
 	err = initSetting()
 	if err != nil {
 		return err
 	}
+	err = initServerStat()
+	if err != nil {
+		return err
+	}
+	err = initAlert()
+	if err != nil {
+		return err
+	}
+	err = initNotifyChannel()
+	if err != nil {
+		return err
+	}
+	err = initWebhook()
+	if err != nil {
+		return err
+	}
+	err = initTgClientBinding()
+	if err != nil {
+		return err
+	}
+	err = initBlockedIp()
+	if err != nil {
+		return err
+	}
+	err = initTrafficReset()
+	if err != nil {
+		return err
+	}
+	err = initNode()
+	if err != nil {
+		return err
+	}
+	err = initGeoFile()
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -1,7 +1,10 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
 	"x-ui/util/json_util"
 	"x-ui/xray"
 )
@@ -11,8 +14,10 @@ type Protocol string
 const (
 	VMess       Protocol = "vmess"
 	VLESS       Protocol = "vless"
-	Dokodemo    Protocol = "Dokodemo-door"
+	Dokodemo    Protocol = "dokodemo-door"
 	Http        Protocol = "http"
+	Socks       Protocol = "socks"
+	MTProto     Protocol = "mtproto"
 	Trojan      Protocol = "trojan"
 	Shadowsocks Protocol = "shadowsocks"
 )
@@ -25,13 +30,21 @@ type User struct {
 
 type Inbound struct {
 	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	UserId     int    `json:"-"`
+	UserId     int    `json:"-" gorm:"index"`
 	Up         int64  `json:"up" form:"up"`
 	Down       int64  `json:"down" form:"down"`
 	Total      int64  `json:"total" form:"total"`
 	Remark     string `json:"remark" form:"remark"`
 	Enable     bool   `json:"enable" form:"enable"`
-	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime" gorm:"index"`
+	// 有效天数，ExpiryTime 为 0 时从首次产生流量开始计时，否则作为自动续期的周期
+	ExpiryDays int `json:"expiryDays" form:"expiryDays"`
+	// 剩余的自动续期次数，到期时清零流量并把到期时间延长 ExpiryDays 天
+	AutoRenew int `json:"autoRenew" form:"autoRenew"`
+	// 分组名称，用于筛选和批量操作，group 是 SQL 关键字所以列名用 group_name
+	Group string `json:"group" form:"group" gorm:"column:group_name;index"`
+
+	ClientStats []xray.ClientTraffic `json:"clientStats" form:"clientStats" gorm:"foreignKey:InboundId;references:Id"`
 
 	// config part
 	Listen         string   `json:"listen" form:"listen"`
@@ -41,6 +54,65 @@ type Inbound struct {
 	StreamSettings string   `json:"streamSettings" form:"streamSettings"`
 	Tag            string   `json:"tag" form:"tag" gorm:"unique"`
 	Sniffing       string   `json:"sniffing" form:"sniffing"`
+
+	// 流量定时重置周期，ResetDay 对每周为星期几（0 为周日），对每月为几号，对间隔为天数
+	ResetPolicy string `json:"resetPolicy" form:"resetPolicy"`
+	ResetDay    int    `json:"resetDay" form:"resetDay"`
+	LastResetAt int64  `json:"lastResetAt"`
+	CreatedAt   int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+	// 被自动禁用的原因，只有因流量超出而禁用的入站会在定时重置后重新启用
+	DisableReason string `json:"disableReason"`
+
+	// 用量的计算方式，QuotaMultiplier 只在按倍率计费时生效
+	QuotaMode       string  `json:"quotaMode" form:"quotaMode"`
+	QuotaMultiplier float64 `json:"quotaMultiplier" form:"quotaMultiplier"`
+	// 超出总量后还允许继续使用的百分比
+	QuotaGrace int `json:"quotaGrace" form:"quotaGrace"`
+	// 超出流量后的处理方式，转发时该入站的流量被路由到 OverageOutbound
+	OverageAction   string `json:"overageAction" form:"overageAction"`
+	OverageOutbound string `json:"overageOutbound" form:"overageOutbound"`
+	// 流量已超出但按设置没有禁用，仅通知或正在转发
+	OverQuota bool `json:"overQuota"`
+	// 内部入站只接收其他入站的回落，监听地址由面板生成，客户端通过回落所在入站的端口连接
+	Internal string `json:"internal" form:"internal"`
+	// 分享链接和客户端配置中使用的地址，入站在 CDN 之后时填写 CDN 上的域名
+	PublicHost string `json:"publicHost" form:"publicHost"`
+}
+
+// QuotaUsed 按用量计算方式返回计入总量的流量
+func (i *Inbound) QuotaUsed() int64 {
+	switch i.QuotaMode {
+	case QuotaModeDown:
+		return i.Down
+	case QuotaModeUp:
+		return i.Up
+	case QuotaModeMultiplier:
+		if i.QuotaMultiplier > 0 {
+			return int64(float64(i.Up+i.Down) * i.QuotaMultiplier)
+		}
+	}
+	return i.Up + i.Down
+}
+
+// QuotaLimit 返回加上宽限后实际触发超出处理的用量，0 表示不限
+func (i *Inbound) QuotaLimit() int64 {
+	if i.Total <= 0 {
+		return 0
+	}
+	return i.Total + i.Total*int64(i.QuotaGrace)/100
+}
+
+func (i *Inbound) IsQuotaExceeded() bool {
+	limit := i.QuotaLimit()
+	return limit > 0 && i.QuotaUsed() >= limit
+}
+
+// FallbackDest 返回内部入站作为回落目标时的地址
+func (i *Inbound) FallbackDest() string {
+	if i.Internal == InternalUnix {
+		return i.Listen
+	}
+	return net.JoinHostPort(i.Listen, strconv.Itoa(i.Port))
 }
 
 func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
@@ -59,8 +131,264 @@ func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
 	}
 }
 
+// GetClients 解析 Settings 中的 clients 列表，没有 clients 的协议返回空列表
+func (i *Inbound) GetClients() ([]Client, error) {
+	settings := struct {
+		Clients []Client `json:"clients"`
+	}{}
+	if i.Settings == "" {
+		return nil, nil
+	}
+	err := json.Unmarshal([]byte(i.Settings), &settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings.Clients, nil
+}
+
+type Client struct {
+	ID          string `json:"id,omitempty"`
+	Password    string `json:"password,omitempty"`
+	Email       string `json:"email"`
+	Total       int64  `json:"totalGB"`
+	ExpiryTime  int64  `json:"expiryTime"`
+	ResetPolicy string `json:"resetPolicy,omitempty"`
+	ResetDay    int    `json:"resetDay,omitempty"`
+	ExpiryDays  int    `json:"expiryDays,omitempty"`
+	AutoRenew   int    `json:"autoRenew,omitempty"`
+}
+
+const (
+	ResetNever    = "never"
+	ResetDaily    = "daily"
+	ResetWeekly   = "weekly"
+	ResetMonthly  = "monthly"
+	ResetInterval = "interval"
+)
+
+const (
+	QuotaModeBoth       = "both"
+	QuotaModeDown       = "down"
+	QuotaModeUp         = "up"
+	QuotaModeMultiplier = "multiplier"
+)
+
+const (
+	OverageDisable  = "disable"
+	OverageNotify   = "notify"
+	OverageRedirect = "redirect"
+	// 未指定转发目标时使用的出站，配置模板中没有时自动添加同名的 blackhole 出站
+	OverageDefaultOutbound = "blocked"
+)
+
+const (
+	InternalLoopback = "loopback"
+	InternalUnix     = "unix"
+)
+
+const (
+	DisableReasonQuota  = "quota"
+	DisableReasonExpiry = "expiry"
+	DisableReasonManual = "manual"
+)
+
 type Setting struct {
 	Id    int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
 	Key   string `json:"key" form:"key"`
 	Value string `json:"value" form:"value"`
 }
+
+// ServerStat 是按固定粒度聚合后的服务器状态，Resolution 为聚合粒度（秒）
+type ServerStat struct {
+	Id         int     `json:"-" gorm:"primaryKey;autoIncrement"`
+	Resolution int     `json:"-" gorm:"index:idx_server_stat,priority:1"`
+	Time       int64   `json:"time" gorm:"index:idx_server_stat,priority:2"`
+	Cpu        float64 `json:"cpu"`
+	Mem        uint64  `json:"mem"`
+	MemTotal   uint64  `json:"memTotal"`
+	Swap       uint64  `json:"swap"`
+	Disk       uint64  `json:"disk"`
+	Load1      float64 `json:"load1"`
+	Load5      float64 `json:"load5"`
+	Load15     float64 `json:"load15"`
+	TcpCount   int     `json:"tcpCount"`
+	UdpCount   int     `json:"udpCount"`
+	NetUp      uint64  `json:"netUp"`
+	NetDown    uint64  `json:"netDown"`
+}
+
+type AlertRuleType string
+
+const (
+	AlertCpu          AlertRuleType = "cpu"
+	AlertMem          AlertRuleType = "mem"
+	AlertDisk         AlertRuleType = "disk"
+	AlertXrayDown     AlertRuleType = "xray_down"
+	AlertInboundQuota AlertRuleType = "inbound_quota"
+	AlertClientQuota  AlertRuleType = "client_quota"
+	AlertExpiry       AlertRuleType = "expiry"
+	AlertTrafficSpike AlertRuleType = "traffic_spike"
+)
+
+type AlertRule struct {
+	Id        int           `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string        `json:"name" form:"name"`
+	Enable    bool          `json:"enable" form:"enable"`
+	Type      AlertRuleType `json:"type" form:"type"`
+	Threshold float64       `json:"threshold" form:"threshold"`
+	// 条件持续多少秒后才告警
+	Duration int `json:"duration" form:"duration"`
+	// 恢复时需要越过阈值的幅度，避免在阈值附近反复告警
+	Hysteresis float64 `json:"hysteresis" form:"hysteresis"`
+	// 同一对象两次告警之间的最小间隔（秒）
+	Cooldown int `json:"cooldown" form:"cooldown"`
+	// 通知渠道，0 表示按事件订阅分发
+	ChannelId int `json:"channelId" form:"channelId"`
+}
+
+type AlertStatus string
+
+const (
+	AlertFiring   AlertStatus = "firing"
+	AlertResolved AlertStatus = "resolved"
+)
+
+type Alert struct {
+	Id         int         `json:"id" gorm:"primaryKey;autoIncrement"`
+	RuleId     int         `json:"ruleId" gorm:"index"`
+	RuleName   string      `json:"ruleName"`
+	Subject    string      `json:"subject"`
+	Status     AlertStatus `json:"status" gorm:"index"`
+	Value      float64     `json:"value"`
+	Message    string      `json:"message"`
+	FiredAt    int64       `json:"firedAt"`
+	ResolvedAt int64       `json:"resolvedAt"`
+}
+
+type NotifyChannel struct {
+	Id     int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name   string `json:"name" form:"name"`
+	Type   string `json:"type" form:"type"`
+	Enable bool   `json:"enable" form:"enable"`
+	// 各类型渠道的配置，JSON 格式
+	Config string `json:"config" form:"config"`
+	// 订阅的事件类型，逗号分隔
+	Events string `json:"events" form:"events"`
+}
+
+type WebhookEndpoint struct {
+	Id     int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name   string `json:"name" form:"name"`
+	Url    string `json:"url" form:"url"`
+	Secret string `json:"secret" form:"secret"`
+	Enable bool   `json:"enable" form:"enable"`
+	// 订阅的事件类型，逗号分隔，为空表示全部事件
+	Events string `json:"events" form:"events"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookPending WebhookDeliveryStatus = "pending"
+	WebhookSuccess WebhookDeliveryStatus = "success"
+	WebhookFailed  WebhookDeliveryStatus = "failed"
+)
+
+type WebhookDelivery struct {
+	Id           int                   `json:"id" gorm:"primaryKey;autoIncrement"`
+	EndpointId   int                   `json:"endpointId" gorm:"index"`
+	EventId      string                `json:"eventId"`
+	Event        string                `json:"event"`
+	Payload      string                `json:"payload"`
+	Status       WebhookDeliveryStatus `json:"status" gorm:"index"`
+	Attempts     int                   `json:"attempts"`
+	ResponseCode int                   `json:"responseCode"`
+	LastError    string                `json:"lastError"`
+	CreatedAt    int64                 `json:"createdAt" gorm:"index"`
+	NextRetryAt  int64                 `json:"nextRetryAt"`
+	DeliveredAt  int64                 `json:"deliveredAt"`
+}
+
+// TgClientBinding 记录 Telegram 用户与入站用户(以 email 区分)的绑定
+type TgClientBinding struct {
+	Id           int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TgUserId     int64  `json:"tgUserId" gorm:"index"`
+	ChatId       int64  `json:"chatId"`
+	Email        string `json:"email" gorm:"index"`
+	QuotaWarned  bool   `json:"quotaWarned"`
+	ExpiryWarned bool   `json:"expiryWarned"`
+	CreatedAt    int64  `json:"createdAt"`
+}
+
+// BlockedIp 是被禁止访问面板的 IP，ExpiresAt 为 0 表示永久封禁
+type BlockedIp struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Ip        string `json:"ip" form:"ip" gorm:"unique"`
+	Reason    string `json:"reason" form:"reason"`
+	Source    string `json:"source" form:"source"`
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt" form:"expiresAt"`
+}
+
+const (
+	ResetTriggerManual   = "manual"
+	ResetTriggerSchedule = "schedule"
+	ResetTriggerRenew    = "renew"
+)
+
+// TrafficReset 记录每次重置前的用量，Email 为空表示入站本身的流量
+type TrafficReset struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId int    `json:"inboundId" gorm:"index"`
+	Email     string `json:"email" gorm:"index"`
+	Up        int64  `json:"up"`
+	Down      int64  `json:"down"`
+	Total     int64  `json:"total"`
+	Trigger   string `json:"trigger"`
+	ResetAt   int64  `json:"resetAt"`
+}
+
+// Node 是由本面板管理的远程 x-ui 节点，节点需要在面板设置中开启节点模式
+type Node struct {
+	Id   int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name string `json:"name" form:"name"`
+	// 节点面板的访问地址，包含协议、端口和根路径，如 https://example.com:54321/
+	Url   string `json:"url" form:"url"`
+	Token string `json:"token" form:"token"`
+	// 节点使用自签名证书时不校验证书
+	Insecure bool `json:"insecure" form:"insecure"`
+	Enable   bool `json:"enable" form:"enable"`
+	// 同名用户在节点上的流量计入本机用户，按本机用户的总流量统一启用或禁用
+	SyncClients bool  `json:"syncClients" form:"syncClients"`
+	CreatedAt   int64 `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+// NodeClientTraffic 记录上次同步时节点上用户的流量，用于计算两次同步之间的增量
+type NodeClientTraffic struct {
+	Id     int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId int    `json:"nodeId" gorm:"uniqueIndex:idx_node_client,priority:1"`
+	Email  string `json:"email" gorm:"uniqueIndex:idx_node_client,priority:2"`
+	Up     int64  `json:"up"`
+	Down   int64  `json:"down"`
+}
+
+const (
+	GeoTypeIP   = "geoip"
+	GeoTypeSite = "geosite"
+)
+
+// GeoFile 是 xray 使用的一个 geo 数据文件，保存在 bin 目录中，设置了下载地址时可以定时更新
+type GeoFile struct {
+	Id   int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name string `json:"name" form:"name" gorm:"uniqueIndex"`
+	Type string `json:"type" form:"type"`
+	Url  string `json:"url" form:"url"`
+	// 下载时校验同一地址下的 .sha256sum 文件
+	Verify  bool   `json:"verify" form:"verify"`
+	Version string `json:"version"`
+	Sha256  string `json:"sha256"`
+	// 文件内容最后一次变化的时间和最后一次尝试更新的时间
+	LastUpdate int64  `json:"lastUpdate"`
+	LastCheck  int64  `json:"lastCheck"`
+	LastError  string `json:"lastError"`
+}
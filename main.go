@@ -92,6 +92,24 @@ func resetSetting() {
 	}
 }
 
+func unblockIp(ip string) {
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ipBlockService := service.IpBlockService{}
+	count, err := ipBlockService.UnblockIpAddr(ip)
+	if err != nil {
+		fmt.Println("unblock ip failed:", err)
+	} else if count == 0 {
+		fmt.Println("ip not in block list:", ip)
+	} else {
+		fmt.Println("unblock ip success:", ip)
+	}
+}
+
 func showSetting(show bool) {
 	if show {
 		settingService := service.SettingService{}
@@ -229,6 +247,7 @@ func main() {
 	var tgbotRuntime string
 	var reset bool
 	var show bool
+	var unblock string
 	settingCmd.BoolVar(&reset, "reset", false, "reset all settings")
 	settingCmd.BoolVar(&show, "show", false, "show current settings")
 	settingCmd.IntVar(&port, "port", 0, "set panel port")
@@ -238,6 +257,7 @@ func main() {
 	settingCmd.StringVar(&tgbotRuntime, "tgbotRuntime", "", "set telegrame bot cron time")
 	settingCmd.IntVar(&tgbotchatid, "tgbotchatid", 0, "set telegrame bot chat id")
 	settingCmd.BoolVar(&enabletgbot, "enabletgbot", false, "enable telegram bot notify")
+	settingCmd.StringVar(&unblock, "unblock", "", "remove ip from panel block list")
 
 	oldUsage := flag.Usage
 	flag.Usage = func() {
@@ -290,6 +310,9 @@ func main() {
 		if (tgbottoken != "") || (tgbotchatid != 0) || (tgbotRuntime != "") {
 			updateTgbotSetting(tgbottoken, tgbotchatid, tgbotRuntime)
 		}
+		if unblock != "" {
+			unblockIp(unblock)
+		}
 	default:
 		fmt.Println("except 'run' or 'v2-ui' or 'setting' subcommands")
 		fmt.Println()
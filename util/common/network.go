@@ -1,16 +1,85 @@
 package common
 
 import (
-	"io/ioutil"
+	"context"
+	"io"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 )
 
-func GetMyIpAddr() string {
-	resp, err := http.Get("https://api64.ipify.org")
+// 查询本机公网 IP 的服务，按连接使用的协议族返回 IPv4 或 IPv6
+var publicIPServices = []string{
+	"https://api64.ipify.org",
+	"http://ip.cip.cc",
+}
+
+// DetectPublicIP 通过外部服务查询本机的公网 IP，ipv6 为 true 时只使用 IPv6 连接
+func DetectPublicIP(ipv6 bool) (string, error) {
+	network := "tcp4"
+	if ipv6 {
+		network = "tcp6"
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	var lastErr error
+	for _, url := range publicIPServices {
+		resp, err := client.Get(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ip := net.ParseIP(strings.TrimSpace(string(body)))
+		if ip == nil || (ip.To4() == nil) != ipv6 {
+			lastErr = NewError(url, "returned invalid ip:", strings.TrimSpace(string(body)))
+			continue
+		}
+		return ip.String(), nil
+	}
+	return "", lastErr
+}
+
+// GetInterfaceIP 从本机网卡中查找 IP，优先使用公网地址，没有时使用内网地址，都没有时返回空字符串
+func GetInterfaceIP(ipv6 bool) string {
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		resp, _ = http.Get("http://ip.cip.cc")
+		return ""
+	}
+	private := ""
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() || (ipNet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if ipNet.IP.IsPrivate() {
+			if private == "" {
+				private = ipNet.IP.String()
+			}
+			continue
+		}
+		return ipNet.IP.String()
 	}
-	defer resp.Body.Close()
-	s, _ := ioutil.ReadAll(resp.Body)
-	return string(s)
+	return private
+}
+
+var domainRegex = regexp.MustCompile(`^([a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?$`)
+
+// IsDomain 判断是否为域名，不包含协议和端口
+func IsDomain(s string) bool {
+	return len(s) <= 253 && domainRegex.MatchString(s)
 }
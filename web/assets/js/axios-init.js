@@ -3,9 +3,11 @@ axios.defaults.headers.common['X-Requested-With'] = 'XMLHttpRequest';
 
 axios.interceptors.request.use(
     config => {
-        config.data = Qs.stringify(config.data, {
-            arrayFormat: 'repeat'
-        });
+        if (!(config.data instanceof FormData)) {
+            config.data = Qs.stringify(config.data, {
+                arrayFormat: 'repeat'
+            });
+        }
         return config;
     },
     error => Promise.reject(error)
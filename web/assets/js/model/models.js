@@ -34,8 +34,11 @@ class DBInbound {
         this.down = 0;
         this.total = 0;
         this.remark = "";
+        this.group = "";
         this.enable = true;
         this.expiryTime = 0;
+        this.expiryDays = 0;
+        this.autoRenew = 0;
 
         this.listen = "";
         this.port = 0;
@@ -44,17 +47,48 @@ class DBInbound {
         this.streamSettings = "";
         this.tag = "";
         this.sniffing = "";
+        this.resetPolicy = "never";
+        this.resetDay = 1;
+        this.quotaMode = "both";
+        this.quotaMultiplier = 1;
+        this.quotaGrace = 0;
+        this.overageAction = "disable";
+        this.overageOutbound = "";
+        this.overQuota = false;
+        this.internal = "";
+        this.publicHost = "";
 
         if (data == null) {
             return;
         }
         ObjectUtil.cloneProps(this, data);
+        // 升级前创建的入站这些字段为空，按默认值显示
+        this.resetPolicy = this.resetPolicy || "never";
+        this.quotaMode = this.quotaMode || "both";
+        this.quotaMultiplier = this.quotaMultiplier || 1;
+        this.overageAction = this.overageAction || "disable";
+        this.internal = this.internal || "";
+        this.publicHost = this.publicHost || "";
     }
 
     get totalGB() {
         return toFixed(this.total / ONE_GB, 2);
     }
 
+    // 按计算方式计入总量的用量，与后端 Inbound.QuotaUsed 一致
+    get quotaUsed() {
+        switch (this.quotaMode) {
+            case "down":
+                return this.down;
+            case "up":
+                return this.up;
+            case "multiplier":
+                return (this.up + this.down) * this.quotaMultiplier;
+            default:
+                return this.up + this.down;
+        }
+    }
+
     set totalGB(gb) {
         this.total = toFixed(gb * ONE_GB, 0);
     }
@@ -84,6 +118,9 @@ class DBInbound {
     }
 
     get address() {
+        if (!ObjectUtil.isEmpty(this.publicHost)) {
+            return this.publicHost;
+        }
         let address = location.hostname;
         if (!ObjectUtil.isEmpty(this.listen) && this.listen !== "0.0.0.0") {
             address = this.listen;
@@ -143,15 +180,24 @@ class DBInbound {
             case Protocols.VLESS:
             case Protocols.TROJAN:
             case Protocols.SHADOWSOCKS:
+            case Protocols.SOCKS:
+            case Protocols.HTTP:
+            case Protocols.MTPROTO:
                 return true;
             default:
                 return false;
         }
     }
 
-    genLink() {
-        const inbound = this.toInbound();
-        return inbound.genLink(this.address, this.remark);
+    isMultiUser() {
+        switch (this.protocol) {
+            case Protocols.VMESS:
+            case Protocols.VLESS:
+            case Protocols.TROJAN:
+                return true;
+            default:
+                return false;
+        }
     }
 }
 
@@ -167,7 +213,42 @@ class AllSetting {
         this.tgBotToken = "";
         this.tgBotChatId = 0;
         this.tgRunTime = "";
+        this.tgBotAdmins = "";
+        this.tgLang = "zh_Hans";
+        this.tgBotMode = "polling";
+        this.tgBotApiServer = "";
+        this.tgWebhookUrl = "";
+        this.tgClientBotEnable = false;
+        this.tgClientWarnPercent = 90;
+        this.tgClientWarnDays = 3;
+        this.subEnable = false;
+        this.subURI = "";
         this.xrayTemplateConfig = "";
+        this.metricsEnable = false;
+        this.metricsToken = "";
+        this.metricsUsername = "";
+        this.metricsPassword = "";
+        this.sshWatchEnable = true;
+        this.sshLogSource = "auto";
+        this.sshLogPath = "";
+        this.sshBruteThreshold = 5;
+        this.sshBruteWindow = 10;
+        this.sshAutoBlock = false;
+        this.sshBlockDuration = 24;
+        this.portRange = "10000-60000";
+        this.portExclude = "";
+        this.decoyEnable = false;
+        this.decoyPort = 10080;
+        this.decoyFallback = true;
+        this.decoyPanelProxy = false;
+        this.publicAddressV4 = "";
+        this.publicAddressV6 = "";
+        this.publicAddressDetect = true;
+        this.nodeAgentEnable = false;
+        this.nodeAgentToken = "";
+        this.xrayDownloadMirror = "";
+        this.xrayDownloadProxy = "";
+        this.geoUpdateInterval = 0;
 
         this.timeLocation = "Asia/Shanghai";
 
@@ -883,243 +883,6 @@ class Inbound extends XrayCommonClass {
         this.sniffing = new Sniffing();
     }
 
-    genVmessLink(address = '', remark = '') {
-        if (this.protocol !== Protocols.VMESS) {
-            return '';
-        }
-        let network = this.stream.network;
-        let type = 'none';
-        let host = '';
-        let path = '';
-        if (network === 'tcp') {
-            let tcp = this.stream.tcp;
-            type = tcp.type;
-            if (type === 'http') {
-                let request = tcp.request;
-                path = request.path.join(',');
-                let index = request.headers.findIndex(header => header.name.toLowerCase() === 'host');
-                if (index >= 0) {
-                    host = request.headers[index].value;
-                }
-            }
-        } else if (network === 'kcp') {
-            let kcp = this.stream.kcp;
-            type = kcp.type;
-            path = kcp.seed;
-        } else if (network === 'ws') {
-            let ws = this.stream.ws;
-            path = ws.path;
-            let index = ws.headers.findIndex(header => header.name.toLowerCase() === 'host');
-            if (index >= 0) {
-                host = ws.headers[index].value;
-            }
-        } else if (network === 'http') {
-            network = 'h2';
-            path = this.stream.http.path;
-            host = this.stream.http.host.join(',');
-        } else if (network === 'quic') {
-            type = this.stream.quic.type;
-            host = this.stream.quic.security;
-            path = this.stream.quic.key;
-        } else if (network === 'grpc') {
-            path = this.stream.grpc.serviceName;
-        }
-
-        if (this.stream.security === 'tls') {
-            if (!ObjectUtil.isEmpty(this.stream.tls.server)) {
-                address = this.stream.tls.server;
-            }
-        }
-
-        let obj = {
-            v: '2',
-            ps: remark,
-            add: address,
-            port: this.port,
-            id: this.settings.vmesses[0].id,
-            aid: this.settings.vmesses[0].alterId,
-            net: network,
-            type: type,
-            host: host,
-            path: path,
-            tls: this.stream.security,
-        };
-        return 'vmess://' + base64(JSON.stringify(obj, null, 2));
-    }
-
-    genVLESSLink(address = '', remark = '') {
-        const settings = this.settings;
-        const uuid = settings.vlesses[0].id;
-        const port = this.port;
-        const type = this.stream.network;
-        const params = new Map();
-        params.set("type", this.stream.network);
-        if (this.xtls) {
-            params.set("security", "xtls");
-        } else {
-            params.set("security", this.stream.security);
-        }
-        switch (type) {
-            case "tcp":
-                const tcp = this.stream.tcp;
-                if (tcp.type === 'http') {
-                    const request = tcp.request;
-                    params.set("path", request.path.join(','));
-                    const index = request.headers.findIndex(header => header.name.toLowerCase() === 'host');
-                    if (index >= 0) {
-                        const host = request.headers[index].value;
-                        params.set("host", host);
-                    }
-                }
-                break;
-            case "kcp":
-                const kcp = this.stream.kcp;
-                params.set("headerType", kcp.type);
-                params.set("seed", kcp.seed);
-                break;
-            case "ws":
-                const ws = this.stream.ws;
-                params.set("path", ws.path);
-                const index = ws.headers.findIndex(header => header.name.toLowerCase() === 'host');
-                if (index >= 0) {
-                    const host = ws.headers[index].value;
-                    params.set("host", host);
-                }
-                break;
-            case "http":
-                const http = this.stream.http;
-                params.set("path", http.path);
-                params.set("host", http.host);
-                break;
-            case "quic":
-                const quic = this.stream.quic;
-                params.set("quicSecurity", quic.security);
-                params.set("key", quic.key);
-                params.set("headerType", quic.type);
-                break;
-            case "grpc":
-                const grpc = this.stream.grpc;
-                params.set("serviceName", grpc.serviceName);
-                break;
-        }
-
-        if (this.stream.security === 'tls') {
-            if (!ObjectUtil.isEmpty(this.stream.tls.server)) {
-                address = this.stream.tls.server;
-                params.set("sni", address);
-            }
-        }
-
-        if (this.xtls) {
-            params.set("flow", this.settings.vlesses[0].flow);
-        }
-
-        const link = `vless://${uuid}@${address}:${port}`;
-        const url = new URL(link);
-        for (const [key, value] of params) {
-            url.searchParams.set(key, value)
-        }
-        url.hash = encodeURIComponent(remark);
-        return url.toString();
-    }
-
-    genSSLink(address = '', remark = '') {
-        let settings = this.settings;
-        const server = this.stream.tls.server;
-        if (!ObjectUtil.isEmpty(server)) {
-            address = server;
-        }
-        if (settings.method == SSMethods.BLAKE3_AES_128_GCM || settings.method == SSMethods.BLAKE3_AES_256_GCM || settings.method == SSMethods.BLAKE3_CHACHA20_POLY1305) {
-            return `ss://${settings.method}:${settings.password}@${address}:${this.port}#${encodeURIComponent(remark)}`;
-        } else {
-            return 'ss://' + safeBase64(settings.method + ':' + settings.password + '@' + address + ':' + this.port)
-                + '#' + encodeURIComponent(remark);
-        }
-    }
-
-    genTrojanLink(address = '', remark = '') {
-        let settings = this.settings;
-        const port = this.port;
-        const type = this.stream.network;
-        const params = new Map();
-        params.set("type", this.stream.network);
-        if (this.xtls) {
-            params.set("security", "xtls");
-        } else {
-            params.set("security", this.stream.security);
-        }
-        switch (type) {
-            case "tcp":
-                const tcp = this.stream.tcp;
-                if (tcp.type === 'http') {
-                    const request = tcp.request;
-                    params.set("path", request.path.join(','));
-                    const index = request.headers.findIndex(header => header.name.toLowerCase() === 'host');
-                    if (index >= 0) {
-                        const host = request.headers[index].value;
-                        params.set("host", host);
-                    }
-                }
-                break;
-            case "kcp":
-                const kcp = this.stream.kcp;
-                params.set("headerType", kcp.type);
-                params.set("seed", kcp.seed);
-                break;
-            case "ws":
-                const ws = this.stream.ws;
-                params.set("path", ws.path);
-                const index = ws.headers.findIndex(header => header.name.toLowerCase() === 'host');
-                if (index >= 0) {
-                    const host = ws.headers[index].value;
-                    params.set("host", host);
-                }
-                break;
-            case "http":
-                const http = this.stream.http;
-                params.set("path", http.path);
-                params.set("host", http.host);
-                break;
-            case "quic":
-                const quic = this.stream.quic;
-                params.set("quicSecurity", quic.security);
-                params.set("key", quic.key);
-                params.set("headerType", quic.type);
-                break;
-            case "grpc":
-                const grpc = this.stream.grpc;
-                params.set("serviceName", grpc.serviceName);
-                break;
-        }
-
-        if (this.stream.security === 'tls') {
-            if (!ObjectUtil.isEmpty(this.stream.tls.server)) {
-                address = this.stream.tls.server;
-                params.set("sni", address);
-            }
-        }
-        if (this.xtls) {
-            params.set("flow", this.settings.clients[0].flow);
-        }
-        const link = `trojan://${settings.clients[0].password}@${address}:${port}`;
-        const url = new URL(link);
-        for (const [key, value] of params) {
-            url.searchParams.set(key, value)
-        }
-        url.hash = encodeURIComponent(remark);
-        return url.toString();
-    }
-
-    genLink(address = '', remark = '') {
-        switch (this.protocol) {
-            case Protocols.VMESS: return this.genVmessLink(address, remark);
-            case Protocols.VLESS: return this.genVLESSLink(address, remark);
-            case Protocols.SHADOWSOCKS: return this.genSSLink(address, remark);
-            case Protocols.TROJAN: return this.genTrojanLink(address, remark);
-            default: return '';
-        }
-    }
-
     static fromJson(json = {}) {
         return new Inbound(
             json.port,
@@ -1231,16 +994,30 @@ Inbound.VmessSettings = class extends Inbound.Settings {
     }
 };
 Inbound.VmessSettings.Vmess = class extends XrayCommonClass {
-    constructor(id = RandomUtil.randomUUID(), alterId = 0) {
+    constructor(id = RandomUtil.randomUUID(), alterId = 0, email = '', totalGB = 0, expiryTime = 0, resetPolicy = '', resetDay = 0, expiryDays = 0, autoRenew = 0) {
         super();
         this.id = id;
         this.alterId = alterId;
+        this.email = email;
+        this.totalGB = totalGB;
+        this.expiryTime = expiryTime;
+        this.resetPolicy = resetPolicy;
+        this.resetDay = resetDay;
+        this.expiryDays = expiryDays;
+        this.autoRenew = autoRenew;
     }
 
     static fromJson(json = {}) {
         return new Inbound.VmessSettings.Vmess(
             json.id,
             json.alterId,
+            json.email,
+            json.totalGB,
+            json.expiryTime,
+            json.resetPolicy,
+            json.resetDay,
+            json.expiryDays,
+            json.autoRenew,
         );
     }
 };
@@ -1283,27 +1060,43 @@ Inbound.VLESSSettings = class extends Inbound.Settings {
 };
 Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
 
-    constructor(id = RandomUtil.randomUUID(), flow = FLOW_CONTROL.DIRECT) {
+    constructor(id = RandomUtil.randomUUID(), flow = FLOW_CONTROL.DIRECT, email = '', totalGB = 0, expiryTime = 0, resetPolicy = '', resetDay = 0, expiryDays = 0, autoRenew = 0) {
         super();
         this.id = id;
         this.flow = flow;
+        this.email = email;
+        this.totalGB = totalGB;
+        this.expiryTime = expiryTime;
+        this.resetPolicy = resetPolicy;
+        this.resetDay = resetDay;
+        this.expiryDays = expiryDays;
+        this.autoRenew = autoRenew;
     }
 
     static fromJson(json = {}) {
         return new Inbound.VLESSSettings.VLESS(
             json.id,
             json.flow,
+            json.email,
+            json.totalGB,
+            json.expiryTime,
+            json.resetPolicy,
+            json.resetDay,
+            json.expiryDays,
+            json.autoRenew,
         );
     }
 };
 Inbound.VLESSSettings.Fallback = class extends XrayCommonClass {
-    constructor(name = "", alpn = '', path = '', dest = '', xver = 0) {
+    constructor(name = "", alpn = '', path = '', dest = '', xver = 0, inbound = '') {
         super();
         this.name = name;
         this.alpn = alpn;
         this.path = path;
         this.dest = dest;
         this.xver = xver;
+        // 回落到的内部入站标签，dest 由面板生成
+        this.inbound = inbound;
     }
 
     toJson() {
@@ -1315,8 +1108,9 @@ Inbound.VLESSSettings.Fallback = class extends XrayCommonClass {
             name: this.name,
             alpn: this.alpn,
             path: this.path,
-            dest: this.dest,
+            dest: this.inbound ? '' : this.dest,
             xver: xver,
+            inbound: this.inbound ? this.inbound : undefined,
         }
     }
 
@@ -1329,6 +1123,7 @@ Inbound.VLESSSettings.Fallback = class extends XrayCommonClass {
                 fallback.path,
                 fallback.dest,
                 fallback.xver,
+                fallback.inbound,
             ))
         }
         return fallbacks;
@@ -1371,16 +1166,30 @@ Inbound.TrojanSettings = class extends Inbound.Settings {
     }
 };
 Inbound.TrojanSettings.Client = class extends XrayCommonClass {
-    constructor(password = RandomUtil.randomSeq(10), flow = FLOW_CONTROL.DIRECT) {
+    constructor(password = RandomUtil.randomSeq(10), flow = FLOW_CONTROL.DIRECT, email = '', totalGB = 0, expiryTime = 0, resetPolicy = '', resetDay = 0, expiryDays = 0, autoRenew = 0) {
         super();
         this.password = password;
         this.flow = flow;
+        this.email = email;
+        this.totalGB = totalGB;
+        this.expiryTime = expiryTime;
+        this.resetPolicy = resetPolicy;
+        this.resetDay = resetDay;
+        this.expiryDays = expiryDays;
+        this.autoRenew = autoRenew;
     }
 
     toJson() {
         return {
             password: this.password,
             flow: this.flow,
+            email: this.email,
+            totalGB: this.totalGB,
+            expiryTime: this.expiryTime,
+            resetPolicy: this.resetPolicy,
+            resetDay: this.resetDay,
+            expiryDays: this.expiryDays,
+            autoRenew: this.autoRenew,
         };
     }
 
@@ -1388,19 +1197,28 @@ Inbound.TrojanSettings.Client = class extends XrayCommonClass {
         return new Inbound.TrojanSettings.Client(
             json.password,
             json.flow,
+            json.email,
+            json.totalGB,
+            json.expiryTime,
+            json.resetPolicy,
+            json.resetDay,
+            json.expiryDays,
+            json.autoRenew,
         );
     }
 
 };
 
 Inbound.TrojanSettings.Fallback = class extends XrayCommonClass {
-    constructor(name = "", alpn = '', path = '', dest = '', xver = 0) {
+    constructor(name = "", alpn = '', path = '', dest = '', xver = 0, inbound = '') {
         super();
         this.name = name;
         this.alpn = alpn;
         this.path = path;
         this.dest = dest;
         this.xver = xver;
+        // 回落到的内部入站标签，dest 由面板生成
+        this.inbound = inbound;
     }
 
     toJson() {
@@ -1412,8 +1230,9 @@ Inbound.TrojanSettings.Fallback = class extends XrayCommonClass {
             name: this.name,
             alpn: this.alpn,
             path: this.path,
-            dest: this.dest,
+            dest: this.inbound ? '' : this.dest,
             xver: xver,
+            inbound: this.inbound ? this.inbound : undefined,
         }
     }
 
@@ -1426,6 +1245,7 @@ Inbound.TrojanSettings.Fallback = class extends XrayCommonClass {
                 fallback.path,
                 fallback.dest,
                 fallback.xver,
+                fallback.inbound,
             ))
         }
         return fallbacks;
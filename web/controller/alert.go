@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"strconv"
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AlertController struct {
+	alertService service.AlertService
+}
+
+func NewAlertController(g *gin.RouterGroup) *AlertController {
+	a := &AlertController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *AlertController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/alert")
+
+	g.POST("/rules", a.getRules)
+	g.POST("/rule/add", a.addRule)
+	g.POST("/rule/update/:id", a.updateRule)
+	g.POST("/rule/del/:id", a.delRule)
+	g.POST("/list", a.getAlerts)
+}
+
+func (a *AlertController) getRules(c *gin.Context) {
+	rules, err := a.alertService.GetRules()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, rules, nil)
+}
+
+func (a *AlertController) addRule(c *gin.Context) {
+	rule := &model.AlertRule{}
+	err := c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.add"), err)
+		return
+	}
+	rule.Id = 0
+	err = a.alertService.AddRule(rule)
+	jsonMsg(c, localize(c, "action.add"), err)
+}
+
+func (a *AlertController) updateRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	rule := &model.AlertRule{}
+	err = c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	rule.Id = id
+	err = a.alertService.UpdateRule(rule)
+	jsonMsg(c, localize(c, "action.update"), err)
+}
+
+func (a *AlertController) delRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	err = a.alertService.DelRule(id)
+	jsonMsg(c, localize(c, "action.delete"), err)
+}
+
+func (a *AlertController) getAlerts(c *gin.Context) {
+	alerts, err := a.alertService.GetAlerts(200)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, alerts, nil)
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GeoController struct {
+	geoService service.GeoService
+}
+
+func NewGeoController(g *gin.RouterGroup) *GeoController {
+	a := &GeoController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *GeoController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/geo")
+
+	g.POST("/list", a.getGeoFiles)
+	g.POST("/add", a.addGeoFile)
+	g.POST("/update/:id", a.updateGeoFile)
+	g.POST("/del/:id", a.delGeoFile)
+	g.POST("/download/:id", a.downloadGeoFile)
+	g.POST("/upload", a.uploadGeoFile)
+	g.POST("/categories/:id", a.getCategories)
+}
+
+func (a *GeoController) getGeoFiles(c *gin.Context) {
+	geoFiles, err := a.geoService.GetGeoFiles()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, geoFiles, nil)
+}
+
+func (a *GeoController) addGeoFile(c *gin.Context) {
+	geoFile := &model.GeoFile{}
+	err := c.ShouldBind(geoFile)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.add"), err)
+		return
+	}
+	geoFile.Id = 0
+	err = a.geoService.AddGeoFile(geoFile)
+	jsonMsgObj(c, localize(c, "action.add"), geoFile, err)
+}
+
+func (a *GeoController) updateGeoFile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	geoFile := &model.GeoFile{}
+	err = c.ShouldBind(geoFile)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	geoFile.Id = id
+	err = a.geoService.UpdateGeoFile(geoFile)
+	jsonMsg(c, localize(c, "action.update"), err)
+}
+
+func (a *GeoController) delGeoFile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	err = a.geoService.DelGeoFile(id)
+	jsonMsg(c, localize(c, "action.delete"), err)
+}
+
+func (a *GeoController) downloadGeoFile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.updateGeo"), err)
+		return
+	}
+	err = a.geoService.UpdateFromUrl(id)
+	jsonMsg(c, localize(c, "action.updateGeo"), err)
+}
+
+func (a *GeoController) uploadGeoFile(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		jsonMsg(c, localize(c, "action.uploadGeo"), err)
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.uploadGeo"), err)
+		return
+	}
+	defer file.Close()
+	// 没有指定文件名时使用上传的文件名，替换已有文件时指定为已有的文件名
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = header.Filename
+	}
+	err = a.geoService.UploadGeoFile(name, c.PostForm("type"), file, header.Size)
+	jsonMsg(c, localize(c, "action.uploadGeo"), err)
+}
+
+func (a *GeoController) getCategories(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	categories, err := a.geoService.GetCategories(id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, categories, nil)
+}
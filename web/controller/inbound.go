@@ -1,19 +1,26 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"net/http"
+	"net/url"
 	"strconv"
 	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/web/entity"
 	"x-ui/web/global"
 	"x-ui/web/service"
 	"x-ui/web/session"
+	"x-ui/xray"
 )
 
 type InboundController struct {
-	inboundService service.InboundService
-	xrayService    service.XrayService
+	inboundService      service.InboundService
+	xrayService         service.XrayService
+	subscriptionService service.SubscriptionService
 }
 
 func NewInboundController(g *gin.RouterGroup) *InboundController {
@@ -27,9 +34,22 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g = g.Group("/inbound")
 
 	g.POST("/list", a.getInbounds)
+	g.POST("/page", a.getInboundPage)
+	g.POST("/summary", a.getInboundSummary)
 	g.POST("/add", a.addInbound)
 	g.POST("/del/:id", a.delInbound)
 	g.POST("/update/:id", a.updateInbound)
+	g.POST("/client/reset/:email", a.resetClientTraffic)
+	g.POST("/client/subs/:id", a.getClientSubs)
+	g.POST("/resets/:id", a.getTrafficResets)
+	g.POST("/groups", a.getGroups)
+	g.POST("/batch", a.batchInbounds)
+	g.POST("/freePort", a.getFreePort)
+	g.POST("/check", a.checkInbound)
+	g.POST("/internal", a.getInternalInbounds)
+	g.POST("/links/:id", a.getShareLinks)
+	g.GET("/qrcode/:id", a.getQrCode)
+	g.GET("/export/:id", a.exportClientConfig)
 }
 
 func (a *InboundController) startTask() {
@@ -49,38 +69,105 @@ func (a *InboundController) getInbounds(c *gin.Context) {
 	user := session.GetLoginUser(c)
 	inbounds, err := a.inboundService.GetInbounds(user.Id)
 	if err != nil {
-		jsonMsg(c, "获取", err)
+		jsonMsg(c, localize(c, "action.get"), err)
 		return
 	}
 	jsonObj(c, inbounds, nil)
 }
 
+func (a *InboundController) getInboundPage(c *gin.Context) {
+	query := &entity.InboundQuery{}
+	err := c.ShouldBind(query)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	user := session.GetLoginUser(c)
+	err = a.inboundService.GetInboundPage(user.Id, query)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, query, nil)
+}
+
+func (a *InboundController) getInboundSummary(c *gin.Context) {
+	user := session.GetLoginUser(c)
+	summary, err := a.inboundService.GetInboundSummary(user.Id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, summary, nil)
+}
+
 func (a *InboundController) addInbound(c *gin.Context) {
 	inbound := &model.Inbound{}
 	err := c.ShouldBind(inbound)
 	if err != nil {
-		jsonMsg(c, "添加", err)
+		jsonMsg(c, localize(c, "action.add"), err)
 		return
 	}
 	user := session.GetLoginUser(c)
 	inbound.UserId = user.Id
 	inbound.Enable = true
+	// 未指定端口时自动分配
+	if inbound.Port == 0 {
+		inbound.Port, err = a.inboundService.AllocatePort(inbound.Listen)
+		if err != nil {
+			jsonMsg(c, localize(c, "action.add"), err)
+			return
+		}
+	}
 	inbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
 	err = a.inboundService.AddInbound(inbound)
-	jsonMsg(c, "添加", err)
+	jsonInboundMsg(c, localize(c, "action.add"), inbound, err)
 	if err == nil {
 		a.xrayService.SetToNeedRestart()
 	}
 }
 
+// checkInbound 只检查入站配置不保存，失败时 obj 为各字段的错误
+func (a *InboundController) checkInbound(c *gin.Context) {
+	inbound := &model.Inbound{}
+	err := c.ShouldBind(inbound)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.check"), err)
+		return
+	}
+	err = xray.ValidateInbound(string(inbound.Protocol), inbound.Settings, inbound.StreamSettings, inbound.Sniffing)
+	jsonInboundMsg(c, localize(c, "action.check"), nil, err)
+}
+
+// jsonInboundMsg 入站配置检查失败时在 obj 中返回各字段的错误，前端可以定位到具体字段
+func jsonInboundMsg(c *gin.Context, msg string, obj interface{}, err error) {
+	var fieldErrs xray.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		localizer, _ := c.Value("localizer").(*i18n.Localizer)
+		fieldErrs = fieldErrs.Localize(localizer)
+		obj = fieldErrs
+		err = fieldErrs
+	}
+	jsonMsgObj(c, msg, obj, err)
+}
+
+func (a *InboundController) getFreePort(c *gin.Context) {
+	port, err := a.inboundService.AllocatePort(c.PostForm("listen"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, port, nil)
+}
+
 func (a *InboundController) delInbound(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		jsonMsg(c, "删除", err)
+		jsonMsg(c, localize(c, "action.delete"), err)
 		return
 	}
 	err = a.inboundService.DelInbound(id)
-	jsonMsg(c, "删除", err)
+	jsonMsg(c, localize(c, "action.delete"), err)
 	if err == nil {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -89,7 +176,7 @@ func (a *InboundController) delInbound(c *gin.Context) {
 func (a *InboundController) updateInbound(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		jsonMsg(c, "修改", err)
+		jsonMsg(c, localize(c, "action.update"), err)
 		return
 	}
 	inbound := &model.Inbound{
@@ -97,11 +184,168 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 	}
 	err = c.ShouldBind(inbound)
 	if err != nil {
-		jsonMsg(c, "修改", err)
+		jsonMsg(c, localize(c, "action.update"), err)
 		return
 	}
 	err = a.inboundService.UpdateInbound(inbound)
-	jsonMsg(c, "修改", err)
+	jsonInboundMsg(c, localize(c, "action.update"), nil, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) resetClientTraffic(c *gin.Context) {
+	err := a.inboundService.ResetClientTraffic(c.Param("email"))
+	jsonMsg(c, localize(c, "action.reset"), err)
+}
+
+func (a *InboundController) getClientSubs(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	subs, err := a.subscriptionService.GetInboundSubURLs(id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, subs, nil)
+}
+
+func (a *InboundController) getTrafficResets(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	resets, err := a.inboundService.GetTrafficResets(id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, resets, nil)
+}
+
+func (a *InboundController) getGroups(c *gin.Context) {
+	user := session.GetLoginUser(c)
+	groups, err := a.inboundService.GetInboundGroups(user.Id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, groups, nil)
+}
+
+func (a *InboundController) getInternalInbounds(c *gin.Context) {
+	user := session.GetLoginUser(c)
+	inbounds, err := a.inboundService.GetInternalInbounds(user.Id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, inbounds, nil)
+}
+
+func (a *InboundController) getShareLinks(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	inbound, err := a.inboundService.GetInbound(id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	links, err := a.inboundService.GetShareLinks(inbound)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, links, nil)
+}
+
+// getQrCode 返回 email 对应用户分享链接的二维码 PNG，email 为空时使用第一个链接
+func (a *InboundController) getQrCode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	inbound, err := a.inboundService.GetInbound(id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	links, err := a.inboundService.GetShareLinks(inbound)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	email := c.Query("email")
+	for _, link := range links {
+		if email != "" && link.Email != email {
+			continue
+		}
+		size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+		if err != nil || size < 64 || size > 1024 {
+			size = 256
+		}
+		png, err := service.GenQrCode(link.Link, size)
+		if err != nil {
+			jsonMsg(c, localize(c, "action.get"), err)
+			return
+		}
+		c.Data(http.StatusOK, "image/png", png)
+		return
+	}
+	jsonMsg(c, localize(c, "action.get"), errors.New(localize(c, "msg.noQrLink")))
+}
+
+// exportClientConfig 下载入站的客户端配置，format 为 xray、clash 或 singbox，email 为空时包含所有用户
+func (a *InboundController) exportClientConfig(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.export"), err)
+		return
+	}
+	format := c.Query("format")
+	contentType, ext, err := service.ClientConfigContentType(format)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.export"), err)
+		return
+	}
+	inbound, err := a.inboundService.GetInbound(id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.export"), err)
+		return
+	}
+	email := c.Query("email")
+	data, err := a.inboundService.GetClientConfig(inbound, email, format)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.export"), err)
+		return
+	}
+	name := inbound.Tag
+	if email != "" {
+		name = email
+	}
+	name = fmt.Sprintf("%v-%v%v", name, format, ext)
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (a *InboundController) batchInbounds(c *gin.Context) {
+	batch := &entity.InboundBatch{}
+	err := c.ShouldBind(batch)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	user := session.GetLoginUser(c)
+	count, err := a.inboundService.BatchInbounds(user.Id, batch)
+	jsonMsgObj(c, localize(c, "action.update"), count, err)
 	if err == nil {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type blockIpForm struct {
+	Ip     string `json:"ip" form:"ip"`
+	Reason string `json:"reason" form:"reason"`
+	// 封禁时长（小时），0 表示永久
+	Hours int `json:"hours" form:"hours"`
+}
+
+type IpBlockController struct {
+	ipBlockService service.IpBlockService
+}
+
+func NewIpBlockController(g *gin.RouterGroup) *IpBlockController {
+	a := &IpBlockController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *IpBlockController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/ipblock")
+
+	g.POST("/list", a.getBlockedIps)
+	g.POST("/add", a.blockIp)
+	g.POST("/del/:id", a.unblockIp)
+}
+
+func (a *IpBlockController) getBlockedIps(c *gin.Context) {
+	ips, err := a.ipBlockService.GetBlockedIps()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, ips, nil)
+}
+
+func (a *IpBlockController) blockIp(c *gin.Context) {
+	form := &blockIpForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.add"), err)
+		return
+	}
+	if form.Hours < 0 {
+		jsonMsg(c, localize(c, "action.add"), errors.New(localize(c, "msg.negativeBlockHours")))
+		return
+	}
+	if ip := net.ParseIP(form.Ip); ip != nil && ip.Equal(net.ParseIP(getRemoteIp(c))) {
+		jsonMsg(c, localize(c, "action.add"), errors.New(localize(c, "msg.blockSelf")))
+		return
+	}
+	err = a.ipBlockService.BlockIp(form.Ip, form.Reason, service.IpBlockSourceManual, time.Duration(form.Hours)*time.Hour)
+	jsonMsg(c, localize(c, "action.add"), err)
+}
+
+func (a *IpBlockController) unblockIp(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	err = a.ipBlockService.UnblockIp(id)
+	jsonMsg(c, localize(c, "action.delete"), err)
+}
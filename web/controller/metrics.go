@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"x-ui/logger"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MetricsController struct {
+	metricsService service.MetricsService
+	settingService service.SettingService
+}
+
+func NewMetricsController(g *gin.RouterGroup) *MetricsController {
+	a := &MetricsController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *MetricsController) initRouter(g *gin.RouterGroup) {
+	g.GET("/metrics", a.checkAuth, a.metrics)
+}
+
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// checkAuth 使用独立于面板登录的 token 或 basic auth 校验抓取请求
+func (a *MetricsController) checkAuth(c *gin.Context) {
+	enable, err := a.settingService.GetMetricsEnable()
+	if err != nil || !enable {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token, err := a.settingService.GetMetricsToken()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if token != "" {
+		auth := c.GetHeader("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") && secureEqual(strings.TrimPrefix(auth, "Bearer "), token) {
+			c.Next()
+			return
+		}
+		if secureEqual(c.Query("token"), token) {
+			c.Next()
+			return
+		}
+	}
+
+	username, err := a.settingService.GetMetricsUsername()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	password, err := a.settingService.GetMetricsPassword()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if username != "" && password != "" {
+		user, pass, ok := c.Request.BasicAuth()
+		if ok && secureEqual(user, username) && secureEqual(pass, password) {
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", `Basic realm="x-ui metrics"`)
+	}
+
+	logger.Warning("unauthorized metrics request from", getRemoteIp(c))
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+func (a *MetricsController) metrics(c *gin.Context) {
+	text, err := a.metricsService.GetMetrics()
+	if err != nil {
+		logger.Warning("get metrics failed:", err)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(text))
+}
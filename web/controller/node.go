@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"strconv"
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NodeController struct {
+	nodeService service.NodeService
+}
+
+func NewNodeController(g *gin.RouterGroup) *NodeController {
+	a := &NodeController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *NodeController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/node")
+
+	g.POST("/list", a.getNodes)
+	g.POST("/overview", a.getOverviews)
+	g.POST("/add", a.addNode)
+	g.POST("/update/:id", a.updateNode)
+	g.POST("/del/:id", a.delNode)
+	g.POST("/test", a.testNode)
+	g.POST("/inbounds/:id", a.getNodeInbounds)
+	g.POST("/push/:id", a.pushInbound)
+	g.POST("/delInbound/:id", a.delNodeInbound)
+	g.POST("/restartXray/:id", a.restartXray)
+}
+
+func (a *NodeController) getNodes(c *gin.Context) {
+	nodes, err := a.nodeService.GetNodes()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, nodes, nil)
+}
+
+func (a *NodeController) getOverviews(c *gin.Context) {
+	overviews, err := a.nodeService.GetOverviews(localize(c, "node.local"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, overviews, nil)
+}
+
+func (a *NodeController) addNode(c *gin.Context) {
+	node := &model.Node{}
+	err := c.ShouldBind(node)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.add"), err)
+		return
+	}
+	node.Id = 0
+	err = a.nodeService.AddNode(node)
+	jsonMsg(c, localize(c, "action.add"), err)
+}
+
+func (a *NodeController) updateNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	node := &model.Node{}
+	err = c.ShouldBind(node)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	node.Id = id
+	err = a.nodeService.UpdateNode(node)
+	jsonMsg(c, localize(c, "action.update"), err)
+}
+
+func (a *NodeController) delNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	err = a.nodeService.DelNode(id)
+	jsonMsg(c, localize(c, "action.delete"), err)
+}
+
+func (a *NodeController) testNode(c *gin.Context) {
+	node := &model.Node{}
+	err := c.ShouldBind(node)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.test"), err)
+		return
+	}
+	status, err := a.nodeService.TestNode(node)
+	jsonMsgObj(c, localize(c, "action.test"), status, err)
+}
+
+func (a *NodeController) getNodeInbounds(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	inbounds, err := a.nodeService.GetNodeInbounds(id)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, inbounds, nil)
+}
+
+type nodeInboundForm struct {
+	InboundId int `json:"inboundId" form:"inboundId"`
+}
+
+func (a *NodeController) pushInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.push"), err)
+		return
+	}
+	form := &nodeInboundForm{}
+	err = c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.push"), err)
+		return
+	}
+	inbound, err := a.nodeService.PushInbound(id, form.InboundId)
+	jsonMsgObj(c, localize(c, "action.push"), inbound, err)
+}
+
+func (a *NodeController) delNodeInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	form := &nodeInboundForm{}
+	err = c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	err = a.nodeService.DelNodeInbound(id, form.InboundId)
+	jsonMsg(c, localize(c, "action.delete"), err)
+}
+
+func (a *NodeController) restartXray(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.restartXray"), err)
+		return
+	}
+	err = a.nodeService.RestartNodeXray(id)
+	jsonMsg(c, localize(c, "action.restartXray"), err)
+}
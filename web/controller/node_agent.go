@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/web/entity"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeAgentController 是供主面板调用的节点接口，使用节点 Token 而不是面板登录校验
+type NodeAgentController struct {
+	nodeAgentService service.NodeAgentService
+	inboundService   service.InboundService
+	settingService   service.SettingService
+}
+
+func NewNodeAgentController(g *gin.RouterGroup) *NodeAgentController {
+	a := &NodeAgentController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *NodeAgentController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/node")
+	g.Use(a.checkAuth)
+
+	g.POST("/status", a.status)
+	g.POST("/inbounds", a.getInbounds)
+	g.POST("/inbound/push", a.pushInbound)
+	g.POST("/inbound/del/:id", a.delInbound)
+	g.POST("/clients", a.getClients)
+	g.POST("/client/enable", a.setClientEnable)
+	g.POST("/xray/restart", a.restartXray)
+}
+
+// checkAuth 未开启节点模式时返回 404，与不存在的路径一样
+func (a *NodeAgentController) checkAuth(c *gin.Context) {
+	enable, err := a.settingService.GetNodeAgentEnable()
+	if err != nil || !enable {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	token, err := a.settingService.GetNodeAgentToken()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	auth := c.GetHeader("Authorization")
+	if token != "" && strings.HasPrefix(auth, "Bearer ") && secureEqual(strings.TrimPrefix(auth, "Bearer "), token) {
+		c.Next()
+		return
+	}
+	logger.Warning("unauthorized node request from", getRemoteIp(c))
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// agentMsg 返回给主面板的结果，失败时 msg 只有错误本身，由主面板加上操作名称
+func agentMsg(c *gin.Context, obj interface{}, err error) {
+	m := entity.Msg{
+		Success: err == nil,
+		Obj:     obj,
+	}
+	if err != nil {
+		m.Msg = err.Error()
+		logger.Warning("node request failed:", err)
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+func (a *NodeAgentController) status(c *gin.Context) {
+	status, err := a.nodeAgentService.GetStatus()
+	agentMsg(c, status, err)
+}
+
+func (a *NodeAgentController) getInbounds(c *gin.Context) {
+	inbounds, err := a.inboundService.GetAllInbounds()
+	agentMsg(c, inbounds, err)
+}
+
+func (a *NodeAgentController) pushInbound(c *gin.Context) {
+	inbound := &model.Inbound{}
+	err := c.ShouldBindJSON(inbound)
+	if err != nil {
+		agentMsg(c, nil, err)
+		return
+	}
+	inbound, err = a.nodeAgentService.PushInbound(inbound)
+	agentMsg(c, inbound, err)
+}
+
+func (a *NodeAgentController) delInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		agentMsg(c, nil, err)
+		return
+	}
+	err = a.nodeAgentService.DelInbound(id)
+	agentMsg(c, nil, err)
+}
+
+func (a *NodeAgentController) getClients(c *gin.Context) {
+	traffics, err := a.nodeAgentService.GetClientTraffics()
+	agentMsg(c, traffics, err)
+}
+
+type clientEnableForm struct {
+	Email  string `json:"email" form:"email"`
+	Enable bool   `json:"enable" form:"enable"`
+}
+
+func (a *NodeAgentController) setClientEnable(c *gin.Context) {
+	form := &clientEnableForm{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		agentMsg(c, nil, err)
+		return
+	}
+	err = a.nodeAgentService.SetClientEnable(form.Email, form.Enable)
+	agentMsg(c, nil, err)
+}
+
+func (a *NodeAgentController) restartXray(c *gin.Context) {
+	err := a.nodeAgentService.RestartXray()
+	agentMsg(c, nil, err)
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"strconv"
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotifyController struct {
+	notifyService service.NotifyService
+}
+
+func NewNotifyController(g *gin.RouterGroup) *NotifyController {
+	a := &NotifyController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *NotifyController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/notify")
+
+	g.POST("/channels", a.getChannels)
+	g.POST("/channel/add", a.addChannel)
+	g.POST("/channel/update/:id", a.updateChannel)
+	g.POST("/channel/del/:id", a.delChannel)
+	g.POST("/channel/test", a.testChannel)
+}
+
+func (a *NotifyController) getChannels(c *gin.Context) {
+	channels, err := a.notifyService.GetChannels()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, channels, nil)
+}
+
+func (a *NotifyController) addChannel(c *gin.Context) {
+	channel := &model.NotifyChannel{}
+	err := c.ShouldBind(channel)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.add"), err)
+		return
+	}
+	channel.Id = 0
+	err = a.notifyService.AddChannel(channel)
+	jsonMsg(c, localize(c, "action.add"), err)
+}
+
+func (a *NotifyController) updateChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	channel := &model.NotifyChannel{}
+	err = c.ShouldBind(channel)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	channel.Id = id
+	err = a.notifyService.UpdateChannel(channel)
+	jsonMsg(c, localize(c, "action.update"), err)
+}
+
+func (a *NotifyController) delChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	err = a.notifyService.DelChannel(id)
+	jsonMsg(c, localize(c, "action.delete"), err)
+}
+
+func (a *NotifyController) testChannel(c *gin.Context) {
+	channel := &model.NotifyChannel{}
+	err := c.ShouldBind(channel)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.test"), err)
+		return
+	}
+	err = a.notifyService.TestChannel(channel)
+	jsonMsg(c, localize(c, "action.test"), err)
+}
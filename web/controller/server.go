@@ -10,7 +10,9 @@ import (
 type ServerController struct {
 	BaseController
 
-	serverService service.ServerService
+	serverService     service.ServerService
+	serverStatService service.ServerStatService
+	xrayCoreService   service.XrayCoreService
 
 	lastStatus        *service.Status
 	lastGetStatusTime time.Time
@@ -33,8 +35,15 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 
 	g.Use(a.checkLogin)
 	g.POST("/status", a.status)
+	g.POST("/history", a.history)
 	g.POST("/getXrayVersion", a.getXrayVersion)
 	g.POST("/installXray/:version", a.installXray)
+	g.POST("/xrayCore/list", a.getXrayCoreVersions)
+	g.POST("/xrayCore/upload", a.uploadXrayCore)
+	g.POST("/xrayCore/installPath", a.installXrayCorePath)
+	g.POST("/xrayCore/switch/:version", a.switchXrayCore)
+	g.POST("/xrayCore/rollback", a.rollbackXrayCore)
+	g.POST("/xrayCore/del/:version", a.delXrayCore)
 }
 
 func (a *ServerController) refreshStatus() {
@@ -59,6 +68,38 @@ func (a *ServerController) status(c *gin.Context) {
 	jsonObj(c, a.lastStatus, nil)
 }
 
+type historyForm struct {
+	From       int64 `json:"from" form:"from"`
+	To         int64 `json:"to" form:"to"`
+	Resolution int   `json:"resolution" form:"resolution"`
+}
+
+func (a *ServerController) history(c *gin.Context) {
+	form := &historyForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.getHistory"), err)
+		return
+	}
+	to := time.Now()
+	if form.To > 0 {
+		to = time.Unix(form.To, 0)
+	}
+	from := to.Add(-time.Hour)
+	if form.From > 0 {
+		from = time.Unix(form.From, 0)
+	}
+	stats, resolution, err := a.serverStatService.GetHistory(from, to, form.Resolution)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.getHistory"), err)
+		return
+	}
+	jsonObj(c, gin.H{
+		"resolution": resolution,
+		"stats":      stats,
+	}, nil)
+}
+
 func (a *ServerController) getXrayVersion(c *gin.Context) {
 	now := time.Now()
 	if now.Sub(a.lastGetVersionsTime) <= time.Minute {
@@ -66,9 +107,9 @@ func (a *ServerController) getXrayVersion(c *gin.Context) {
 		return
 	}
 
-	versions, err := a.serverService.GetXrayVersions()
+	versions, err := a.xrayCoreService.GetReleases()
 	if err != nil {
-		jsonMsg(c, "获取版本", err)
+		jsonMsg(c, localize(c, "action.getVersion"), err)
 		return
 	}
 
@@ -79,7 +120,64 @@ func (a *ServerController) getXrayVersion(c *gin.Context) {
 }
 
 func (a *ServerController) installXray(c *gin.Context) {
-	version := c.Param("version")
-	err := a.serverService.UpdateXray(version)
-	jsonMsg(c, "安装 xray", err)
+	version, err := a.xrayCoreService.InstallRelease(c.Param("version"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.installXray"), err)
+		return
+	}
+	err = a.xrayCoreService.Switch(version)
+	jsonMsgObj(c, localize(c, "action.installXray"), version, err)
+}
+
+func (a *ServerController) getXrayCoreVersions(c *gin.Context) {
+	versions, err := a.xrayCoreService.GetVersions()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, versions, nil)
+}
+
+// afterInstall 离线安装后按表单中的 switch 决定是否立即切换到新安装的版本
+func (a *ServerController) afterInstall(c *gin.Context, version string, err error) {
+	if err == nil && c.PostForm("switch") == "true" {
+		err = a.xrayCoreService.Switch(version)
+	}
+	jsonMsgObj(c, localize(c, "action.installXray"), version, err)
+}
+
+func (a *ServerController) uploadXrayCore(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		jsonMsg(c, localize(c, "action.installXray"), err)
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.installXray"), err)
+		return
+	}
+	defer file.Close()
+	version, err := a.xrayCoreService.InstallZip(file, header.Size, c.PostForm("sha256"))
+	a.afterInstall(c, version, err)
+}
+
+func (a *ServerController) installXrayCorePath(c *gin.Context) {
+	version, err := a.xrayCoreService.InstallPath(c.PostForm("path"), c.PostForm("sha256"))
+	a.afterInstall(c, version, err)
+}
+
+func (a *ServerController) switchXrayCore(c *gin.Context) {
+	err := a.xrayCoreService.Switch(c.Param("version"))
+	jsonMsg(c, localize(c, "action.switchXray"), err)
+}
+
+func (a *ServerController) rollbackXrayCore(c *gin.Context) {
+	version, err := a.xrayCoreService.Rollback()
+	jsonMsgObj(c, localize(c, "action.rollback"), version, err)
+}
+
+func (a *ServerController) delXrayCore(c *gin.Context) {
+	err := a.xrayCoreService.DelVersion(c.Param("version"))
+	jsonMsg(c, localize(c, "action.delete"), err)
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"time"
+	"x-ui/logger"
 	"x-ui/web/entity"
 	"x-ui/web/service"
 	"x-ui/web/session"
@@ -17,9 +18,15 @@ type updateUserForm struct {
 }
 
 type SettingController struct {
-	settingService service.SettingService
-	userService    service.UserService
-	panelService   service.PanelService
+	settingService  service.SettingService
+	userService     service.UserService
+	panelService    service.PanelService
+	telegramService service.TelegramService
+	sshWatchService service.SSHWatchService
+	decoyService    service.DecoyService
+	xrayService     service.XrayService
+	addressService  service.AddressService
+	geoService      service.GeoService
 }
 
 func NewSettingController(g *gin.RouterGroup) *SettingController {
@@ -35,12 +42,16 @@ func (a *SettingController) initRouter(g *gin.RouterGroup) {
 	g.POST("/update", a.updateSetting)
 	g.POST("/updateUser", a.updateUser)
 	g.POST("/restartPanel", a.restartPanel)
+	g.POST("/publicAddress", a.getPublicAddress)
+	g.POST("/decoy/info", a.getDecoyInfo)
+	g.POST("/decoy/template", a.applyDecoyTemplate)
+	g.POST("/decoy/upload", a.uploadDecoySite)
 }
 
 func (a *SettingController) getAllSetting(c *gin.Context) {
 	allSetting, err := a.settingService.GetAllSetting()
 	if err != nil {
-		jsonMsg(c, "获取设置", err)
+		jsonMsg(c, localize(c, "action.getSetting"), err)
 		return
 	}
 	jsonObj(c, allSetting, nil)
@@ -50,27 +61,91 @@ func (a *SettingController) updateSetting(c *gin.Context) {
 	allSetting := &entity.AllSetting{}
 	err := c.ShouldBind(allSetting)
 	if err != nil {
-		jsonMsg(c, "修改设置", err)
+		jsonMsg(c, localize(c, "action.updateSetting"), err)
 		return
 	}
-	err = a.settingService.UpdateAllSetting(allSetting)
-	jsonMsg(c, "修改设置", err)
+	oldSetting, err := a.settingService.GetAllSetting()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.updateSetting"), err)
+		return
+	}
+	// 路由规则中引用的 geo 文件或分类不存在时 xray 无法启动，修改模版时先检查
+	if oldSetting.XrayTemplateConfig != allSetting.XrayTemplateConfig {
+		err = a.geoService.CheckXrayTemplate(allSetting.XrayTemplateConfig)
+	}
+	if err == nil {
+		err = a.settingService.UpdateAllSetting(allSetting)
+	}
+	if err == nil && tgBotSettingChanged(oldSetting, allSetting) {
+		go func() {
+			if err := a.telegramService.Reload(); err != nil {
+				logger.Warning("reload telegram bot failed:", err)
+			}
+		}()
+	}
+	if err == nil && sshWatchSettingChanged(oldSetting, allSetting) {
+		if err := a.sshWatchService.Reload(); err != nil {
+			logger.Warning("reload ssh login watcher failed:", err)
+		}
+	}
+	if err == nil && publicAddressSettingChanged(oldSetting, allSetting) {
+		a.addressService.ClearCache()
+	}
+	if err == nil && decoySettingChanged(oldSetting, allSetting) {
+		if err := a.decoyService.Reload(); err != nil {
+			logger.Warning("reload decoy site failed:", err)
+		}
+		a.xrayService.SetToNeedRestart()
+	}
+	jsonMsg(c, localize(c, "action.updateSetting"), err)
+}
+
+// sshWatchSettingChanged 日志来源变化时需要重新启动 SSH 登录监听，其他选项每次使用时读取
+func sshWatchSettingChanged(old *entity.AllSetting, new *entity.AllSetting) bool {
+	return old.SSHWatchEnable != new.SSHWatchEnable ||
+		old.SSHLogSource != new.SSHLogSource ||
+		old.SSHLogPath != new.SSHLogPath
+}
+
+// publicAddressSettingChanged 公网地址设置变化时清除自动获取的地址缓存
+func publicAddressSettingChanged(old *entity.AllSetting, new *entity.AllSetting) bool {
+	return old.PublicAddressV4 != new.PublicAddressV4 ||
+		old.PublicAddressV6 != new.PublicAddressV6 ||
+		old.PublicAddressDetect != new.PublicAddressDetect
+}
+
+// decoySettingChanged 伪装站点的设置变化时需要重新启动伪装站点，并重新生成 xray 配置中的默认回落
+func decoySettingChanged(old *entity.AllSetting, new *entity.AllSetting) bool {
+	return old.DecoyEnable != new.DecoyEnable ||
+		old.DecoyPort != new.DecoyPort ||
+		old.DecoyFallback != new.DecoyFallback ||
+		old.DecoyPanelProxy != new.DecoyPanelProxy ||
+		old.WebBasePath != new.WebBasePath
+}
+
+// tgBotSettingChanged 机器人的启用状态、Token 或接收消息方式变化时需要重新启动机器人
+func tgBotSettingChanged(old *entity.AllSetting, new *entity.AllSetting) bool {
+	return old.TgBotEnable != new.TgBotEnable ||
+		old.TgBotToken != new.TgBotToken ||
+		old.TgBotMode != new.TgBotMode ||
+		old.TgBotApiServer != new.TgBotApiServer ||
+		old.TgWebhookUrl != new.TgWebhookUrl
 }
 
 func (a *SettingController) updateUser(c *gin.Context) {
 	form := &updateUserForm{}
 	err := c.ShouldBind(form)
 	if err != nil {
-		jsonMsg(c, "修改用户", err)
+		jsonMsg(c, localize(c, "action.updateUser"), err)
 		return
 	}
 	user := session.GetLoginUser(c)
 	if user.Username != form.OldUsername || user.Password != form.OldPassword {
-		jsonMsg(c, "修改用户", errors.New("原用户名或原密码错误"))
+		jsonMsg(c, localize(c, "action.updateUser"), errors.New(localize(c, "msg.wrongOldCredentials")))
 		return
 	}
 	if form.NewUsername == "" || form.NewPassword == "" {
-		jsonMsg(c, "修改用户", errors.New("新用户名和新密码不能为空"))
+		jsonMsg(c, localize(c, "action.updateUser"), errors.New(localize(c, "msg.emptyNewCredentials")))
 		return
 	}
 	err = a.userService.UpdateUser(user.Id, form.NewUsername, form.NewPassword)
@@ -79,10 +154,55 @@ func (a *SettingController) updateUser(c *gin.Context) {
 		user.Password = form.NewPassword
 		session.SetLoginUser(c, user)
 	}
-	jsonMsg(c, "修改用户", err)
+	jsonMsg(c, localize(c, "action.updateUser"), err)
 }
 
 func (a *SettingController) restartPanel(c *gin.Context) {
 	err := a.panelService.RestartPanel(time.Second * 3)
-	jsonMsg(c, "重启面板", err)
+	jsonMsg(c, localize(c, "action.restartPanel"), err)
+}
+
+func (a *SettingController) getPublicAddress(c *gin.Context) {
+	jsonObj(c, gin.H{
+		"v4": a.addressService.GetPublicAddressV4(),
+		"v6": a.addressService.GetPublicAddressV6(),
+	}, nil)
+}
+
+func (a *SettingController) getDecoyInfo(c *gin.Context) {
+	templates, err := a.decoyService.GetTemplates()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	template, err := a.settingService.GetDecoyTemplate()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, gin.H{
+		"template":  template,
+		"templates": templates,
+	}, nil)
+}
+
+func (a *SettingController) applyDecoyTemplate(c *gin.Context) {
+	err := a.decoyService.ApplyTemplate(c.PostForm("name"))
+	jsonMsg(c, localize(c, "action.decoyTemplate"), err)
+}
+
+func (a *SettingController) uploadDecoySite(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		jsonMsg(c, localize(c, "action.uploadDecoy"), err)
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.uploadDecoy"), err)
+		return
+	}
+	defer file.Close()
+	err = a.decoyService.UploadSite(file, header.Size)
+	jsonMsg(c, localize(c, "action.uploadDecoy"), err)
 }
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"x-ui/logger"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubController struct {
+	subscriptionService service.SubscriptionService
+	settingService      service.SettingService
+}
+
+func NewSubController(g *gin.RouterGroup) *SubController {
+	a := &SubController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *SubController) initRouter(g *gin.RouterGroup) {
+	g.GET("/sub/:token", a.sub)
+}
+
+func (a *SubController) sub(c *gin.Context) {
+	enable, err := a.settingService.GetSubEnable()
+	if err != nil || !enable {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	sub, err := a.subscriptionService.GetSubscription(c.Param("token"))
+	if err != nil {
+		logger.Debug("get subscription failed:", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Header("Subscription-Userinfo", sub.UserInfo())
+	c.Header("Profile-Update-Interval", "12")
+	// format 为 xray、clash 或 singbox 时返回完整的客户端配置，否则返回 base64 编码的分享链接
+	if format := c.Query("format"); format != "" {
+		contentType, _, err := service.ClientConfigContentType(format)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		data, err := sub.ClientConfig(format)
+		if err != nil {
+			logger.Debug("generate subscription config failed:", err)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+	content := base64.StdEncoding.EncodeToString([]byte(strings.Join(sub.Links, "\n")))
+	c.String(http.StatusOK, content)
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"net/http"
+	"x-ui/logger"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TgBotController struct {
+	telegramService service.TelegramService
+}
+
+func NewTgBotController(g *gin.RouterGroup) *TgBotController {
+	a := &TgBotController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *TgBotController) initRouter(g *gin.RouterGroup) {
+	g.POST("/tgbot/:secret", a.webhook)
+}
+
+func (a *TgBotController) webhook(c *gin.Context) {
+	err := a.telegramService.HandleWebhook(c.Param("secret"), c.Request)
+	if err != nil {
+		logger.Debug("handle telegram webhook failed:", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusOK)
+}
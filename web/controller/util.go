@@ -1,13 +1,15 @@
 package controller
 
 import (
-	"github.com/gin-gonic/gin"
-	"net"
 	"net/http"
 	"strings"
 	"x-ui/config"
 	"x-ui/logger"
 	"x-ui/web/entity"
+	"x-ui/web/locale"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
 func getUriId(c *gin.Context) int64 {
@@ -19,16 +21,24 @@ func getUriId(c *gin.Context) int64 {
 	return s.Id
 }
 
+// getRemoteIp 返回访问者的 IP，与面板的 IP 封禁使用同一来源，只在通过伪装站点转发时才读取 X-Forwarded-For
 func getRemoteIp(c *gin.Context) string {
-	value := c.GetHeader("X-Forwarded-For")
-	if value != "" {
-		ips := strings.Split(value, ",")
-		return ips[0]
-	} else {
-		addr := c.Request.RemoteAddr
-		ip, _, _ := net.SplitHostPort(addr)
-		return ip
+	return c.ClientIP()
+}
+
+// localize 按请求的 Accept-Language 翻译 key，params 为成对的参数名和值
+func localize(c *gin.Context, key string, params ...interface{}) string {
+	localizer, _ := c.Value("localizer").(*i18n.Localizer)
+	return locale.Localize(localizer, key, params...)
+}
+
+// localizeError 按请求的语言翻译 service 返回的 locale.Error，其他错误原样返回
+func localizeError(c *gin.Context, err error) string {
+	if localeErr, ok := err.(*locale.Error); ok {
+		localizer, _ := c.Value("localizer").(*i18n.Localizer)
+		return localeErr.Localize(localizer)
 	}
+	return err.Error()
 }
 
 func jsonMsg(c *gin.Context, msg string, err error) {
@@ -46,12 +56,12 @@ func jsonMsgObj(c *gin.Context, msg string, obj interface{}, err error) {
 	if err == nil {
 		m.Success = true
 		if msg != "" {
-			m.Msg = msg + "成功"
+			m.Msg = localize(c, "msg.success", "Action", msg)
 		}
 	} else {
 		m.Success = false
-		m.Msg = msg + "失败: " + err.Error()
-		logger.Warning(msg+"失败: ", err)
+		m.Msg = strings.TrimSpace(localize(c, "msg.fail", "Action", msg, "Error", localizeError(c, err)))
+		logger.Warning(m.Msg)
 	}
 	c.JSON(http.StatusOK, m)
 }
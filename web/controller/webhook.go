@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"strconv"
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookController struct {
+	webhookService service.WebhookService
+}
+
+func NewWebhookController(g *gin.RouterGroup) *WebhookController {
+	a := &WebhookController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *WebhookController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/webhook")
+
+	g.POST("/endpoints", a.getEndpoints)
+	g.POST("/endpoint/add", a.addEndpoint)
+	g.POST("/endpoint/update/:id", a.updateEndpoint)
+	g.POST("/endpoint/del/:id", a.delEndpoint)
+	g.POST("/endpoint/test", a.testEndpoint)
+	g.POST("/deliveries", a.getDeliveries)
+	g.POST("/delivery/replay/:id", a.replayDelivery)
+}
+
+func (a *WebhookController) getEndpoints(c *gin.Context) {
+	endpoints, err := a.webhookService.GetEndpoints()
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, endpoints, nil)
+}
+
+func (a *WebhookController) addEndpoint(c *gin.Context) {
+	endpoint := &model.WebhookEndpoint{}
+	err := c.ShouldBind(endpoint)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.add"), err)
+		return
+	}
+	endpoint.Id = 0
+	err = a.webhookService.AddEndpoint(endpoint)
+	jsonMsg(c, localize(c, "action.add"), err)
+}
+
+func (a *WebhookController) updateEndpoint(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	endpoint := &model.WebhookEndpoint{}
+	err = c.ShouldBind(endpoint)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.update"), err)
+		return
+	}
+	endpoint.Id = id
+	err = a.webhookService.UpdateEndpoint(endpoint)
+	jsonMsg(c, localize(c, "action.update"), err)
+}
+
+func (a *WebhookController) delEndpoint(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.delete"), err)
+		return
+	}
+	err = a.webhookService.DelEndpoint(id)
+	jsonMsg(c, localize(c, "action.delete"), err)
+}
+
+func (a *WebhookController) testEndpoint(c *gin.Context) {
+	endpoint := &model.WebhookEndpoint{}
+	err := c.ShouldBind(endpoint)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.test"), err)
+		return
+	}
+	err = a.webhookService.TestEndpoint(endpoint)
+	jsonMsg(c, localize(c, "action.test"), err)
+}
+
+type deliveryForm struct {
+	EndpointId int    `form:"endpointId"`
+	Status     string `form:"status"`
+}
+
+func (a *WebhookController) getDeliveries(c *gin.Context) {
+	form := &deliveryForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	deliveries, err := a.webhookService.GetDeliveries(form.EndpointId, form.Status, 500)
+	if err != nil {
+		jsonMsg(c, localize(c, "action.get"), err)
+		return
+	}
+	jsonObj(c, deliveries, nil)
+}
+
+func (a *WebhookController) replayDelivery(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, localize(c, "action.replay"), err)
+		return
+	}
+	err = a.webhookService.Replay(id)
+	jsonMsg(c, localize(c, "action.replay"), err)
+}
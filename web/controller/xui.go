@@ -9,6 +9,12 @@ type XUIController struct {
 
 	inboundController *InboundController
 	settingController *SettingController
+	alertController   *AlertController
+	notifyController  *NotifyController
+	webhookController *WebhookController
+	ipBlockController *IpBlockController
+	nodeController    *NodeController
+	geoController     *GeoController
 }
 
 func NewXUIController(g *gin.RouterGroup) *XUIController {
@@ -24,9 +30,19 @@ func (a *XUIController) initRouter(g *gin.RouterGroup) {
 	g.GET("/", a.index)
 	g.GET("/inbounds", a.inbounds)
 	g.GET("/setting", a.setting)
+	g.GET("/alerts", a.alerts)
+	g.GET("/webhooks", a.webhooks)
+	g.GET("/nodes", a.nodes)
+	g.GET("/geo", a.geo)
 
 	a.inboundController = NewInboundController(g)
 	a.settingController = NewSettingController(g)
+	a.alertController = NewAlertController(g)
+	a.notifyController = NewNotifyController(g)
+	a.webhookController = NewWebhookController(g)
+	a.ipBlockController = NewIpBlockController(g)
+	a.nodeController = NewNodeController(g)
+	a.geoController = NewGeoController(g)
 }
 
 func (a *XUIController) index(c *gin.Context) {
@@ -40,3 +56,19 @@ func (a *XUIController) inbounds(c *gin.Context) {
 func (a *XUIController) setting(c *gin.Context) {
 	html(c, "setting.html", "设置", nil)
 }
+
+func (a *XUIController) alerts(c *gin.Context) {
+	html(c, "alerts.html", "告警", nil)
+}
+
+func (a *XUIController) webhooks(c *gin.Context) {
+	html(c, "webhooks.html", "Webhook", nil)
+}
+
+func (a *XUIController) nodes(c *gin.Context) {
+	html(c, "nodes.html", "节点管理", nil)
+}
+
+func (a *XUIController) geo(c *gin.Context) {
+	html(c, "geo.html", "geo 文件", nil)
+}
@@ -4,9 +4,12 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 	"x-ui/util/common"
+	"x-ui/web/locale"
 	"x-ui/xray"
 )
 
@@ -17,30 +20,91 @@ type Msg struct {
 }
 
 type Pager struct {
-	Current  int         `json:"current"`
-	PageSize int         `json:"page_size"`
+	Current  int         `json:"current" form:"current"`
+	PageSize int         `json:"page_size" form:"page_size"`
 	Total    int         `json:"total"`
-	OrderBy  string      `json:"order_by"`
-	Desc     bool        `json:"desc"`
-	Key      string      `json:"key"`
+	OrderBy  string      `json:"order_by" form:"order_by"`
+	Desc     bool        `json:"desc" form:"desc"`
+	Key      string      `json:"key" form:"key"`
 	List     interface{} `json:"list"`
 }
 
+// InboundQuery 是入站分页列表的查询条件，Key 匹配备注、端口、协议、tag 和用户 email
+type InboundQuery struct {
+	Pager
+	// enabled、disabled、expired、expiring、over_quota，为空时不筛选
+	Status string `json:"status" form:"status"`
+	Group  string `json:"group" form:"group"`
+	// 筛选即将到期时的天数
+	ExpiringDays int `json:"expiring_days" form:"expiring_days"`
+}
+
+// InboundBatch 是批量操作入站的请求，All 为 true 时选择全部入站，否则 Ids 为空时选择 Group 分组中的全部入站
+type InboundBatch struct {
+	All    bool   `json:"all" form:"all"`
+	Ids    []int  `json:"ids" form:"ids"`
+	Group  string `json:"group" form:"group"`
+	Action string `json:"action" form:"action"`
+	// 延长到期时间的天数
+	Days int `json:"days" form:"days"`
+	// 新的总流量，0 表示不限
+	Total int64 `json:"total" form:"total"`
+	// 移动到的分组
+	NewGroup string `json:"newGroup" form:"newGroup"`
+}
+
 type AllSetting struct {
-	WebListen          string `json:"webListen" form:"webListen"`
-	WebPort            int    `json:"webPort" form:"webPort"`
-	WebCertFile        string `json:"webCertFile" form:"webCertFile"`
-	WebKeyFile         string `json:"webKeyFile" form:"webKeyFile"`
-	WebBasePath        string `json:"webBasePath" form:"webBasePath"`
-	TgBotEnable        bool   `json:"tgBotEnable" form:"tgBotEnable"`
-	TgBotToken         string `json:"tgBotToken" form:"tgBotToken"`
-	TgBotChatId        int    `json:"tgBotChatId" form:"tgBotChatId"`
-	TgRunTime          string `json:"tgRunTime" form:"tgRunTime"`
-	XrayTemplateConfig string `json:"xrayTemplateConfig" form:"xrayTemplateConfig"`
+	WebListen           string `json:"webListen" form:"webListen"`
+	WebPort             int    `json:"webPort" form:"webPort"`
+	WebCertFile         string `json:"webCertFile" form:"webCertFile"`
+	WebKeyFile          string `json:"webKeyFile" form:"webKeyFile"`
+	WebBasePath         string `json:"webBasePath" form:"webBasePath"`
+	TgBotEnable         bool   `json:"tgBotEnable" form:"tgBotEnable"`
+	TgBotToken          string `json:"tgBotToken" form:"tgBotToken"`
+	TgBotChatId         int    `json:"tgBotChatId" form:"tgBotChatId"`
+	TgRunTime           string `json:"tgRunTime" form:"tgRunTime"`
+	TgBotAdmins         string `json:"tgBotAdmins" form:"tgBotAdmins"`
+	TgLang              string `json:"tgLang" form:"tgLang"`
+	TgBotMode           string `json:"tgBotMode" form:"tgBotMode"`
+	TgBotApiServer      string `json:"tgBotApiServer" form:"tgBotApiServer"`
+	TgWebhookUrl        string `json:"tgWebhookUrl" form:"tgWebhookUrl"`
+	TgClientBotEnable   bool   `json:"tgClientBotEnable" form:"tgClientBotEnable"`
+	TgClientWarnPercent int    `json:"tgClientWarnPercent" form:"tgClientWarnPercent"`
+	TgClientWarnDays    int    `json:"tgClientWarnDays" form:"tgClientWarnDays"`
+	SubEnable           bool   `json:"subEnable" form:"subEnable"`
+	SubURI              string `json:"subURI" form:"subURI"`
+	XrayTemplateConfig  string `json:"xrayTemplateConfig" form:"xrayTemplateConfig"`
+	MetricsEnable       bool   `json:"metricsEnable" form:"metricsEnable"`
+	MetricsToken        string `json:"metricsToken" form:"metricsToken"`
+	MetricsUsername     string `json:"metricsUsername" form:"metricsUsername"`
+	MetricsPassword     string `json:"metricsPassword" form:"metricsPassword"`
+	SSHWatchEnable      bool   `json:"sshWatchEnable" form:"sshWatchEnable"`
+	SSHLogSource        string `json:"sshLogSource" form:"sshLogSource"`
+	SSHLogPath          string `json:"sshLogPath" form:"sshLogPath"`
+	SSHBruteThreshold   int    `json:"sshBruteThreshold" form:"sshBruteThreshold"`
+	SSHBruteWindow      int    `json:"sshBruteWindow" form:"sshBruteWindow"`
+	SSHAutoBlock        bool   `json:"sshAutoBlock" form:"sshAutoBlock"`
+	SSHBlockDuration    int    `json:"sshBlockDuration" form:"sshBlockDuration"`
+	PortRange           string `json:"portRange" form:"portRange"`
+	PortExclude         string `json:"portExclude" form:"portExclude"`
+	DecoyEnable         bool   `json:"decoyEnable" form:"decoyEnable"`
+	DecoyPort           int    `json:"decoyPort" form:"decoyPort"`
+	DecoyFallback       bool   `json:"decoyFallback" form:"decoyFallback"`
+	DecoyPanelProxy     bool   `json:"decoyPanelProxy" form:"decoyPanelProxy"`
+	PublicAddressV4     string `json:"publicAddressV4" form:"publicAddressV4"`
+	PublicAddressV6     string `json:"publicAddressV6" form:"publicAddressV6"`
+	PublicAddressDetect bool   `json:"publicAddressDetect" form:"publicAddressDetect"`
+	NodeAgentEnable     bool   `json:"nodeAgentEnable" form:"nodeAgentEnable"`
+	NodeAgentToken      string `json:"nodeAgentToken" form:"nodeAgentToken"`
+	XrayDownloadMirror  string `json:"xrayDownloadMirror" form:"xrayDownloadMirror"`
+	XrayDownloadProxy   string `json:"xrayDownloadProxy" form:"xrayDownloadProxy"`
+	GeoUpdateInterval   int    `json:"geoUpdateInterval" form:"geoUpdateInterval"`
 
 	TimeLocation string `json:"timeLocation" form:"timeLocation"`
 }
 
+var tgWebhookPorts = map[int]bool{443: true, 80: true, 88: true, 8443: true}
+
 func (s *AllSetting) CheckValid() error {
 	if s.WebListen != "" {
 		ip := net.ParseIP(s.WebListen)
@@ -67,12 +131,154 @@ func (s *AllSetting) CheckValid() error {
 		s.WebBasePath += "/"
 	}
 
+	_, err := ParseTgBotAdmins(s.TgBotAdmins)
+	if err != nil {
+		return err
+	}
+
+	if !locale.IsSupported(s.TgLang) {
+		return common.NewError("telegram bot language not supported:", s.TgLang)
+	}
+
+	switch s.TgBotMode {
+	case "polling":
+	case "webhook":
+		// Telegram 只接受 https 回调地址
+		if s.TgWebhookUrl == "" && s.WebCertFile == "" {
+			return common.NewError("telegram webhook mode requires panel https or a webhook url")
+		}
+	default:
+		return common.NewError("telegram bot mode not supported:", s.TgBotMode)
+	}
+	if s.TgBotApiServer != "" && !strings.HasPrefix(s.TgBotApiServer, "http://") && !strings.HasPrefix(s.TgBotApiServer, "https://") {
+		return common.NewError("telegram bot api server should start with http:// or https://:", s.TgBotApiServer)
+	}
+	if s.TgWebhookUrl != "" {
+		if !strings.HasPrefix(s.TgWebhookUrl, "https://") {
+			return common.NewError("telegram webhook url should start with https://:", s.TgWebhookUrl)
+		}
+		if !strings.HasSuffix(s.TgWebhookUrl, "/") {
+			s.TgWebhookUrl += "/"
+		}
+	}
+	// 官方 Bot API 只会回调 443、80、88、8443 端口，自建的 Bot API 服务没有限制
+	if s.TgBotMode == "webhook" && s.TgBotApiServer == "" {
+		port := s.WebPort
+		if s.TgWebhookUrl != "" {
+			u, err := url.Parse(s.TgWebhookUrl)
+			if err != nil {
+				return common.NewError("telegram webhook url is not valid:", s.TgWebhookUrl)
+			}
+			port = 443
+			if u.Port() != "" {
+				port, _ = strconv.Atoi(u.Port())
+			}
+		}
+		if !tgWebhookPorts[port] {
+			return common.NewError("telegram webhook only supports port 443, 80, 88 or 8443, please set a webhook url, got port:", port)
+		}
+	}
+
+	if s.TgClientWarnPercent < 0 || s.TgClientWarnPercent > 100 {
+		return common.NewError("client warn percent should be between 0 and 100:", s.TgClientWarnPercent)
+	}
+	if s.TgClientWarnDays < 0 {
+		return common.NewError("client warn days can not be negative:", s.TgClientWarnDays)
+	}
+
+	if s.SubURI != "" {
+		if !strings.HasPrefix(s.SubURI, "http://") && !strings.HasPrefix(s.SubURI, "https://") {
+			return common.NewError("subscription uri should start with http:// or https://:", s.SubURI)
+		}
+		if !strings.HasSuffix(s.SubURI, "/") {
+			s.SubURI += "/"
+		}
+	}
+
+	if s.MetricsEnable && s.MetricsToken == "" && (s.MetricsUsername == "" || s.MetricsPassword == "") {
+		return common.NewError("metrics enabled but neither token nor basic auth is set")
+	}
+
+	switch s.SSHLogSource {
+	case "auto", "journald", "file":
+	default:
+		return common.NewError("ssh log source not supported:", s.SSHLogSource)
+	}
+	if s.SSHBruteThreshold < 0 {
+		return common.NewError("ssh brute force threshold can not be negative:", s.SSHBruteThreshold)
+	}
+	if s.SSHBruteWindow <= 0 {
+		return common.NewError("ssh brute force window should be positive:", s.SSHBruteWindow)
+	}
+	if s.SSHBlockDuration < 0 {
+		return common.NewError("ssh block duration can not be negative:", s.SSHBlockDuration)
+	}
+
+	ranges, err := ParsePortRanges(s.PortRange)
+	if err != nil {
+		return err
+	}
+	if len(ranges) == 0 {
+		return common.NewError("port range can not be empty")
+	}
+	_, err = ParsePortRanges(s.PortExclude)
+	if err != nil {
+		return err
+	}
+
+	if s.DecoyPort <= 0 || s.DecoyPort > 65535 {
+		return common.NewError("decoy site port is not a valid port:", s.DecoyPort)
+	}
+	if s.DecoyEnable && s.DecoyPort == s.WebPort {
+		return common.NewError("decoy site port can not be the same as web port:", s.DecoyPort)
+	}
+	// 面板在伪装站点上只通过根路径区分，根路径为 / 时会覆盖整个站点
+	if s.DecoyPanelProxy && s.WebBasePath == "/" {
+		return common.NewError("decoy panel proxy requires a web base path other than /")
+	}
+
 	xrayConfig := &xray.Config{}
-	err := json.Unmarshal([]byte(s.XrayTemplateConfig), xrayConfig)
+	err = json.Unmarshal([]byte(s.XrayTemplateConfig), xrayConfig)
 	if err != nil {
 		return common.NewError("xray template config invalid:", err)
 	}
 
+	err = checkPublicAddress(s.PublicAddressV4, false)
+	if err != nil {
+		return err
+	}
+	err = checkPublicAddress(s.PublicAddressV6, true)
+	if err != nil {
+		return err
+	}
+
+	// 节点接口可以修改入站和重启 xray，token 不能太短
+	if s.NodeAgentEnable && len(s.NodeAgentToken) < 16 {
+		return common.NewError("node agent token should be at least 16 characters")
+	}
+
+	if s.XrayDownloadMirror != "" {
+		u, err := url.Parse(s.XrayDownloadMirror)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return common.NewError("xray download mirror should be an http or https url:", s.XrayDownloadMirror)
+		}
+	}
+	if s.XrayDownloadProxy != "" {
+		u, err := url.Parse(s.XrayDownloadProxy)
+		if err != nil || u.Host == "" {
+			return common.NewError("xray download proxy is not a valid url:", s.XrayDownloadProxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return common.NewError("xray download proxy scheme not supported:", u.Scheme)
+		}
+	}
+
+	if s.GeoUpdateInterval < 0 {
+		return common.NewError("geo update interval can not be negative:", s.GeoUpdateInterval)
+	}
+
 	_, err = time.LoadLocation(s.TimeLocation)
 	if err != nil {
 		return common.NewError("time location not exist:", s.TimeLocation)
@@ -80,3 +286,98 @@ func (s *AllSetting) CheckValid() error {
 
 	return nil
 }
+
+// TgBotAdmins 记录每个 chat id 或 user id 允许执行的命令，"*" 表示全部命令
+type TgBotAdmins map[int64]map[string]bool
+
+// ParseTgBotAdmins 解析机器人管理员列表，每行一条，格式为 "id: 命令1,命令2" 或 "id: *"
+func ParseTgBotAdmins(str string) (TgBotAdmins, error) {
+	admins := TgBotAdmins{}
+	for _, line := range strings.Split(str, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, common.NewError("telegram bot admin invalid, should be \"id: commands\":", line)
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+		if err != nil || id == 0 {
+			return nil, common.NewError("telegram bot admin id invalid:", line)
+		}
+		commands := admins[id]
+		if commands == nil {
+			commands = map[string]bool{}
+			admins[id] = commands
+		}
+		for _, command := range strings.Split(parts[1], ",") {
+			command = strings.TrimPrefix(strings.TrimSpace(command), "/")
+			if command != "" {
+				commands[command] = true
+			}
+		}
+	}
+	return admins, nil
+}
+
+// Allow 判断 id 是否被允许执行 command
+func (a TgBotAdmins) Allow(id int64, command string) bool {
+	commands := a[id]
+	return commands["*"] || commands[command]
+}
+
+// PortRange 是闭区间的端口范围
+type PortRange struct {
+	From int
+	To   int
+}
+
+func (r PortRange) Contains(port int) bool {
+	return port >= r.From && port <= r.To
+}
+
+// ParsePortRanges 解析逗号分隔的端口和端口范围，如 "443,10000-20000"
+func ParsePortRanges(str string) ([]PortRange, error) {
+	ranges := make([]PortRange, 0)
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			from, to = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		r := PortRange{}
+		var err1, err2 error
+		r.From, err1 = strconv.Atoi(from)
+		r.To, err2 = strconv.Atoi(to)
+		if err1 != nil || err2 != nil || r.From < 1 || r.To > 65535 || r.From > r.To {
+			return nil, common.NewError("port range invalid:", part)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// checkPublicAddress 公网地址可以是对应协议族的 IP 或域名
+func checkPublicAddress(address string, ipv6 bool) error {
+	if address == "" {
+		return nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		if !common.IsDomain(address) {
+			return common.NewError("public address should be an ip or domain without scheme and port:", address)
+		}
+		return nil
+	}
+	if (ip.To4() == nil) != ipv6 {
+		if ipv6 {
+			return common.NewError("public ipv6 address is not an ipv6 address:", address)
+		}
+		return common.NewError("public ipv4 address is not an ipv4 address:", address)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package job
+
+import "x-ui/web/service"
+
+type AlertJob struct {
+	alertService service.AlertService
+}
+
+func NewAlertJob() *AlertJob {
+	return new(AlertJob)
+}
+
+func (j *AlertJob) Run() {
+	j.alertService.Evaluate()
+}
@@ -8,6 +8,7 @@ import (
 type CheckInboundJob struct {
 	xrayService    service.XrayService
 	inboundService service.InboundService
+	notifyService  service.NotifyService
 }
 
 func NewCheckInboundJob() *CheckInboundJob {
@@ -22,4 +23,15 @@ func (j *CheckInboundJob) Run() {
 		logger.Debugf("disabled %v inbounds", count)
 		j.xrayService.SetToNeedRestart()
 	}
+
+	inbounds, needRestart, err := j.inboundService.CheckOverQuota()
+	if err != nil {
+		logger.Warning("check over quota inbounds err:", err)
+	}
+	for _, inbound := range inbounds {
+		j.notifyService.NotifyOverQuota(inbound)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
 }
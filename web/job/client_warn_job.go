@@ -0,0 +1,15 @@
+package job
+
+import "x-ui/web/service"
+
+type ClientWarnJob struct {
+	telegramService service.TelegramService
+}
+
+func NewClientWarnJob() *ClientWarnJob {
+	return new(ClientWarnJob)
+}
+
+func (j *ClientWarnJob) Run() {
+	j.telegramService.CheckClientWarnings()
+}
@@ -0,0 +1,21 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type GeoUpdateJob struct {
+	geoService service.GeoService
+}
+
+func NewGeoUpdateJob() *GeoUpdateJob {
+	return new(GeoUpdateJob)
+}
+
+func (j *GeoUpdateJob) Run() {
+	err := j.geoService.AutoUpdate()
+	if err != nil {
+		logger.Warning("auto update geo files failed:", err)
+	}
+}
@@ -0,0 +1,25 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type NodeSyncJob struct {
+	nodeService service.NodeService
+	xrayService service.XrayService
+}
+
+func NewNodeSyncJob() *NodeSyncJob {
+	return new(NodeSyncJob)
+}
+
+func (j *NodeSyncJob) Run() {
+	needRestart, err := j.nodeService.SyncClients()
+	if err != nil {
+		logger.Warning("sync node clients failed:", err)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,37 @@
+package job
+
+import (
+	"time"
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type ServerStatJob struct {
+	serverStatService service.ServerStatService
+}
+
+func NewServerStatJob() *ServerStatJob {
+	return new(ServerStatJob)
+}
+
+func (j *ServerStatJob) Run() {
+	j.serverStatService.Sample()
+}
+
+type ServerStatRollupJob struct {
+	serverStatService service.ServerStatService
+	resolution        int
+}
+
+func NewServerStatRollupJob(resolution int) *ServerStatRollupJob {
+	return &ServerStatRollupJob{
+		resolution: resolution,
+	}
+}
+
+func (j *ServerStatRollupJob) Run() {
+	err := j.serverStatService.Rollup(j.resolution, time.Now())
+	if err != nil {
+		logger.Warningf("rollup server stat with resolution %vs failed: %v", j.resolution, err)
+	}
+}
@@ -0,0 +1,17 @@
+package job
+
+import "x-ui/web/service"
+
+type SSHLoginJob struct {
+	sshWatchService service.SSHWatchService
+	ipBlockService  service.IpBlockService
+}
+
+func NewSSHLoginJob() *SSHLoginJob {
+	return new(SSHLoginJob)
+}
+
+func (j *SSHLoginJob) Run() {
+	j.sshWatchService.Flush()
+	j.ipBlockService.CleanExpired()
+}
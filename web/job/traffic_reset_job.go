@@ -0,0 +1,26 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type TrafficResetJob struct {
+	xrayService    service.XrayService
+	inboundService service.InboundService
+}
+
+func NewTrafficResetJob() *TrafficResetJob {
+	return new(TrafficResetJob)
+}
+
+func (j *TrafficResetJob) Run() {
+	count, err := j.inboundService.ResetScheduledTraffic()
+	if err != nil {
+		logger.Warning("reset scheduled traffic err:", err)
+	}
+	if count > 0 {
+		logger.Debugf("re-enabled %v inbounds and clients after traffic reset", count)
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -18,7 +18,7 @@ func (j *XrayTrafficJob) Run() {
 	if !j.xrayService.IsXrayRunning() {
 		return
 	}
-	traffics, err := j.xrayService.GetXrayTraffic()
+	traffics, clientTraffics, err := j.xrayService.GetXrayTraffic()
 	if err != nil {
 		logger.Warning("get xray traffic failed:", err)
 		return
@@ -27,4 +27,12 @@ func (j *XrayTrafficJob) Run() {
 	if err != nil {
 		logger.Warning("add traffic failed:", err)
 	}
+	err = j.inboundService.AddClientTraffic(clientTraffics)
+	if err != nil {
+		logger.Warning("add client traffic failed:", err)
+	}
+	err = j.inboundService.ActivateExpiry(traffics, clientTraffics)
+	if err != nil {
+		logger.Warning("activate expiry failed:", err)
+	}
 }
@@ -0,0 +1,136 @@
+package locale
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"x-ui/logger"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+)
+
+// 面板支持的语言，与 web/translation 下的翻译文件对应
+var Languages = []string{"zh_Hans", "zh_Hant", "en_US"}
+
+var bundle *i18n.Bundle
+
+var botLock sync.RWMutex
+var botLocalizer *i18n.Localizer
+
+// InitBundle 加载 dir 目录下所有 toml 翻译文件
+func InitBundle(fsys fs.FS, dir string) error {
+	b := i18n.NewBundle(language.SimplifiedChinese)
+	b.RegisterUnmarshalFunc("toml", toml.Unmarshal)
+	err := fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		_, err = b.ParseMessageFileBytes(data, path)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	bundle = b
+	return nil
+}
+
+func IsSupported(lang string) bool {
+	for _, l := range Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// NewLocalizer 按优先级创建翻译器，langs 可以是 Accept-Language 头或 zh_Hans 这样的语言名
+func NewLocalizer(langs ...string) *i18n.Localizer {
+	for i := range langs {
+		langs[i] = strings.ReplaceAll(langs[i], "_", "-")
+	}
+	return i18n.NewLocalizer(bundle, langs...)
+}
+
+// SetBotLanguage 设置电报机器人回复和通知消息使用的语言
+func SetBotLanguage(lang string) {
+	localizer := NewLocalizer(lang)
+	botLock.Lock()
+	botLocalizer = localizer
+	botLock.Unlock()
+}
+
+// Localize 翻译 key，params 为成对的参数名和值，找不到翻译时返回 key 本身
+func Localize(localizer *i18n.Localizer, key string, params ...interface{}) string {
+	if bundle == nil || localizer == nil {
+		return key
+	}
+	var data map[string]interface{}
+	if len(params) > 0 {
+		data = make(map[string]interface{}, len(params)/2)
+		for i := 0; i+1 < len(params); i += 2 {
+			data[fmt.Sprint(params[i])] = params[i+1]
+		}
+	}
+	msg, err := localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    key,
+		TemplateData: data,
+	})
+	if err != nil {
+		logger.Debug("localize", key, "failed:", err)
+		return key
+	}
+	return msg
+}
+
+func getBotLocalizer() *i18n.Localizer {
+	botLock.RLock()
+	localizer := botLocalizer
+	botLock.RUnlock()
+	if localizer == nil {
+		localizer = NewLocalizer()
+	}
+	return localizer
+}
+
+// Bot 按电报机器人语言翻译 key
+func Bot(key string, params ...interface{}) string {
+	return Localize(getBotLocalizer(), key, params...)
+}
+
+// Error 是需要翻译的错误，Error() 按机器人语言翻译，接口返回前由 controller 按请求的语言翻译
+type Error struct {
+	Key    string
+	Params []interface{}
+}
+
+// NewError 创建需要翻译的错误，params 与 Localize 相同，参数也可以是 *Error
+func NewError(key string, params ...interface{}) *Error {
+	return &Error{Key: key, Params: params}
+}
+
+func (e *Error) Error() string {
+	return e.Localize(getBotLocalizer())
+}
+
+// Localize 按 localizer 翻译错误，参数中的 *Error 一并翻译
+func (e *Error) Localize(localizer *i18n.Localizer) string {
+	params := make([]interface{}, len(e.Params))
+	for i, param := range e.Params {
+		if err, ok := param.(*Error); ok {
+			param = err.Localize(localizer)
+		}
+		params[i] = param
+	}
+	return Localize(localizer, e.Key, params...)
+}
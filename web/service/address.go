@@ -0,0 +1,117 @@
+package service
+
+import (
+	"sync"
+	"time"
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+// 自动获取的地址的缓存时间，通过外部服务查询到的地址缓存较久，只从网卡获取到时过一会再重新查询
+const (
+	detectedAddressTTL  = 30 * time.Minute
+	interfaceAddressTTL = 5 * time.Minute
+)
+
+// cachedAddress 是自动获取的某个协议族的公网地址
+type cachedAddress struct {
+	lock      sync.Mutex
+	address   string
+	expiresAt time.Time
+}
+
+var cachedAddressV4 = &cachedAddress{}
+var cachedAddressV6 = &cachedAddress{}
+
+type AddressService struct {
+	settingService SettingService
+}
+
+// detect 依次通过外部服务和本机网卡获取地址，结果在有效期内复用
+func (c *cachedAddress) detect(ipv6 bool, online bool) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if time.Now().Before(c.expiresAt) {
+		return c.address
+	}
+	ttl := interfaceAddressTTL
+	address := ""
+	if online {
+		ip, err := common.DetectPublicIP(ipv6)
+		if err == nil {
+			address = ip
+			ttl = detectedAddressTTL
+		} else {
+			logger.Debug("detect public ip failed:", err)
+		}
+	}
+	if address == "" {
+		address = common.GetInterfaceIP(ipv6)
+	}
+	c.address = address
+	c.expiresAt = time.Now().Add(ttl)
+	return address
+}
+
+func (s *AddressService) getAddress(ipv6 bool) string {
+	var address string
+	var err error
+	cache := cachedAddressV4
+	if ipv6 {
+		address, err = s.settingService.GetPublicAddressV6()
+		cache = cachedAddressV6
+	} else {
+		address, err = s.settingService.GetPublicAddressV4()
+	}
+	if err != nil {
+		logger.Warning("get public address setting failed:", err)
+	}
+	if address != "" {
+		return address
+	}
+	online, err := s.settingService.GetPublicAddressDetect()
+	if err != nil {
+		logger.Warning("get public address setting failed:", err)
+	}
+	return cache.detect(ipv6, online)
+}
+
+// GetPublicAddressV4 返回 IPv4 公网地址，优先使用设置中的地址或域名，获取不到时返回空字符串
+func (s *AddressService) GetPublicAddressV4() string {
+	return s.getAddress(false)
+}
+
+// GetPublicAddressV6 返回 IPv6 公网地址，优先使用设置中的地址或域名，获取不到时返回空字符串
+func (s *AddressService) GetPublicAddressV6() string {
+	return s.getAddress(true)
+}
+
+// GetPublicAddress 返回客户端连接本机用的地址，优先使用 IPv4
+func (s *AddressService) GetPublicAddress() string {
+	if address := s.GetPublicAddressV4(); address != "" {
+		return address
+	}
+	return s.GetPublicAddressV6()
+}
+
+// GetPublicAddresses 返回用于报告中显示的所有公网地址
+func (s *AddressService) GetPublicAddresses() string {
+	v4 := s.GetPublicAddressV4()
+	v6 := s.GetPublicAddressV6()
+	switch {
+	case v4 != "" && v6 != "":
+		return v4 + " / " + v6
+	case v4 != "":
+		return v4
+	}
+	return v6
+}
+
+// ClearCache 公网地址设置变化后清除自动获取的地址，下次使用时重新获取
+func (s *AddressService) ClearCache() {
+	for _, cache := range []*cachedAddress{cachedAddressV4, cachedAddressV6} {
+		cache.lock.Lock()
+		cache.expiresAt = time.Time{}
+		cache.lock.Unlock()
+	}
+}
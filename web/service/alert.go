@@ -0,0 +1,430 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/web/locale"
+)
+
+// alertObservation 是某条规则在一次评估中对单个对象（主机、入站、用户）的观测值
+type alertObservation struct {
+	subject string
+	value   float64
+	message string
+}
+
+type alertState struct {
+	pendingSince time.Time
+	firing       *model.Alert
+	lastNotified time.Time
+}
+
+var alertLock sync.Mutex
+var alertStates = map[string]*alertState{}
+var alertStatesLoaded bool
+
+type AlertService struct {
+	inboundService InboundService
+	xrayService    XrayService
+	notifyService  NotifyService
+}
+
+func (s *AlertService) GetRules() ([]*model.AlertRule, error) {
+	db := database.GetDB()
+	rules := make([]*model.AlertRule, 0)
+	err := db.Model(model.AlertRule{}).Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *AlertService) checkRule(rule *model.AlertRule) error {
+	switch rule.Type {
+	case model.AlertCpu, model.AlertMem, model.AlertDisk,
+		model.AlertInboundQuota, model.AlertClientQuota,
+		model.AlertExpiry, model.AlertTrafficSpike:
+		if rule.Threshold <= 0 {
+			return locale.NewError("alert.invalidThreshold", "Threshold", rule.Threshold)
+		}
+	case model.AlertXrayDown:
+	default:
+		return locale.NewError("alert.unknownType", "Type", rule.Type)
+	}
+	if rule.Duration < 0 || rule.Cooldown < 0 || rule.Hysteresis < 0 {
+		return locale.NewError("alert.negativeTiming")
+	}
+	return nil
+}
+
+func (s *AlertService) AddRule(rule *model.AlertRule) error {
+	err := s.checkRule(rule)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Create(rule).Error
+}
+
+func (s *AlertService) UpdateRule(rule *model.AlertRule) error {
+	err := s.checkRule(rule)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	err = db.Save(rule).Error
+	if err != nil {
+		return err
+	}
+	if !rule.Enable {
+		// 停用的规则不再评估，触发中的告警不会自动恢复，和删除规则一样直接结束
+		return s.resolveRule(rule.Id)
+	}
+	s.forgetRule(rule.Id, false)
+	return nil
+}
+
+func (s *AlertService) DelRule(id int) error {
+	db := database.GetDB()
+	err := db.Delete(model.AlertRule{}, id).Error
+	if err != nil {
+		return err
+	}
+	return s.resolveRule(id)
+}
+
+// resolveRule 将规则所有触发中的告警标记为已恢复，并清除其内存状态
+func (s *AlertService) resolveRule(id int) error {
+	db := database.GetDB()
+	err := db.Model(model.Alert{}).
+		Where("rule_id = ? and status = ?", id, model.AlertFiring).
+		Updates(map[string]interface{}{"status": model.AlertResolved, "resolved_at": time.Now().Unix()}).Error
+	if err != nil {
+		return err
+	}
+	s.forgetRule(id, true)
+	return nil
+}
+
+// forgetRule 清除规则的内存状态，规则修改后按新条件重新评估，触发中的告警仅在删除或停用规则时清除
+func (s *AlertService) forgetRule(id int, includeFiring bool) {
+	alertLock.Lock()
+	defer alertLock.Unlock()
+	prefix := fmt.Sprintf("%d:", id)
+	for key, state := range alertStates {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix && (includeFiring || state.firing == nil) {
+			delete(alertStates, key)
+		}
+	}
+}
+
+func (s *AlertService) GetAlerts(limit int) ([]*model.Alert, error) {
+	db := database.GetDB()
+	alerts := make([]*model.Alert, 0)
+	err := db.Model(model.Alert{}).Order("status = 'firing' desc, id desc").Limit(limit).Find(&alerts).Error
+	if err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (s *AlertService) loadFiringAlerts() error {
+	if alertStatesLoaded {
+		return nil
+	}
+	db := database.GetDB()
+	alerts := make([]*model.Alert, 0)
+	err := db.Model(model.Alert{}).Where("status = ?", model.AlertFiring).Find(&alerts).Error
+	if err != nil {
+		return err
+	}
+	for _, alert := range alerts {
+		alertStates[fmt.Sprintf("%d:%s", alert.RuleId, alert.Subject)] = &alertState{
+			firing:       alert,
+			lastNotified: time.Unix(alert.FiredAt, 0),
+		}
+	}
+	alertStatesLoaded = true
+	return nil
+}
+
+// isBelowRule 到期类规则在数值小于阈值时告警，其余规则在大于等于阈值时告警
+func isBelowRule(rule *model.AlertRule) bool {
+	return rule.Type == model.AlertExpiry
+}
+
+func (s *AlertService) observe(rule *model.AlertRule, status *Status, now time.Time) ([]alertObservation, error) {
+	percent := func(current, total uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(current) / float64(total) * 100
+	}
+	switch rule.Type {
+	case model.AlertCpu:
+		if status == nil {
+			return nil, nil
+		}
+		return []alertObservation{{"host", status.Cpu, locale.Bot("alert.cpu", "Value", fmt.Sprintf("%.2f", status.Cpu))}}, nil
+	case model.AlertMem:
+		if status == nil {
+			return nil, nil
+		}
+		value := percent(status.Mem.Current, status.Mem.Total)
+		return []alertObservation{{"host", value, locale.Bot("alert.mem", "Value", fmt.Sprintf("%.2f", value))}}, nil
+	case model.AlertDisk:
+		if status == nil {
+			return nil, nil
+		}
+		value := percent(status.Disk.Current, status.Disk.Total)
+		return []alertObservation{{"host", value, locale.Bot("alert.disk", "Value", fmt.Sprintf("%.2f", value))}}, nil
+	case model.AlertXrayDown:
+		if s.xrayService.IsXrayRunning() {
+			return []alertObservation{{"xray", 0, locale.Bot("alert.xrayRunning")}}, nil
+		}
+		return []alertObservation{{"xray", 1, locale.Bot("alert.xrayDown", "Result", s.xrayService.GetXrayResult())}}, nil
+	case model.AlertTrafficSpike:
+		return s.observeTrafficSpike(status, now)
+	}
+
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	observations := make([]alertObservation, 0)
+	for _, inbound := range inbounds {
+		switch rule.Type {
+		case model.AlertInboundQuota:
+			if inbound.Total <= 0 {
+				continue
+			}
+			value := float64(inbound.QuotaUsed()) / float64(inbound.Total) * 100
+			observations = append(observations, alertObservation{
+				inbound.Tag, value,
+				locale.Bot("alert.inboundQuota", "Remark", inbound.Remark, "Port", inbound.Port, "Value", fmt.Sprintf("%.2f", value),
+					"Used", common.FormatTraffic(inbound.QuotaUsed()), "Total", common.FormatTraffic(inbound.Total)),
+			})
+		case model.AlertClientQuota:
+			for _, client := range inbound.ClientStats {
+				if client.Total <= 0 {
+					continue
+				}
+				value := float64(client.Up+client.Down) / float64(client.Total) * 100
+				observations = append(observations, alertObservation{
+					client.Email, value,
+					locale.Bot("alert.clientQuota", "Email", client.Email, "Remark", inbound.Remark, "Value", fmt.Sprintf("%.2f", value),
+						"Used", common.FormatTraffic(client.Up+client.Down), "Total", common.FormatTraffic(client.Total)),
+				})
+			}
+		case model.AlertExpiry:
+			days := func(expiryTime int64) float64 {
+				return float64(expiryTime-now.UnixMilli()) / float64(time.Hour*24/time.Millisecond)
+			}
+			if inbound.ExpiryTime > 0 && inbound.Enable {
+				value := days(inbound.ExpiryTime)
+				observations = append(observations, alertObservation{
+					inbound.Tag, value,
+					locale.Bot("alert.inboundExpiry", "Remark", inbound.Remark, "Port", inbound.Port,
+						"Expiry", time.UnixMilli(inbound.ExpiryTime).Format("2006-01-02 15:04:05")),
+				})
+			}
+			for _, client := range inbound.ClientStats {
+				if client.ExpiryTime <= 0 || !client.Enable {
+					continue
+				}
+				value := days(client.ExpiryTime)
+				observations = append(observations, alertObservation{
+					client.Email, value,
+					locale.Bot("alert.clientExpiry", "Email", client.Email, "Remark", inbound.Remark,
+						"Expiry", time.UnixMilli(client.ExpiryTime).Format("2006-01-02 15:04:05")),
+				})
+			}
+		}
+	}
+	return observations, nil
+}
+
+// observeTrafficSpike 以当前网速相对最近一小时平均网速的倍数作为观测值
+func (s *AlertService) observeTrafficSpike(status *Status, now time.Time) ([]alertObservation, error) {
+	if status == nil {
+		return nil, nil
+	}
+	db := database.GetDB()
+	var avg struct {
+		Rate float64
+	}
+	err := db.Model(model.ServerStat{}).
+		Select("avg(net_up + net_down) as rate").
+		Where("resolution = ? and time >= ?", StatResolutionMinute, now.Add(-time.Hour).Unix()).
+		Scan(&avg).Error
+	if err != nil {
+		return nil, err
+	}
+	// 平均网速过低时倍数没有意义
+	if avg.Rate < 1024 {
+		return nil, nil
+	}
+	current := float64(status.NetIO.Up + status.NetIO.Down)
+	value := current / avg.Rate
+	return []alertObservation{{"host", value, locale.Bot("alert.trafficSpike",
+		"Speed", common.FormatTraffic(int64(current)), "Value", fmt.Sprintf("%.1f", value))}}, nil
+}
+
+// Evaluate 评估所有启用的规则，处理告警的触发与恢复
+func (s *AlertService) Evaluate() {
+	rules, err := s.GetRules()
+	if err != nil {
+		logger.Warning("get alert rules failed:", err)
+		return
+	}
+
+	alertLock.Lock()
+	defer alertLock.Unlock()
+	err = s.loadFiringAlerts()
+	if err != nil {
+		logger.Warning("load firing alerts failed:", err)
+		return
+	}
+
+	now := time.Now()
+	status := statSamples.last()
+	for _, rule := range rules {
+		if !rule.Enable {
+			continue
+		}
+		observations, err := s.observe(rule, status, now)
+		if err != nil {
+			logger.Warningf("evaluate alert rule %s failed: %v", rule.Name, err)
+			continue
+		}
+		observed := make(map[string]bool, len(observations))
+		for _, observation := range observations {
+			observed[observation.subject] = true
+			s.evaluateObservation(rule, observation, now)
+		}
+		if isObjectRule(rule) {
+			s.forgetUnobserved(rule, observed, now)
+		}
+	}
+}
+
+// isObjectRule 入站和用户类规则对每个入站、用户分别观测，禁用或删除的对象不再产生观测值
+func isObjectRule(rule *model.AlertRule) bool {
+	switch rule.Type {
+	case model.AlertInboundQuota, model.AlertClientQuota, model.AlertExpiry:
+		return true
+	}
+	return false
+}
+
+// forgetUnobserved 清除本次没有观测到的对象的状态，触发中的告警直接恢复，避免一直处于触发状态
+func (s *AlertService) forgetUnobserved(rule *model.AlertRule, observed map[string]bool, now time.Time) {
+	prefix := fmt.Sprintf("%d:", rule.Id)
+	for key, state := range alertStates {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		subject := strings.TrimPrefix(key, prefix)
+		if observed[subject] {
+			continue
+		}
+		if state.firing != nil {
+			s.resolve(rule, state, alertObservation{subject, 0, locale.Bot("alert.subjectGone", "Subject", subject)}, now)
+			if state.firing != nil {
+				continue
+			}
+		}
+		delete(alertStates, key)
+	}
+}
+
+func (s *AlertService) evaluateObservation(rule *model.AlertRule, observation alertObservation, now time.Time) {
+	key := fmt.Sprintf("%d:%s", rule.Id, observation.subject)
+	state, ok := alertStates[key]
+	if !ok {
+		state = &alertState{}
+		alertStates[key] = state
+	}
+
+	threshold := rule.Threshold
+	if rule.Type == model.AlertXrayDown {
+		threshold = 1
+	}
+	var triggered, recovered bool
+	if isBelowRule(rule) {
+		triggered = observation.value <= threshold
+		recovered = observation.value > threshold+rule.Hysteresis
+	} else {
+		triggered = observation.value >= threshold
+		recovered = observation.value < threshold-rule.Hysteresis
+	}
+
+	if state.firing != nil {
+		if recovered {
+			s.resolve(rule, state, observation, now)
+		}
+		return
+	}
+
+	if !triggered {
+		state.pendingSince = time.Time{}
+		return
+	}
+	if state.pendingSince.IsZero() {
+		state.pendingSince = now
+	}
+	if now.Sub(state.pendingSince) < time.Duration(rule.Duration)*time.Second {
+		return
+	}
+	if !state.lastNotified.IsZero() && now.Sub(state.lastNotified) < time.Duration(rule.Cooldown)*time.Second {
+		return
+	}
+	s.fire(rule, state, observation, now)
+}
+
+func (s *AlertService) fire(rule *model.AlertRule, state *alertState, observation alertObservation, now time.Time) {
+	alert := &model.Alert{
+		RuleId:   rule.Id,
+		RuleName: rule.Name,
+		Subject:  observation.subject,
+		Status:   model.AlertFiring,
+		Value:    observation.value,
+		Message:  observation.message,
+		FiredAt:  now.Unix(),
+	}
+	db := database.GetDB()
+	err := db.Create(alert).Error
+	if err != nil {
+		logger.Warning("save alert failed:", err)
+		return
+	}
+	state.firing = alert
+	state.pendingSince = time.Time{}
+	state.lastNotified = now
+	s.notify(rule, locale.Bot("alert.fireTitle", "Name", rule.Name), observation.message)
+}
+
+func (s *AlertService) resolve(rule *model.AlertRule, state *alertState, observation alertObservation, now time.Time) {
+	alert := state.firing
+	alert.Status = model.AlertResolved
+	alert.ResolvedAt = now.Unix()
+	db := database.GetDB()
+	err := db.Save(alert).Error
+	if err != nil {
+		logger.Warning("save alert failed:", err)
+		return
+	}
+	state.firing = nil
+	state.pendingSince = time.Time{}
+	s.notify(rule, locale.Bot("alert.resolveTitle", "Name", rule.Name), observation.message)
+}
+
+func (s *AlertService) notify(rule *model.AlertRule, title string, msg string) {
+	s.notifyService.NotifyChannel(rule.ChannelId, EventAlert, title, msg)
+}
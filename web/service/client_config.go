@@ -0,0 +1,545 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"x-ui/database/model"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 客户端配置的格式
+const (
+	ClientFormatXray    = "xray"
+	ClientFormatClash   = "clash"
+	ClientFormatSingBox = "singbox"
+)
+
+// mapItem 和 orderedMap 按添加顺序输出 JSON/YAML 对象，生成的配置中 type、server 等字段排在前面便于阅读
+type mapItem struct {
+	Key   string
+	Value interface{}
+}
+
+type orderedMap []mapItem
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, item := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (m orderedMap) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, item := range m {
+		value := &yaml.Node{}
+		err := value.Encode(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item.Key}
+		node.Content = append(node.Content, key, value)
+	}
+	return node, nil
+}
+
+// ClientConfigContentType 返回客户端配置的 Content-Type 和文件扩展名，不支持的格式返回错误
+func ClientConfigContentType(format string) (string, string, error) {
+	switch format {
+	case ClientFormatXray, ClientFormatSingBox:
+		return "application/json; charset=utf-8", ".json", nil
+	case ClientFormatClash:
+		return "text/yaml; charset=utf-8", ".yaml", nil
+	}
+	return "", "", locale.NewError("clientConfig.unsupportedFormat", "Format", format)
+}
+
+// GenClientConfig 生成包含 profiles 中所有用户的完整客户端配置，该格式不支持的用户被跳过，
+// 全部不支持时返回第一个错误
+func GenClientConfig(format string, profiles []*clientProfile) ([]byte, error) {
+	switch format {
+	case ClientFormatXray:
+		return genXrayClientConfig(profiles)
+	case ClientFormatClash:
+		return genClashConfig(profiles)
+	case ClientFormatSingBox:
+		return genSingBoxConfig(profiles)
+	}
+	return nil, locale.NewError("clientConfig.unsupportedFormat", "Format", format)
+}
+
+// proxyNames 客户端要求代理名称不重复，备注相同时加上序号
+func proxyNames(profiles []*clientProfile) []string {
+	names := make([]string, len(profiles))
+	used := map[string]int{}
+	for i, profile := range profiles {
+		name := profile.Remark
+		if name == "" {
+			name = profile.hostPort()
+		}
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%v (%d)", name, used[name])
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// convertProfiles 逐个转换用户，跳过不支持的，返回转换结果和对应的名称
+func convertProfiles(profiles []*clientProfile, convert func(p *clientProfile, name string) (orderedMap, error)) ([]orderedMap, []string, error) {
+	if len(profiles) == 0 {
+		return nil, nil, locale.NewError("clientConfig.noClients")
+	}
+	results := make([]orderedMap, 0, len(profiles))
+	names := make([]string, 0, len(profiles))
+	var firstErr error
+	for i, name := range proxyNames(profiles) {
+		result, err := convert(profiles[i], name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		results = append(results, result)
+		names = append(names, name)
+	}
+	if len(results) == 0 {
+		return nil, nil, firstErr
+	}
+	return results, names, nil
+}
+
+func tlsAlpn(stream *xray.StreamSettings) []string {
+	if tls := stream.GetTLSSettings(); tls != nil {
+		return tls.Alpn
+	}
+	return nil
+}
+
+func wsHost(stream *xray.StreamSettings) string {
+	if stream.WSSettings == nil {
+		return ""
+	}
+	return headerValue(stream.WSSettings.Headers, "host")
+}
+
+// xrayClientStream 生成客户端的 streamSettings，tls 只保留 serverName 和 alpn
+func xrayClientStream(stream *xray.StreamSettings) orderedMap {
+	network := stream.GetNetwork()
+	result := orderedMap{{"network", network}, {"security", stream.GetSecurity()}}
+	if stream.GetTLSSettings() != nil {
+		tlsSettings := orderedMap{}
+		if sni := stream.ServerName(); sni != "" {
+			tlsSettings = append(tlsSettings, mapItem{"serverName", sni})
+		}
+		if alpn := tlsAlpn(stream); len(alpn) > 0 {
+			tlsSettings = append(tlsSettings, mapItem{"alpn", alpn})
+		}
+		result = append(result, mapItem{stream.GetSecurity() + "Settings", tlsSettings})
+	}
+	switch network {
+	case "tcp":
+		if tcp := stream.TCPSettings; tcp != nil && tcp.Header.Type == "http" {
+			result = append(result, mapItem{"tcpSettings", orderedMap{{"header", tcp.Header}}})
+		}
+	case "kcp":
+		if stream.KCPSettings != nil {
+			result = append(result, mapItem{"kcpSettings", stream.KCPSettings})
+		}
+	case "ws":
+		if ws := stream.WSSettings; ws != nil {
+			wsSettings := orderedMap{{"path", ws.Path}}
+			if host := wsHost(stream); host != "" {
+				wsSettings = append(wsSettings, mapItem{"headers", orderedMap{{"Host", host}}})
+			}
+			result = append(result, mapItem{"wsSettings", wsSettings})
+		}
+	case "http":
+		if h := stream.HTTPSettings; h != nil {
+			result = append(result, mapItem{"httpSettings", orderedMap{{"path", h.Path}, {"host", h.Host}}})
+		}
+	case "quic":
+		if stream.QUICSettings != nil {
+			result = append(result, mapItem{"quicSettings", stream.QUICSettings})
+		}
+	case "grpc":
+		if grpc := stream.GRPCSettings; grpc != nil {
+			result = append(result, mapItem{"grpcSettings", orderedMap{{"serviceName", grpc.ServiceName}}})
+		}
+	}
+	return result
+}
+
+func xrayOutbound(p *clientProfile, tag string) (orderedMap, error) {
+	server := orderedMap{{"address", p.Address}, {"port", p.Port}}
+	var settings orderedMap
+	switch p.Protocol {
+	case model.VMess:
+		user := orderedMap{{"id", p.Id}, {"alterId", p.AlterId}, {"security", "auto"}}
+		settings = orderedMap{{"vnext", []orderedMap{append(server, mapItem{"users", []orderedMap{user}})}}}
+	case model.VLESS:
+		user := orderedMap{{"id", p.Id}, {"encryption", "none"}}
+		if p.Flow != "" {
+			user = append(user, mapItem{"flow", p.Flow})
+		}
+		settings = orderedMap{{"vnext", []orderedMap{append(server, mapItem{"users", []orderedMap{user}})}}}
+	case model.Trojan:
+		server = append(server, mapItem{"password", p.Password})
+		if p.Flow != "" {
+			server = append(server, mapItem{"flow", p.Flow})
+		}
+		settings = orderedMap{{"servers", []orderedMap{server}}}
+	case model.Shadowsocks:
+		server = append(server, mapItem{"method", p.Method}, mapItem{"password", p.Password})
+		settings = orderedMap{{"servers", []orderedMap{server}}}
+	case model.Socks, model.Http:
+		if p.Username != "" {
+			server = append(server, mapItem{"users", []orderedMap{{{"user", p.Username}, {"pass", p.Password}}}})
+		}
+		settings = orderedMap{{"servers", []orderedMap{server}}}
+	default:
+		return nil, locale.NewError("clientConfig.unsupportedProtocol", "Client", "xray", "Protocol", p.Protocol)
+	}
+	return orderedMap{
+		{"tag", tag},
+		{"protocol", string(p.Protocol)},
+		{"settings", settings},
+		{"streamSettings", xrayClientStream(p.Stream)},
+	}, nil
+}
+
+// genXrayClientConfig 在本机 10808 提供 socks、10809 提供 http 代理，xray 使用第一个出站作为默认出站
+func genXrayClientConfig(profiles []*clientProfile) ([]byte, error) {
+	outbounds, _, err := convertProfiles(profiles, xrayOutbound)
+	if err != nil {
+		return nil, err
+	}
+	outbounds = append(outbounds,
+		orderedMap{{"tag", "direct"}, {"protocol", "freedom"}},
+		orderedMap{{"tag", "block"}, {"protocol", "blackhole"}},
+	)
+	sniffing := orderedMap{{"enabled", true}, {"destOverride", []string{"http", "tls"}}}
+	config := orderedMap{
+		{"log", orderedMap{{"loglevel", "warning"}}},
+		{"inbounds", []orderedMap{
+			{{"tag", "socks"}, {"listen", "127.0.0.1"}, {"port", 10808}, {"protocol", "socks"},
+				{"settings", orderedMap{{"udp", true}}}, {"sniffing", sniffing}},
+			{{"tag", "http"}, {"listen", "127.0.0.1"}, {"port", 10809}, {"protocol", "http"},
+				{"sniffing", sniffing}},
+		}},
+		{"outbounds", outbounds},
+		{"routing", orderedMap{
+			{"domainStrategy", "IPIfNonMatch"},
+			{"rules", []orderedMap{
+				{{"type", "field"}, {"ip", []string{"geoip:private"}}, {"outboundTag", "direct"}},
+			}},
+		}},
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// clashProxy 生成 Clash Meta 的代理，Clash 不支持 xtls、kcp 和 quic
+func clashProxy(p *clientProfile, name string) (orderedMap, error) {
+	stream := p.Stream
+	security := stream.GetSecurity()
+	network := stream.GetNetwork()
+	if security == "xtls" {
+		return nil, locale.NewError("clientConfig.unsupportedXtls", "Client", "Clash")
+	}
+	proxy := orderedMap{{"name", name}}
+	server := orderedMap{{"server", p.Address}, {"port", p.Port}}
+	switch p.Protocol {
+	case model.VMess:
+		proxy = append(proxy, mapItem{"type", "vmess"})
+		proxy = append(proxy, server...)
+		proxy = append(proxy, mapItem{"uuid", p.Id}, mapItem{"alterId", p.AlterId}, mapItem{"cipher", "auto"})
+	case model.VLESS:
+		proxy = append(proxy, mapItem{"type", "vless"})
+		proxy = append(proxy, server...)
+		proxy = append(proxy, mapItem{"uuid", p.Id})
+	case model.Trojan:
+		proxy = append(proxy, mapItem{"type", "trojan"})
+		proxy = append(proxy, server...)
+		proxy = append(proxy, mapItem{"password", p.Password})
+	case model.Shadowsocks:
+		proxy = append(proxy, mapItem{"type", "ss"})
+		proxy = append(proxy, server...)
+		proxy = append(proxy, mapItem{"cipher", p.Method}, mapItem{"password", p.Password})
+	case model.Socks, model.Http:
+		if p.Protocol == model.Socks {
+			proxy = append(proxy, mapItem{"type", "socks5"})
+		} else {
+			proxy = append(proxy, mapItem{"type", "http"})
+		}
+		proxy = append(proxy, server...)
+		if p.Username != "" {
+			proxy = append(proxy, mapItem{"username", p.Username}, mapItem{"password", p.Password})
+		}
+	default:
+		return nil, locale.NewError("clientConfig.unsupportedProtocol", "Client", "Clash", "Protocol", p.Protocol)
+	}
+	if p.Protocol != model.Http {
+		proxy = append(proxy, mapItem{"udp", true})
+	}
+
+	if security == "tls" {
+		if p.Protocol == model.Shadowsocks {
+			return nil, locale.NewError("clientConfig.unsupportedTls", "Client", "Clash", "Protocol", p.Protocol)
+		}
+		proxy = append(proxy, mapItem{"tls", true})
+		if sni := stream.ServerName(); sni != "" {
+			if p.Protocol == model.Trojan {
+				proxy = append(proxy, mapItem{"sni", sni})
+			} else {
+				proxy = append(proxy, mapItem{"servername", sni})
+			}
+		}
+		if alpn := tlsAlpn(stream); len(alpn) > 0 {
+			proxy = append(proxy, mapItem{"alpn", alpn})
+		}
+	}
+
+	switch p.Protocol {
+	case model.VMess, model.VLESS, model.Trojan:
+	default:
+		if network != "tcp" {
+			return nil, locale.NewError("clientConfig.unsupportedProtocolNetwork", "Client", "Clash", "Protocol", p.Protocol, "Network", network)
+		}
+		return proxy, nil
+	}
+	switch network {
+	case "tcp":
+		tcp := stream.TCPSettings
+		if tcp == nil || tcp.Header.Type != "http" {
+			break
+		}
+		if p.Protocol != model.VMess {
+			return nil, locale.NewError("clientConfig.unsupportedHttpHeader", "Client", "Clash", "Protocol", p.Protocol)
+		}
+		httpOpts := orderedMap{{"method", "GET"}, {"path", tcp.Header.Request.Path}}
+		if host := headerValue(tcp.Header.Request.Headers, "host"); host != "" {
+			httpOpts = append(httpOpts, mapItem{"headers", orderedMap{{"Host", []string{host}}}})
+		}
+		proxy = append(proxy, mapItem{"network", "http"}, mapItem{"http-opts", httpOpts})
+	case "ws":
+		wsOpts := orderedMap{}
+		if ws := stream.WSSettings; ws != nil {
+			wsOpts = append(wsOpts, mapItem{"path", ws.Path})
+		}
+		if host := wsHost(stream); host != "" {
+			wsOpts = append(wsOpts, mapItem{"headers", orderedMap{{"Host", host}}})
+		}
+		proxy = append(proxy, mapItem{"network", "ws"}, mapItem{"ws-opts", wsOpts})
+	case "http":
+		h2Opts := orderedMap{}
+		if h := stream.HTTPSettings; h != nil {
+			if len(h.Host) > 0 {
+				h2Opts = append(h2Opts, mapItem{"host", h.Host})
+			}
+			h2Opts = append(h2Opts, mapItem{"path", h.Path})
+		}
+		proxy = append(proxy, mapItem{"network", "h2"}, mapItem{"h2-opts", h2Opts})
+	case "grpc":
+		serviceName := ""
+		if stream.GRPCSettings != nil {
+			serviceName = stream.GRPCSettings.ServiceName
+		}
+		proxy = append(proxy, mapItem{"network", "grpc"}, mapItem{"grpc-opts", orderedMap{{"grpc-service-name", serviceName}}})
+	default:
+		return nil, locale.NewError("clientConfig.unsupportedNetwork", "Client", "Clash", "Network", network)
+	}
+	return proxy, nil
+}
+
+// genClashConfig 生成 Clash Meta 配置，所有代理放在 PROXY 组中，局域网地址直连
+func genClashConfig(profiles []*clientProfile) ([]byte, error) {
+	proxies, names, err := convertProfiles(profiles, clashProxy)
+	if err != nil {
+		return nil, err
+	}
+	config := orderedMap{
+		{"mixed-port", 7890},
+		{"allow-lan", false},
+		{"mode", "rule"},
+		{"log-level", "warning"},
+		{"proxies", proxies},
+		{"proxy-groups", []orderedMap{
+			{{"name", "PROXY"}, {"type", "select"}, {"proxies", names}},
+		}},
+		{"rules", []string{
+			"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+			"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+			"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+			"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+			"MATCH,PROXY",
+		}},
+	}
+	buf := bytes.Buffer{}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(config)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
+
+// singBoxOutbound 生成 sing-box 的出站，sing-box 不支持 xtls、kcp 和 tcp 的 http 伪装
+func singBoxOutbound(p *clientProfile, tag string) (orderedMap, error) {
+	stream := p.Stream
+	security := stream.GetSecurity()
+	network := stream.GetNetwork()
+	if security == "xtls" {
+		return nil, locale.NewError("clientConfig.unsupportedXtls", "Client", "sing-box")
+	}
+	server := orderedMap{{"tag", tag}, {"server", p.Address}, {"server_port", p.Port}}
+	var outbound orderedMap
+	switch p.Protocol {
+	case model.VMess:
+		outbound = append(orderedMap{{"type", "vmess"}}, server...)
+		outbound = append(outbound, mapItem{"uuid", p.Id}, mapItem{"alter_id", p.AlterId}, mapItem{"security", "auto"})
+	case model.VLESS:
+		outbound = append(orderedMap{{"type", "vless"}}, server...)
+		outbound = append(outbound, mapItem{"uuid", p.Id})
+	case model.Trojan:
+		outbound = append(orderedMap{{"type", "trojan"}}, server...)
+		outbound = append(outbound, mapItem{"password", p.Password})
+	case model.Shadowsocks:
+		outbound = append(orderedMap{{"type", "shadowsocks"}}, server...)
+		outbound = append(outbound, mapItem{"method", p.Method}, mapItem{"password", p.Password})
+	case model.Socks:
+		outbound = append(orderedMap{{"type", "socks"}}, server...)
+		outbound = append(outbound, mapItem{"version", "5"})
+		if p.Username != "" {
+			outbound = append(outbound, mapItem{"username", p.Username}, mapItem{"password", p.Password})
+		}
+	case model.Http:
+		outbound = append(orderedMap{{"type", "http"}}, server...)
+		if p.Username != "" {
+			outbound = append(outbound, mapItem{"username", p.Username}, mapItem{"password", p.Password})
+		}
+	default:
+		return nil, locale.NewError("clientConfig.unsupportedProtocol", "Client", "sing-box", "Protocol", p.Protocol)
+	}
+
+	if security == "tls" {
+		switch p.Protocol {
+		case model.Shadowsocks, model.Socks:
+			return nil, locale.NewError("clientConfig.unsupportedTls", "Client", "sing-box", "Protocol", p.Protocol)
+		}
+		tls := orderedMap{{"enabled", true}}
+		if sni := stream.ServerName(); sni != "" {
+			tls = append(tls, mapItem{"server_name", sni})
+		}
+		if alpn := tlsAlpn(stream); len(alpn) > 0 {
+			tls = append(tls, mapItem{"alpn", alpn})
+		}
+		outbound = append(outbound, mapItem{"tls", tls})
+	}
+
+	switch p.Protocol {
+	case model.VMess, model.VLESS, model.Trojan:
+	default:
+		if network != "tcp" {
+			return nil, locale.NewError("clientConfig.unsupportedProtocolNetwork", "Client", "sing-box", "Protocol", p.Protocol, "Network", network)
+		}
+		return outbound, nil
+	}
+	var transport orderedMap
+	switch network {
+	case "tcp":
+		if tcp := stream.TCPSettings; tcp != nil && tcp.Header.Type == "http" {
+			return nil, locale.NewError("clientConfig.unsupportedHttpHeader", "Client", "sing-box", "Protocol", p.Protocol)
+		}
+	case "ws":
+		transport = orderedMap{{"type", "ws"}}
+		if ws := stream.WSSettings; ws != nil {
+			transport = append(transport, mapItem{"path", ws.Path})
+		}
+		if host := wsHost(stream); host != "" {
+			transport = append(transport, mapItem{"headers", orderedMap{{"Host", host}}})
+		}
+	case "http":
+		transport = orderedMap{{"type", "http"}}
+		if h := stream.HTTPSettings; h != nil {
+			if len(h.Host) > 0 {
+				transport = append(transport, mapItem{"host", h.Host})
+			}
+			transport = append(transport, mapItem{"path", h.Path})
+		}
+	case "grpc":
+		transport = orderedMap{{"type", "grpc"}}
+		if grpc := stream.GRPCSettings; grpc != nil {
+			transport = append(transport, mapItem{"service_name", grpc.ServiceName})
+		}
+	case "quic":
+		// sing-box 的 quic 传输没有加密和伪装选项
+		if quic := stream.QUICSettings; quic != nil && (!oneOfString(quic.Security, "", "none") || !oneOfString(quic.Header.Type, "", "none")) {
+			return nil, locale.NewError("clientConfig.unsupportedQuicOptions", "Client", "sing-box")
+		}
+		transport = orderedMap{{"type", "quic"}}
+	default:
+		return nil, locale.NewError("clientConfig.unsupportedNetwork", "Client", "sing-box", "Network", network)
+	}
+	if transport != nil {
+		outbound = append(outbound, mapItem{"transport", transport})
+	}
+	return outbound, nil
+}
+
+func oneOfString(value string, values ...string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// genSingBoxConfig 生成 sing-box 配置，本机 2080 提供 mixed 代理，所有出站放在 proxy 选择器中
+func genSingBoxConfig(profiles []*clientProfile) ([]byte, error) {
+	outbounds, names, err := convertProfiles(profiles, singBoxOutbound)
+	if err != nil {
+		return nil, err
+	}
+	selector := orderedMap{{"type", "selector"}, {"tag", "proxy"}, {"outbounds", names}}
+	outbounds = append([]orderedMap{selector}, outbounds...)
+	outbounds = append(outbounds, orderedMap{{"type", "direct"}, {"tag", "direct"}})
+	config := orderedMap{
+		{"log", orderedMap{{"level", "warn"}}},
+		{"inbounds", []orderedMap{
+			{{"type", "mixed"}, {"tag", "mixed-in"}, {"listen", "127.0.0.1"}, {"listen_port", 2080}},
+		}},
+		{"outbounds", outbounds},
+		{"route", orderedMap{
+			{"rules", []orderedMap{
+				{{"ip_is_private", true}, {"outbound", "direct"}},
+			}},
+			{"final", "proxy"},
+			{"auto_detect_interface", true},
+		}},
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
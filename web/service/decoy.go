@@ -0,0 +1,461 @@
+package service
+
+import (
+	"archive/zip"
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/config"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/json_util"
+	"x-ui/web/locale"
+	"x-ui/xray"
+)
+
+//go:embed decoy
+var decoyTemplatesFS embed.FS
+
+// DecoyUpload 是使用上传的网站时 decoyTemplate 的值
+const DecoyUpload = "upload"
+
+// 上传的网站解压后的总大小和文件数量上限
+const (
+	decoyMaxSize  = 64 << 20
+	decoyMaxFiles = 2000
+)
+
+const decoyNotFoundPage = `<html>
+<head><title>404 Not Found</title></head>
+<body>
+<center><h1>404 Not Found</h1></center>
+<hr><center>nginx</center>
+</body>
+</html>
+`
+
+// 同一时间只运行一个伪装站点
+var decoyLock sync.Mutex
+var decoyServer *http.Server
+
+type DecoyService struct {
+	settingService SettingService
+}
+
+// GetTemplates 返回内置的伪装站点模版名称
+func (s *DecoyService) GetTemplates() ([]string, error) {
+	entries, err := decoyTemplatesFS.ReadDir("decoy")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// replaceSite 在临时目录中生成新网站后替换原目录，生成失败时保留原网站
+func replaceSite(fill func(dir string) error) error {
+	dir := config.GetDecoyDir()
+	tmp := dir + ".new"
+	err := os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tmp, 0755)
+	if err != nil {
+		return err
+	}
+	err = fill(tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// ApplyTemplate 用内置模版替换伪装站点的文件
+func (s *DecoyService) ApplyTemplate(name string) error {
+	root := path.Join("decoy", name)
+	if name == "" || strings.Contains(name, "/") {
+		return locale.NewError("decoy.templateNotFound", "Name", name)
+	}
+	if _, err := fs.Stat(decoyTemplatesFS, root); err != nil {
+		return locale.NewError("decoy.templateNotFound", "Name", name)
+	}
+	err := replaceSite(func(dir string) error {
+		return fs.WalkDir(decoyTemplatesFS, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dir, strings.TrimPrefix(p, root))
+			if d.IsDir() {
+				return os.MkdirAll(target, 0755)
+			}
+			data, err := decoyTemplatesFS.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, 0644)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("decoy site replaced with template", name)
+	return s.settingService.SetDecoyTemplate(name)
+}
+
+// zipRoot 压缩包中所有文件都在同一个目录下时返回该目录，解压时去掉这一层
+func zipRoot(files []*zip.File) string {
+	root := ""
+	for _, f := range files {
+		name := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+		i := strings.Index(name, "/")
+		if i < 0 {
+			if f.FileInfo().IsDir() && (root == "" || root == name) {
+				root = name
+				continue
+			}
+			return ""
+		}
+		if root == "" {
+			root = name[:i]
+		} else if root != name[:i] {
+			return ""
+		}
+	}
+	return root
+}
+
+// UploadSite 用上传的 zip 压缩包替换伪装站点的文件
+func (s *DecoyService) UploadSite(r io.ReaderAt, size int64) error {
+	if size > decoyMaxSize {
+		return locale.NewError("file.archiveTooLarge", "Size", size)
+	}
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return locale.NewError("file.invalidZip", "Error", err)
+	}
+	if len(reader.File) > decoyMaxFiles {
+		return locale.NewError("decoy.tooManyFiles", "Count", len(reader.File))
+	}
+	root := zipRoot(reader.File)
+	var total int64
+	for _, f := range reader.File {
+		total += int64(f.UncompressedSize64)
+	}
+	if total > decoyMaxSize {
+		return locale.NewError("decoy.siteTooLarge", "Size", total)
+	}
+	hasIndex := false
+	err = replaceSite(func(dir string) error {
+		for _, f := range reader.File {
+			// 清理路径，防止压缩包中的 ../ 写到网站目录之外
+			name := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+			if root != "" {
+				name = strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+			}
+			if name == "" {
+				continue
+			}
+			target := filepath.Join(dir, filepath.FromSlash(name))
+			if f.FileInfo().IsDir() {
+				err := os.MkdirAll(target, 0755)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			if name == "index.html" {
+				hasIndex = true
+			}
+			err := extractZipFile(f, target)
+			if err != nil {
+				return err
+			}
+		}
+		if !hasIndex {
+			return locale.NewError("decoy.noIndex")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("decoy site replaced with uploaded zip")
+	return s.settingService.SetDecoyTemplate(DecoyUpload)
+}
+
+func extractZipFile(f *zip.File, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	// 按声明的大小限制读取，避免压缩包谎报大小
+	_, err = io.Copy(dst, io.LimitReader(src, int64(f.UncompressedSize64)))
+	if err1 := dst.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// decoyHandler 提供伪装站点的静态文件，不列出目录，面板根路径下的请求转发到面板
+type decoyHandler struct {
+	root     string
+	basePath string
+	panel    http.Handler
+}
+
+func (h *decoyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.panel != nil && strings.HasPrefix(r.URL.Path, h.basePath) {
+		h.panel.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Server", "nginx")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name := filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	info, err := os.Stat(name)
+	if err == nil && info.IsDir() {
+		name = filepath.Join(name, "index.html")
+		info, err = os.Stat(name)
+	}
+	if err != nil || info.IsDir() {
+		h.notFound(w, r)
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		h.notFound(w, r)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// notFound 网站中有 404.html 时使用，否则返回与 nginx 相同的页面
+func (h *decoyHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	body, err := os.ReadFile(filepath.Join(h.root, "404.html"))
+	if err != nil {
+		body = []byte(decoyNotFoundPage)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// PanelBehindDecoy 判断面板是否只通过伪装站点的面板路径访问，此时面板只监听 127.0.0.1
+func (s *DecoyService) PanelBehindDecoy() (bool, error) {
+	enable, err := s.settingService.GetDecoyEnable()
+	if err != nil || !enable {
+		return false, err
+	}
+	return s.settingService.GetDecoyPanelProxy()
+}
+
+// panelProxy 把请求转发到本机的面板，面板开启 https 时不校验证书
+func (s *DecoyService) panelProxy() (http.Handler, string, error) {
+	basePath, err := s.settingService.GetBasePath()
+	if err != nil {
+		return nil, "", err
+	}
+	port, err := s.settingService.GetPort()
+	if err != nil {
+		return nil, "", err
+	}
+	certFile, err := s.settingService.GetCertFile()
+	if err != nil {
+		return nil, "", err
+	}
+	scheme := "http"
+	if certFile != "" {
+		scheme = "https"
+	}
+	target := &url.URL{Scheme: scheme, Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	return proxy, basePath, nil
+}
+
+// Start 按设置启动伪装站点，网站目录不存在时使用当前模版生成，已在运行时不做任何事
+func (s *DecoyService) Start() error {
+	decoyLock.Lock()
+	defer decoyLock.Unlock()
+	if decoyServer != nil {
+		return nil
+	}
+	if _, err := os.Stat(config.GetDecoyDir()); os.IsNotExist(err) {
+		name, err := s.settingService.GetDecoyTemplate()
+		if err != nil {
+			return err
+		}
+		if name == DecoyUpload {
+			name = defaultValueMap["decoyTemplate"]
+		}
+		err = s.ApplyTemplate(name)
+		if err != nil {
+			return err
+		}
+	}
+	port, err := s.settingService.GetDecoyPort()
+	if err != nil {
+		return err
+	}
+	handler := &decoyHandler{root: config.GetDecoyDir()}
+	panelProxy, err := s.settingService.GetDecoyPanelProxy()
+	if err != nil {
+		return err
+	}
+	basePath, err := s.settingService.GetBasePath()
+	if err != nil {
+		return err
+	}
+	if panelProxy && basePath != "/" {
+		handler.panel, handler.basePath, err = s.panelProxy()
+		if err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+	decoyServer = server
+	logger.Info("decoy site run http on", listener.Addr())
+	return nil
+}
+
+// Stop 停止伪装站点，可以重复调用
+func (s *DecoyService) Stop() {
+	decoyLock.Lock()
+	defer decoyLock.Unlock()
+	if decoyServer == nil {
+		return
+	}
+	decoyServer.Close()
+	decoyServer = nil
+	logger.Info("decoy site stopped")
+}
+
+// Reload 按当前设置重新启动伪装站点，decoyEnable 关闭时只停止
+func (s *DecoyService) Reload() error {
+	s.Stop()
+	enable, err := s.settingService.GetDecoyEnable()
+	if err != nil || !enable {
+		return err
+	}
+	return s.Start()
+}
+
+// FallbackDest 返回作为默认回落的伪装站点地址，未启用时为空
+func (s *DecoyService) FallbackDest() (string, error) {
+	enable, err := s.settingService.GetDecoyEnable()
+	if err != nil || !enable {
+		return "", err
+	}
+	fallback, err := s.settingService.GetDecoyFallback()
+	if err != nil || !fallback {
+		return "", err
+	}
+	port, err := s.settingService.GetDecoyPort()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), nil
+}
+
+// applyDecoyFallback 给没有默认回落的 tcp 传输的 vless/trojan 入站加上回落到伪装站点，
+// 默认回落是 name、alpn、path 都为空的回落
+func applyDecoyFallback(xrayConfig *xray.Config, dest string) {
+	if dest == "" {
+		return
+	}
+	for i := range xrayConfig.InboundConfigs {
+		inboundConfig := &xrayConfig.InboundConfigs[i]
+		if inboundConfig.Protocol != string(model.VLESS) && inboundConfig.Protocol != string(model.Trojan) {
+			continue
+		}
+		stream, err := xray.ParseStreamSettings(string(inboundConfig.StreamSettings))
+		if err != nil || stream.GetNetwork() != "tcp" {
+			continue
+		}
+		settings := map[string]interface{}{}
+		if json.Unmarshal(inboundConfig.Settings, &settings) != nil {
+			continue
+		}
+		fallbacks, _ := settings["fallbacks"].([]interface{})
+		if hasDefaultFallback(fallbacks) {
+			continue
+		}
+		settings["fallbacks"] = append(fallbacks, map[string]interface{}{"dest": dest})
+		data, err := json.Marshal(settings)
+		if err != nil {
+			logger.Warning("marshal decoy fallback failed:", err)
+			continue
+		}
+		inboundConfig.Settings = json_util.RawMessage(data)
+	}
+}
+
+func hasDefaultFallback(fallbacks []interface{}) bool {
+	for _, item := range fallbacks {
+		fallback, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := fallback["name"].(string)
+		alpn, _ := fallback["alpn"].(string)
+		path, _ := fallback["path"].(string)
+		if name == "" && alpn == "" && path == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+const dayMillis = int64(24 * time.Hour / time.Millisecond)
+
+func checkExpiryPolicy(expiryDays int, autoRenew int) error {
+	if expiryDays < 0 {
+		return locale.NewError("expiry.negativeDays", "Days", expiryDays)
+	}
+	if autoRenew < 0 {
+		return locale.NewError("expiry.negativeRenew", "Count", autoRenew)
+	}
+	if autoRenew > 0 && expiryDays == 0 {
+		return locale.NewError("expiry.renewWithoutDays")
+	}
+	return nil
+}
+
+// checkExpiryPolicies 检查入站和其中每个用户的有效天数和自动续期次数
+func checkExpiryPolicies(inbound *model.Inbound) error {
+	err := checkExpiryPolicy(inbound.ExpiryDays, inbound.AutoRenew)
+	if err != nil {
+		return err
+	}
+	clients, err := inbound.GetClients()
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		err = checkExpiryPolicy(client.ExpiryDays, client.AutoRenew)
+		if err != nil {
+			return locale.NewError("inbound.clientInvalid", "Email", client.Email, "Error", err)
+		}
+	}
+	return nil
+}
+
+// renewExpiryTime 返回续期后的到期时间，面板停机太久导致顺延后仍已过期时从现在开始计算
+func renewExpiryTime(expiryTime int64, expiryDays int, now int64) int64 {
+	period := int64(expiryDays) * dayMillis
+	renewed := expiryTime + period
+	if renewed <= now {
+		renewed = now + period
+	}
+	return renewed
+}
+
+// updateClientSettings 修改入站 Settings 中指定用户的字段，保留其他未知字段，
+// 用户的到期时间以 Settings 为准，只改 client_traffics 会在下次编辑入站时被覆盖
+func updateClientSettings(tx *gorm.DB, inboundId int, email string, values map[string]interface{}) error {
+	inbound := &model.Inbound{}
+	err := tx.Model(model.Inbound{}).First(inbound, inboundId).Error
+	if err != nil {
+		return err
+	}
+	settings := map[string]interface{}{}
+	err = json.Unmarshal([]byte(inbound.Settings), &settings)
+	if err != nil {
+		return err
+	}
+	clients, _ := settings["clients"].([]interface{})
+	for _, c := range clients {
+		client, ok := c.(map[string]interface{})
+		if !ok || client["email"] != email {
+			continue
+		}
+		for key, value := range values {
+			client[key] = value
+		}
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tx.Model(model.Inbound{}).Where("id = ?", inboundId).Update("settings", string(data)).Error
+}
+
+// ActivateExpiry 设置了有效天数、尚未开始计时的入站和用户在首次产生流量时开始计时
+func (s *InboundService) ActivateExpiry(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) error {
+	tags := make([]string, 0)
+	for _, traffic := range traffics {
+		if traffic.IsInbound && traffic.Up+traffic.Down > 0 {
+			tags = append(tags, traffic.Tag)
+		}
+	}
+	emails := make([]string, 0)
+	for _, traffic := range clientTraffics {
+		if traffic.Up+traffic.Down > 0 {
+			emails = append(emails, traffic.Email)
+		}
+	}
+	if len(tags) == 0 && len(emails) == 0 {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	db := database.GetDB()
+
+	if len(tags) > 0 {
+		inbounds := make([]*model.Inbound, 0)
+		err := db.Model(model.Inbound{}).
+			Where("tag in ? and expiry_days > 0 and expiry_time = 0", tags).
+			Find(&inbounds).Error
+		if err != nil {
+			return err
+		}
+		for _, inbound := range inbounds {
+			expiryTime := now + int64(inbound.ExpiryDays)*dayMillis
+			err = db.Model(model.Inbound{}).Where("id = ? and expiry_time = 0", inbound.Id).
+				Update("expiry_time", expiryTime).Error
+			if err != nil {
+				return err
+			}
+			logger.Infof("inbound %v activated, expires in %v days", inbound.Id, inbound.ExpiryDays)
+		}
+	}
+
+	if len(emails) > 0 {
+		activated := make([]*xray.ClientTraffic, 0)
+		err := db.Model(xray.ClientTraffic{}).
+			Where("email in ? and expiry_days > 0 and expiry_time = 0", emails).
+			Find(&activated).Error
+		if err != nil {
+			return err
+		}
+		for _, traffic := range activated {
+			expiryTime := now + int64(traffic.ExpiryDays)*dayMillis
+			err = db.Transaction(func(tx *gorm.DB) error {
+				err := tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).
+					Update("expiry_time", expiryTime).Error
+				if err != nil {
+					return err
+				}
+				return updateClientSettings(tx, traffic.InboundId, traffic.Email, map[string]interface{}{
+					"expiryTime": expiryTime,
+				})
+			})
+			if err != nil {
+				return err
+			}
+			logger.Infof("client %v activated, expires in %v days", traffic.Email, traffic.ExpiryDays)
+		}
+	}
+	return nil
+}
+
+// renewExpired 给到期且还有续期次数的入站和用户续期：清零流量、延长到期时间并扣减一次续期次数，
+// 返回因续期重新启用的入站和用户数量
+func (s *InboundService) renewExpired(now int64) (int64, error) {
+	db := database.GetDB()
+	inbounds := make([]*model.Inbound, 0)
+	// 手动禁用的入站不续期，因流量超出或到期而禁用的入站续期后流量清零，可以重新启用
+	err := db.Model(model.Inbound{}).
+		Where("expiry_time > 0 and expiry_time <= ? and expiry_days > 0 and auto_renew > 0", now).
+		Where("enable = ? or disable_reason in ?", true, []string{model.DisableReasonExpiry, model.DisableReasonQuota}).
+		Find(&inbounds).Error
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, inbound := range inbounds {
+		expiryTime := renewExpiryTime(inbound.ExpiryTime, inbound.ExpiryDays, now)
+		var traffics []*xray.ClientTraffic
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := recordTrafficReset(tx, inbound.Id, "", inbound.Up, inbound.Down, inbound.Total, model.ResetTriggerRenew)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Updates(map[string]interface{}{
+				"up":             0,
+				"down":           0,
+				"expiry_time":    expiryTime,
+				"auto_renew":     inbound.AutoRenew - 1,
+				"enable":         true,
+				"disable_reason": "",
+			}).Error
+			if err != nil {
+				return err
+			}
+			traffics, err = resetInheritingClients(tx, inbound.Id, model.ResetTriggerRenew)
+			return err
+		})
+		if err != nil {
+			return count, err
+		}
+		logger.Infof("inbound %v renewed until %v, %v renewals left", inbound.Id,
+			time.UnixMilli(expiryTime).Format("2006-01-02 15:04:05"), inbound.AutoRenew-1)
+
+		data := inboundEventData(inbound)
+		data["trigger"] = model.ResetTriggerRenew
+		s.webhookService.Emit(WebhookInboundReset, data)
+		for _, traffic := range traffics {
+			s.emitClientReset(traffic, model.ResetTriggerRenew)
+		}
+		if !inbound.Enable {
+			count++
+			inbound.Enable = true
+			inbound.Up = 0
+			inbound.Down = 0
+			inbound.ExpiryTime = expiryTime
+			s.webhookService.Emit(WebhookInboundEnabled, inboundEventData(inbound))
+		}
+	}
+
+	// 手动禁用的用户不续期，因流量超出或到期而禁用的用户续期后流量清零，可以重新启用
+	traffics := make([]*xray.ClientTraffic, 0)
+	err = db.Model(xray.ClientTraffic{}).
+		Where("expiry_time > 0 and expiry_time <= ? and expiry_days > 0 and auto_renew > 0", now).
+		Where("enable = ? or disable_reason in ?", true, []string{model.DisableReasonExpiry, model.DisableReasonQuota}).
+		Find(&traffics).Error
+	if err != nil {
+		return count, err
+	}
+	for _, traffic := range traffics {
+		expiryTime := renewExpiryTime(traffic.ExpiryTime, traffic.ExpiryDays, now)
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := recordTrafficReset(tx, traffic.InboundId, traffic.Email, traffic.Up, traffic.Down, traffic.Total, model.ResetTriggerRenew)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Updates(map[string]interface{}{
+				"up":             0,
+				"down":           0,
+				"expiry_time":    expiryTime,
+				"auto_renew":     traffic.AutoRenew - 1,
+				"enable":         true,
+				"disable_reason": "",
+			}).Error
+			if err != nil {
+				return err
+			}
+			return updateClientSettings(tx, traffic.InboundId, traffic.Email, map[string]interface{}{
+				"expiryTime": expiryTime,
+				"autoRenew":  traffic.AutoRenew - 1,
+			})
+		})
+		if err != nil {
+			return count, err
+		}
+		logger.Infof("client %v renewed until %v, %v renewals left", traffic.Email,
+			time.UnixMilli(expiryTime).Format("2006-01-02 15:04:05"), traffic.AutoRenew-1)
+		s.emitClientReset(traffic, model.ResetTriggerRenew)
+		if !traffic.Enable {
+			count++
+		}
+	}
+	return count, nil
+}
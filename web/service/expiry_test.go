@@ -0,0 +1,25 @@
+package service
+
+import "testing"
+
+func TestRenewExpiryTime(t *testing.T) {
+	const now = 100 * dayMillis
+	tests := []struct {
+		name       string
+		expiryTime int64
+		expiryDays int
+		want       int64
+	}{
+		{"just expired", now - 1000, 30, now - 1000 + 30*dayMillis},
+		{"expires now", now, 7, now + 7*dayMillis},
+		{"panel down less than a period", now - 5*dayMillis, 7, now + 2*dayMillis},
+		{"panel down exactly a period", now - 7*dayMillis, 7, now + 7*dayMillis},
+		{"panel down longer than a period", now - 20*dayMillis, 7, now + 7*dayMillis},
+	}
+	for _, test := range tests {
+		got := renewExpiryTime(test.expiryTime, test.expiryDays, now)
+		if got != test.want {
+			t.Errorf("%s: renewExpiryTime() = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"encoding/json"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/json_util"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+// InternalInbound 是可以作为回落目标的内部入站
+type InternalInbound struct {
+	Id       int    `json:"id"`
+	Tag      string `json:"tag"`
+	Remark   string `json:"remark"`
+	Protocol string `json:"protocol"`
+	Network  string `json:"network"`
+	Path     string `json:"path"`
+	Enable   bool   `json:"enable"`
+}
+
+// fallbacksOf 返回 vless 和 trojan 入站的回落设置，其他协议或设置无效时返回 nil
+func fallbacksOf(inbound *model.Inbound) []xray.Fallback {
+	switch inbound.Protocol {
+	case model.VLESS, model.Trojan:
+	default:
+		return nil
+	}
+	settings, err := xray.ParseInboundSettings(string(inbound.Protocol), inbound.Settings)
+	if err != nil {
+		return nil
+	}
+	switch s := settings.(type) {
+	case *xray.VlessSettings:
+		return s.Fallbacks
+	case *xray.TrojanSettings:
+		return s.Fallbacks
+	}
+	return nil
+}
+
+// prepareInternal 为内部入站生成监听地址，回环端口只监听 127.0.0.1，
+// unix socket 使用以标签命名的抽象 socket，此时端口只用于生成标签
+func prepareInternal(inbound *model.Inbound) error {
+	switch inbound.Internal {
+	case "":
+		return nil
+	case model.InternalLoopback:
+		inbound.Listen = "127.0.0.1"
+	case model.InternalUnix:
+		inbound.Listen = "@x-ui/" + inbound.Tag
+	default:
+		return locale.NewError("fallback.unsupportedInternal", "Internal", inbound.Internal)
+	}
+	stream, err := xray.ParseStreamSettings(inbound.StreamSettings)
+	if err != nil {
+		return err
+	}
+	switch stream.GetNetwork() {
+	case "kcp", "quic":
+		return locale.NewError("fallback.internalNetwork", "Network", stream.GetNetwork())
+	}
+	if stream.GetSecurity() != "none" {
+		return locale.NewError("fallback.internalTls")
+	}
+	return nil
+}
+
+// checkFallbackInbounds 检查回落指向的入站存在、属于同一用户且是内部入站
+func (s *InboundService) checkFallbackInbounds(inbound *model.Inbound) error {
+	for i, fallback := range fallbacksOf(inbound) {
+		if fallback.Inbound == "" {
+			continue
+		}
+		if fallback.Inbound == inbound.Tag {
+			return locale.NewError("fallback.self", "Index", i)
+		}
+		target := &model.Inbound{}
+		err := database.GetDB().Model(model.Inbound{}).
+			Where("tag = ? and user_id = ?", fallback.Inbound, inbound.UserId).First(target).Error
+		if database.IsNotFound(err) {
+			return locale.NewError("fallback.notFound", "Index", i, "Tag", fallback.Inbound)
+		} else if err != nil {
+			return err
+		}
+		if target.Internal == "" {
+			return locale.NewError("fallback.notInternal", "Index", i, "Tag", fallback.Inbound)
+		}
+	}
+	return nil
+}
+
+// GetInternalInbounds 返回用户的内部入站，用于选择回落目标
+func (s *InboundService) GetInternalInbounds(userId int) ([]*InternalInbound, error) {
+	inbounds := make([]*model.Inbound, 0)
+	err := database.GetDB().Model(model.Inbound{}).
+		Where("user_id = ? and internal is not null and internal != ''", userId).
+		Order("id").Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*InternalInbound, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		item := &InternalInbound{
+			Id:       inbound.Id,
+			Tag:      inbound.Tag,
+			Remark:   inbound.Remark,
+			Protocol: string(inbound.Protocol),
+			Enable:   inbound.Enable,
+		}
+		if stream, err := xray.ParseStreamSettings(inbound.StreamSettings); err == nil {
+			item.Network = stream.GetNetwork()
+			switch {
+			case stream.WSSettings != nil && item.Network == "ws":
+				item.Path = stream.WSSettings.Path
+			case stream.HTTPSettings != nil && item.Network == "http":
+				item.Path = stream.HTTPSettings.Path
+			}
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// fallbackParent 返回回落到该内部入站的入站，没有时返回 nil
+func (s *InboundService) fallbackParent(inbound *model.Inbound) (*model.Inbound, error) {
+	parents := make([]*model.Inbound, 0)
+	err := database.GetDB().Model(model.Inbound{}).
+		Where("user_id = ? and protocol in ?", inbound.UserId, []model.Protocol{model.VLESS, model.Trojan}).
+		Order("id").Find(&parents).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		for _, fallback := range fallbacksOf(parent) {
+			if fallback.Inbound == inbound.Tag {
+				return parent, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// publicInbound 返回客户端实际连接的入站配置，内部入站使用回落所在入站的监听地址、端口和 tls 设置，
+// 对方使用 xtls 时客户端仍按 tls 连接
+func (s *InboundService) publicInbound(inbound *model.Inbound) (*model.Inbound, error) {
+	if inbound.Internal == "" {
+		return inbound, nil
+	}
+	parent, err := s.fallbackParent(inbound)
+	if err != nil || parent == nil {
+		return inbound, err
+	}
+	stream := map[string]interface{}{}
+	if inbound.StreamSettings != "" {
+		err = json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+		if err != nil {
+			return nil, err
+		}
+	}
+	parentStream := map[string]interface{}{}
+	if parent.StreamSettings != "" {
+		err = json.Unmarshal([]byte(parent.StreamSettings), &parentStream)
+		if err != nil {
+			return nil, err
+		}
+	}
+	delete(stream, "xtlsSettings")
+	stream["security"] = parentStream["security"]
+	stream["tlsSettings"] = parentStream["tlsSettings"]
+	if parentStream["security"] == "xtls" {
+		stream["security"] = "tls"
+		stream["tlsSettings"] = parentStream["xtlsSettings"]
+	}
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return nil, err
+	}
+	public := *inbound
+	public.Listen = parent.Listen
+	public.Port = parent.Port
+	if public.PublicHost == "" {
+		public.PublicHost = parent.PublicHost
+	}
+	public.StreamSettings = string(data)
+	return &public, nil
+}
+
+// applyFallbacks 把回落中的入站标签替换为该内部入站的监听地址，目标不存在或已禁用时去掉该回落
+func applyFallbacks(xrayConfig *xray.Config, inbounds []*model.Inbound) {
+	internals := map[string]*model.Inbound{}
+	for _, inbound := range inbounds {
+		if inbound.Enable && inbound.Internal != "" {
+			internals[inbound.Tag] = inbound
+		}
+	}
+	for i := range xrayConfig.InboundConfigs {
+		inboundConfig := &xrayConfig.InboundConfigs[i]
+		if inboundConfig.Protocol != string(model.VLESS) && inboundConfig.Protocol != string(model.Trojan) {
+			continue
+		}
+		settings := map[string]interface{}{}
+		if json.Unmarshal(inboundConfig.Settings, &settings) != nil {
+			continue
+		}
+		fallbacks, ok := settings["fallbacks"].([]interface{})
+		if !ok {
+			continue
+		}
+		changed := false
+		result := make([]interface{}, 0, len(fallbacks))
+		for _, item := range fallbacks {
+			fallback, ok := item.(map[string]interface{})
+			tag, _ := fallback["inbound"].(string)
+			if !ok || tag == "" {
+				result = append(result, item)
+				continue
+			}
+			changed = true
+			delete(fallback, "inbound")
+			target, ok := internals[tag]
+			if !ok {
+				logger.Warningf("inbound %v fallback to %v skipped, target not found or disabled", inboundConfig.Tag, tag)
+				continue
+			}
+			fallback["dest"] = target.FallbackDest()
+			result = append(result, fallback)
+		}
+		if !changed {
+			continue
+		}
+		settings["fallbacks"] = result
+		data, err := json.Marshal(settings)
+		if err != nil {
+			logger.Warning("marshal fallbacks failed:", err)
+			continue
+		}
+		inboundConfig.Settings = json_util.RawMessage(data)
+	}
+}
+
+// renameFallbackInbound 内部入站的端口变化时标签随之变化，同步修改回落到该入站的设置
+func renameFallbackInbound(tx *gorm.DB, userId int, oldTag string, newTag string) error {
+	parents := make([]*model.Inbound, 0)
+	err := tx.Model(model.Inbound{}).
+		Where("user_id = ? and protocol in ?", userId, []model.Protocol{model.VLESS, model.Trojan}).
+		Find(&parents).Error
+	if err != nil {
+		return err
+	}
+	for _, parent := range parents {
+		settings := map[string]interface{}{}
+		if json.Unmarshal([]byte(parent.Settings), &settings) != nil {
+			continue
+		}
+		fallbacks, _ := settings["fallbacks"].([]interface{})
+		changed := false
+		for _, item := range fallbacks {
+			if fallback, ok := item.(map[string]interface{}); ok && fallback["inbound"] == oldTag {
+				fallback["inbound"] = newTag
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		data, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", parent.Id).Update("settings", string(data)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
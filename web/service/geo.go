@@ -0,0 +1,604 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+const geoMaxSize = 100 << 20
+
+var geoNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.dat$`)
+var geoReleaseRegex = regexp.MustCompile(`/releases/download/([^/]+)/`)
+
+// 同一时间只更新一个 geo 文件，避免定时更新和手动更新同时写同一个文件
+var geoUpdateLock sync.Mutex
+
+// GeoFileInfo 是 geo 文件的设置和文件本身的大小、修改时间，文件不存在时都为 0
+type GeoFileInfo struct {
+	*model.GeoFile
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"modTime"`
+}
+
+// GeoCategory 是 geo 文件中的一个分类，路由规则中用 geosite:code 或 ext:文件名:code 引用
+type GeoCategory struct {
+	Code  string `json:"code"`
+	Count int    `json:"count"`
+}
+
+type GeoService struct {
+	settingService  SettingService
+	xrayService     XrayService
+	xrayCoreService XrayCoreService
+}
+
+func isBuiltinGeoFile(name string) bool {
+	return name == "geoip.dat" || name == "geosite.dat"
+}
+
+// guessGeoType 根据文件名猜测手动放到 bin 目录中的 geo 文件的类型
+func guessGeoType(name string) string {
+	if strings.Contains(strings.ToLower(name), "ip") {
+		return model.GeoTypeIP
+	}
+	return model.GeoTypeSite
+}
+
+// parseGeoData 解析 geo 文件，返回每个分类的条目数，分类名统一为小写
+func parseGeoData(geoType string, data []byte) (map[string]int, error) {
+	categories := map[string]int{}
+	switch geoType {
+	case model.GeoTypeIP:
+		list := &router.GeoIPList{}
+		if err := proto.Unmarshal(data, list); err != nil {
+			return nil, locale.NewError("geo.invalidGeoip", "Error", err)
+		}
+		for _, entry := range list.Entry {
+			categories[strings.ToLower(entry.CountryCode)] += len(entry.Cidr)
+		}
+	case model.GeoTypeSite:
+		list := &router.GeoSiteList{}
+		if err := proto.Unmarshal(data, list); err != nil {
+			return nil, locale.NewError("geo.invalidGeosite", "Error", err)
+		}
+		for _, entry := range list.Entry {
+			categories[strings.ToLower(entry.CountryCode)] += len(entry.Domain)
+		}
+	default:
+		return nil, locale.NewError("geo.unknownType", "Type", geoType)
+	}
+	if len(categories) == 0 {
+		return nil, locale.NewError("geo.noCategories")
+	}
+	return categories, nil
+}
+
+// referencedGeoTags 返回 xray 配置模版的路由规则中引用的 geo 文件和分类，
+// 支持 geoip:cn、geosite:google@ads、ext:custom.dat:tag 等写法
+func referencedGeoTags(template string) map[string]map[string]bool {
+	refs := map[string]map[string]bool{}
+	xrayConfig := &xray.Config{}
+	if json.Unmarshal([]byte(template), xrayConfig) != nil || len(xrayConfig.RouterConfig) == 0 {
+		return refs
+	}
+	routing := struct {
+		Rules []struct {
+			Domain  []string `json:"domain"`
+			Domains []string `json:"domains"`
+			IP      []string `json:"ip"`
+		} `json:"rules"`
+	}{}
+	if json.Unmarshal(xrayConfig.RouterConfig, &routing) != nil {
+		return refs
+	}
+	add := func(name string, tag string) {
+		tag = strings.TrimPrefix(tag, "!")
+		if i := strings.Index(tag, "@"); i >= 0 {
+			tag = tag[:i]
+		}
+		if name == "" || tag == "" {
+			return
+		}
+		if refs[name] == nil {
+			refs[name] = map[string]bool{}
+		}
+		refs[name][strings.ToLower(tag)] = true
+	}
+	parse := func(value string, geoName string, geoPrefix string) {
+		switch {
+		case strings.HasPrefix(value, geoPrefix):
+			add(geoName, strings.TrimPrefix(value, geoPrefix))
+		case strings.HasPrefix(value, "ext:"):
+			parts := strings.SplitN(strings.TrimPrefix(value, "ext:"), ":", 2)
+			if len(parts) == 2 {
+				add(parts[0], parts[1])
+			}
+		}
+	}
+	for _, rule := range routing.Rules {
+		for _, domain := range append(rule.Domain, rule.Domains...) {
+			parse(domain, "geosite.dat", "geosite:")
+		}
+		for _, ip := range rule.IP {
+			parse(ip, "geoip.dat", "geoip:")
+		}
+	}
+	return refs
+}
+
+func checkGeoTags(name string, categories map[string]int, tags map[string]bool) error {
+	missing := make([]string, 0)
+	for tag := range tags {
+		if _, ok := categories[tag]; !ok {
+			missing = append(missing, tag)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return locale.NewError("geo.missingCategories", "Name", name, "Categories", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// CheckXrayTemplate 检查 xray 配置模版的路由规则中引用的 geo 文件和分类是否存在，
+// ext: 引用的文件必须存在，xray 自带的两个文件不存在时不检查
+func (s *GeoService) CheckXrayTemplate(template string) error {
+	for name, tags := range referencedGeoTags(template) {
+		if !geoNameRegex.MatchString(name) {
+			return locale.NewError("geo.invalidRuleName", "Name", name)
+		}
+		data, err := os.ReadFile(xray.GetAssetPath(name))
+		if err != nil {
+			if os.IsNotExist(err) && isBuiltinGeoFile(name) {
+				continue
+			}
+			return locale.NewError("geo.ruleFileNotFound", "Name", name)
+		}
+		geoType := guessGeoType(name)
+		geoFile, err := s.getGeoFileByName(name)
+		if err == nil {
+			geoType = geoFile.Type
+		}
+		categories, err := parseGeoData(geoType, data)
+		if err != nil {
+			return locale.NewError("geo.fileError", "Name", name, "Error", err)
+		}
+		err = checkGeoTags(name, categories, tags)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GeoService) getGeoFileByName(name string) (*model.GeoFile, error) {
+	db := database.GetDB()
+	geoFile := &model.GeoFile{}
+	err := db.Model(model.GeoFile{}).Where("name = ?", name).First(geoFile).Error
+	if err != nil {
+		return nil, err
+	}
+	return geoFile, nil
+}
+
+// registerAssetFiles 把手动放到 bin 目录中的 .dat 文件加入管理
+func (s *GeoService) registerAssetFiles() error {
+	entries, err := os.ReadDir(xray.GetAssetDir())
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !geoNameRegex.MatchString(name) {
+			continue
+		}
+		var count int64
+		err = db.Model(model.GeoFile{}).Where("name = ?", name).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		err = db.Create(&model.GeoFile{
+			Name: name,
+			Type: guessGeoType(name),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GeoService) GetGeoFiles() ([]*GeoFileInfo, error) {
+	err := s.registerAssetFiles()
+	if err != nil {
+		logger.Warning("register geo files failed:", err)
+	}
+	db := database.GetDB()
+	geoFiles := make([]*model.GeoFile, 0)
+	err = db.Model(model.GeoFile{}).Order("id").Find(&geoFiles).Error
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*GeoFileInfo, 0, len(geoFiles))
+	for _, geoFile := range geoFiles {
+		info := &GeoFileInfo{GeoFile: geoFile}
+		if stat, err := os.Stat(xray.GetAssetPath(geoFile.Name)); err == nil {
+			info.Size = stat.Size()
+			info.ModTime = stat.ModTime().UnixMilli()
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *GeoService) GetGeoFile(id int) (*model.GeoFile, error) {
+	db := database.GetDB()
+	geoFile := &model.GeoFile{}
+	err := db.Model(model.GeoFile{}).First(geoFile, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return geoFile, nil
+}
+
+func (s *GeoService) checkGeoFile(geoFile *model.GeoFile) error {
+	geoFile.Name = strings.TrimSpace(geoFile.Name)
+	geoFile.Url = strings.TrimSpace(geoFile.Url)
+	if !geoNameRegex.MatchString(geoFile.Name) {
+		return locale.NewError("geo.invalidName", "Name", geoFile.Name)
+	}
+	if geoFile.Type != model.GeoTypeIP && geoFile.Type != model.GeoTypeSite {
+		return locale.NewError("geo.unknownType", "Type", geoFile.Type)
+	}
+	if geoFile.Url != "" && !strings.HasPrefix(geoFile.Url, "http://") && !strings.HasPrefix(geoFile.Url, "https://") {
+		return locale.NewError("geo.urlScheme")
+	}
+	return nil
+}
+
+func (s *GeoService) AddGeoFile(geoFile *model.GeoFile) error {
+	err := s.checkGeoFile(geoFile)
+	if err != nil {
+		return err
+	}
+	_, err = s.getGeoFileByName(geoFile.Name)
+	if err == nil {
+		return locale.NewError("geo.nameExists", "Name", geoFile.Name)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	geoFile.Version = ""
+	geoFile.Sha256 = ""
+	geoFile.LastUpdate = 0
+	geoFile.LastCheck = 0
+	geoFile.LastError = ""
+	db := database.GetDB()
+	return db.Create(geoFile).Error
+}
+
+// UpdateGeoFile 修改下载地址和是否校验，xray 自带的两个文件不能修改类型
+func (s *GeoService) UpdateGeoFile(geoFile *model.GeoFile) error {
+	oldGeoFile, err := s.GetGeoFile(geoFile.Id)
+	if err != nil {
+		return err
+	}
+	geoFile.Name = oldGeoFile.Name
+	if isBuiltinGeoFile(oldGeoFile.Name) {
+		geoFile.Type = oldGeoFile.Type
+	}
+	err = s.checkGeoFile(geoFile)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Model(model.GeoFile{}).Where("id = ?", geoFile.Id).Updates(map[string]interface{}{
+		"type":   geoFile.Type,
+		"url":    geoFile.Url,
+		"verify": geoFile.Verify,
+	}).Error
+}
+
+// DelGeoFile 删除自定义的 geo 文件，仍被路由规则引用时不能删除
+func (s *GeoService) DelGeoFile(id int) error {
+	geoFile, err := s.GetGeoFile(id)
+	if err != nil {
+		return err
+	}
+	if isBuiltinGeoFile(geoFile.Name) {
+		return locale.NewError("geo.delBuiltin", "Name", geoFile.Name)
+	}
+	template, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return err
+	}
+	if _, ok := referencedGeoTags(template)[geoFile.Name]; ok {
+		return locale.NewError("geo.inUse", "Name", geoFile.Name)
+	}
+	geoUpdateLock.Lock()
+	defer geoUpdateLock.Unlock()
+	err = os.Remove(xray.GetAssetPath(geoFile.Name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db := database.GetDB()
+	return db.Delete(model.GeoFile{}, id).Error
+}
+
+// GetCategories 返回 geo 文件中的分类和每个分类的条目数
+func (s *GeoService) GetCategories(id int) ([]*GeoCategory, error) {
+	geoFile, err := s.GetGeoFile(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(xray.GetAssetPath(geoFile.Name))
+	if err != nil {
+		return nil, err
+	}
+	categories, err := parseGeoData(geoFile.Type, data)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*GeoCategory, 0, len(categories))
+	for code, count := range categories {
+		result = append(result, &GeoCategory{
+			Code:  code,
+			Count: count,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Code < result[j].Code
+	})
+	return result, nil
+}
+
+// replaceGeoFile 检查新文件的内容和路由规则中使用的分类后替换原文件
+func (s *GeoService) replaceGeoFile(geoFile *model.GeoFile, data []byte, version string) error {
+	categories, err := parseGeoData(geoFile.Type, data)
+	if err != nil {
+		return err
+	}
+	template, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return err
+	}
+	err = checkGeoTags(geoFile.Name, categories, referencedGeoTags(template)[geoFile.Name])
+	if err != nil {
+		return err
+	}
+
+	target := xray.GetAssetPath(geoFile.Name)
+	tmp := target + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	geoFile.Sha256 = hex.EncodeToString(sum[:])
+	geoFile.Version = version
+	geoFile.LastUpdate = time.Now().UnixMilli()
+	geoFile.LastError = ""
+	logger.Infof("geo file %s updated, version: %s", geoFile.Name, version)
+	db := database.GetDB()
+	return db.Save(geoFile).Error
+}
+
+func (s *GeoService) fetch(client *http.Client, u string) ([]byte, *http.Response, error) {
+	u, err := s.xrayCoreService.mirrorUrl(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, locale.NewError("file.downloadFailed", "Url", u, "Status", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, geoMaxSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > geoMaxSize {
+		return nil, nil, locale.NewError("file.downloadTooLarge", "Url", u)
+	}
+	return data, resp, nil
+}
+
+// download 下载 geo 文件，GitHub 发布的文件以发布的标签作为版本，其他地址以修改时间作为版本
+func (s *GeoService) download(geoFile *model.GeoFile) ([]byte, string, error) {
+	client, err := s.xrayCoreService.httpClient()
+	if err != nil {
+		return nil, "", err
+	}
+	data, resp, err := s.fetch(client, geoFile.Url)
+	if err != nil {
+		return nil, "", err
+	}
+	version := ""
+	// latest 地址会先跳转到带标签的地址，再跳转到实际存放文件的地址，从跳转经过的地址中找标签
+	for req := resp.Request; req != nil && version == ""; {
+		if matches := geoReleaseRegex.FindStringSubmatch(req.URL.Path); len(matches) == 2 {
+			version = matches[1]
+		}
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	if version == "" {
+		if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			version = t.Local().Format("2006-01-02 15:04")
+		}
+	}
+
+	if geoFile.Verify {
+		sumData, _, err := s.fetch(client, geoFile.Url+".sha256sum")
+		if err != nil {
+			return nil, "", locale.NewError("file.checksumFetchFailed", "Error", err)
+		}
+		fields := strings.Fields(string(sumData))
+		if len(fields) == 0 {
+			return nil, "", locale.NewError("file.checksumEmpty")
+		}
+		sum := sha256.Sum256(data)
+		actual := hex.EncodeToString(sum[:])
+		if !strings.EqualFold(fields[0], actual) {
+			return nil, "", locale.NewError("file.sha256Mismatch", "Actual", actual)
+		}
+	}
+	return data, version, nil
+}
+
+// updateFromUrl 从下载地址更新 geo 文件，返回文件内容是否有变化
+func (s *GeoService) updateFromUrl(geoFile *model.GeoFile) (bool, error) {
+	if geoFile.Url == "" {
+		return false, locale.NewError("geo.noUrl", "Name", geoFile.Name)
+	}
+	geoFile.LastCheck = time.Now().UnixMilli()
+	data, version, err := s.download(geoFile)
+	if err == nil {
+		sum := sha256.Sum256(data)
+		_, statErr := os.Stat(xray.GetAssetPath(geoFile.Name))
+		if statErr == nil && hex.EncodeToString(sum[:]) == geoFile.Sha256 {
+			geoFile.Version = version
+			geoFile.LastError = ""
+			return false, database.GetDB().Save(geoFile).Error
+		}
+		err = s.replaceGeoFile(geoFile, data, version)
+		if err == nil {
+			return true, nil
+		}
+	}
+	geoFile.LastError = err.Error()
+	if err1 := database.GetDB().Save(geoFile).Error; err1 != nil {
+		logger.Warning("save geo file failed:", err1)
+	}
+	return false, err
+}
+
+// restartXray geo 文件只在 xray 启动时读取，文件变化后需要强制重启
+func (s *GeoService) restartXray() {
+	if !s.xrayService.IsXrayRunning() {
+		return
+	}
+	err := s.xrayService.RestartXray(true)
+	if err != nil {
+		logger.Warning("restart xray after geo update failed:", err)
+	}
+}
+
+// UpdateFromUrl 立即从下载地址更新 geo 文件，内容有变化时重启 xray
+func (s *GeoService) UpdateFromUrl(id int) error {
+	geoUpdateLock.Lock()
+	defer geoUpdateLock.Unlock()
+	geoFile, err := s.GetGeoFile(id)
+	if err != nil {
+		return err
+	}
+	changed, err := s.updateFromUrl(geoFile)
+	if changed {
+		s.restartXray()
+	}
+	return err
+}
+
+// UploadGeoFile 用上传的文件替换或添加 geo 文件，geoType 为空时使用已有的类型或根据文件名猜测
+func (s *GeoService) UploadGeoFile(name string, geoType string, r io.Reader, size int64) error {
+	if size > geoMaxSize {
+		return locale.NewError("file.tooLarge", "Size", size)
+	}
+	geoFile, err := s.getGeoFileByName(name)
+	if err == gorm.ErrRecordNotFound {
+		geoFile = &model.GeoFile{Name: name, Type: geoType}
+		if geoFile.Type == "" {
+			geoFile.Type = guessGeoType(name)
+		}
+		err = s.checkGeoFile(geoFile)
+	} else if err == nil && geoType != "" && !isBuiltinGeoFile(name) {
+		geoFile.Type = geoType
+		err = s.checkGeoFile(geoFile)
+	}
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, geoMaxSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > geoMaxSize {
+		return locale.NewError("file.tooLarge", "Size", len(data))
+	}
+
+	geoUpdateLock.Lock()
+	defer geoUpdateLock.Unlock()
+	err = s.replaceGeoFile(geoFile, data, "上传于 "+time.Now().Format("2006-01-02 15:04"))
+	if err != nil {
+		return err
+	}
+	s.restartXray()
+	return nil
+}
+
+// AutoUpdate 按设置的间隔更新设置了下载地址的 geo 文件，全部更新后只重启一次 xray
+func (s *GeoService) AutoUpdate() error {
+	interval, err := s.settingService.GetGeoUpdateInterval()
+	if err != nil || interval <= 0 {
+		return err
+	}
+	geoUpdateLock.Lock()
+	defer geoUpdateLock.Unlock()
+
+	db := database.GetDB()
+	geoFiles := make([]*model.GeoFile, 0)
+	err = db.Model(model.GeoFile{}).Where("url != ''").Find(&geoFiles).Error
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(-time.Duration(interval) * time.Hour).UnixMilli()
+	changed := false
+	errs := make([]error, 0)
+	for _, geoFile := range geoFiles {
+		if geoFile.LastCheck > deadline {
+			continue
+		}
+		fileChanged, err := s.updateFromUrl(geoFile)
+		if err != nil {
+			errs = append(errs, locale.NewError("geo.fileError", "Name", geoFile.Name, "Error", err))
+		}
+		changed = changed || fileChanged
+	}
+	if changed {
+		s.restartXray()
+	}
+	return common.Combine(errs...)
+}
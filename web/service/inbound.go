@@ -2,22 +2,64 @@ package service
 
 import (
 	"fmt"
+	"net"
 	"time"
 	"x-ui/database"
 	"x-ui/database/model"
+	"x-ui/logger"
 	"x-ui/util/common"
+	"x-ui/util/random"
+	"x-ui/web/locale"
 	"x-ui/xray"
 
 	"gorm.io/gorm"
 )
 
 type InboundService struct {
+	webhookService WebhookService
+	settingService SettingService
+	addressService AddressService
+}
+
+type clientChanges struct {
+	added   []string
+	removed []string
+}
+
+func inboundEventData(inbound *model.Inbound) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         inbound.Id,
+		"remark":     inbound.Remark,
+		"tag":        inbound.Tag,
+		"port":       inbound.Port,
+		"protocol":   inbound.Protocol,
+		"enable":     inbound.Enable,
+		"up":         inbound.Up,
+		"down":       inbound.Down,
+		"total":      inbound.Total,
+		"expiryTime": inbound.ExpiryTime,
+	}
+}
+
+func (s *InboundService) emitClientChanges(inbound *model.Inbound, changes *clientChanges) {
+	for _, email := range changes.added {
+		s.webhookService.Emit(WebhookClientCreated, map[string]interface{}{
+			"inboundId": inbound.Id,
+			"email":     email,
+		})
+	}
+	for _, email := range changes.removed {
+		s.webhookService.Emit(WebhookClientDeleted, map[string]interface{}{
+			"inboundId": inbound.Id,
+			"email":     email,
+		})
+	}
 }
 
 func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Where("user_id = ?", userId).Find(&inbounds).Error
+	err := db.Model(model.Inbound{}).Preload("ClientStats").Where("user_id = ?", userId).Find(&inbounds).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
@@ -27,7 +69,7 @@ func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
 func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Find(&inbounds).Error
+	err := db.Model(model.Inbound{}).Preload("ClientStats").Find(&inbounds).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
@@ -48,60 +90,226 @@ func (s *InboundService) checkPortExist(port int, ignoreId int) (bool, error) {
 	return count > 0, nil
 }
 
-func (s *InboundService) AddInbound(inbound *model.Inbound) error {
-	exist, err := s.checkPortExist(inbound.Port, 0)
+// checkInboundConfig 按协议检查入站的 settings、streamSettings 和 sniffing，错误为 xray.FieldErrors
+func checkInboundConfig(inbound *model.Inbound) error {
+	if inbound.PublicHost != "" && net.ParseIP(inbound.PublicHost) == nil && !common.IsDomain(inbound.PublicHost) {
+		return xray.FieldErrors{xray.NewFieldError("publicHost", "publicHost")}
+	}
+	return xray.ValidateInbound(string(inbound.Protocol), inbound.Settings, inbound.StreamSettings, inbound.Sniffing)
+}
+
+func (s *InboundService) checkEmailsExist(inbound *model.Inbound) error {
+	clients, err := inbound.GetClients()
 	if err != nil {
 		return err
 	}
-	if exist {
-		return common.NewError("端口已存在:", inbound.Port)
-	}
-	db := database.GetDB()
-	return db.Save(inbound).Error
-}
+	emails := map[string]bool{}
+	for _, client := range clients {
+		if client.Email == "" {
+			continue
+		}
+		if emails[client.Email] {
+			return locale.NewError("inbound.emailDuplicate", "Email", client.Email)
+		}
+		emails[client.Email] = true
 
-func (s *InboundService) AddInbounds(inbounds []*model.Inbound) error {
-	for _, inbound := range inbounds {
-		exist, err := s.checkPortExist(inbound.Port, 0)
+		db := database.GetDB()
+		var count int64
+		err = db.Model(xray.ClientTraffic{}).
+			Where("email = ? and inbound_id != ?", client.Email, inbound.Id).
+			Count(&count).Error
 		if err != nil {
 			return err
 		}
-		if exist {
-			return common.NewError("端口已存在:", inbound.Port)
+		if count > 0 {
+			return locale.NewError("inbound.emailExists", "Email", client.Email)
 		}
 	}
+	return nil
+}
 
+// syncClientTraffics 根据 Settings 中的 clients 同步 client_traffics 表，保留已统计的流量
+func (s *InboundService) syncClientTraffics(tx *gorm.DB, inbound *model.Inbound) (*clientChanges, error) {
+	changes := &clientChanges{}
+	clients, err := inbound.GetClients()
+	if err != nil {
+		return nil, err
+	}
+	emails := make([]string, 0, len(clients))
+	for _, client := range clients {
+		if client.Email == "" {
+			continue
+		}
+		emails = append(emails, client.Email)
+		traffic := &xray.ClientTraffic{}
+		err = tx.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).First(traffic).Error
+		if database.IsNotFound(err) {
+			traffic = &xray.ClientTraffic{
+				Email:    client.Email,
+				Enable:   true,
+				SubToken: random.Seq(16),
+			}
+			changes.added = append(changes.added, client.Email)
+		} else if err != nil {
+			return nil, err
+		}
+		traffic.InboundId = inbound.Id
+		traffic.Total = client.Total
+		traffic.ExpiryTime = client.ExpiryTime
+		traffic.ExpiryDays = client.ExpiryDays
+		traffic.AutoRenew = client.AutoRenew
+		if traffic.ResetPolicy != client.ResetPolicy || traffic.ResetDay != client.ResetDay {
+			// 修改周期后从现在开始计算，避免立即触发一次重置
+			traffic.ResetPolicy = client.ResetPolicy
+			traffic.ResetDay = client.ResetDay
+			traffic.LastResetAt = time.Now().UnixMilli()
+		}
+		err = tx.Save(traffic).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	db := tx.Model(xray.ClientTraffic{}).Where("inbound_id = ?", inbound.Id)
+	if len(emails) > 0 {
+		db = db.Where("email not in ?", emails)
+	}
+	err = db.Pluck("email", &changes.removed).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(changes.removed) == 0 {
+		return changes, nil
+	}
+	err = tx.Where("inbound_id = ? and email in ?", inbound.Id, changes.removed).Delete(xray.ClientTraffic{}).Error
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (s *InboundService) AddInbound(inbound *model.Inbound) error {
+	err := checkInboundConfig(inbound)
+	if err != nil {
+		return err
+	}
+	err = prepareInternal(inbound)
+	if err != nil {
+		return err
+	}
+	err = s.checkPortConflict(inbound, 0)
+	if err != nil {
+		return err
+	}
+	err = s.checkFallbackInbounds(inbound)
+	if err != nil {
+		return err
+	}
+	err = s.checkEmailsExist(inbound)
+	if err != nil {
+		return err
+	}
+	err = checkResetPolicies(inbound)
+	if err != nil {
+		return err
+	}
+	err = checkExpiryPolicies(inbound)
+	if err != nil {
+		return err
+	}
+	err = checkQuotaPolicy(inbound)
+	if err != nil {
+		return err
+	}
+	var changes *clientChanges
 	db := database.GetDB()
-	tx := db.Begin()
-	var err error
-	defer func() {
-		if err == nil {
-			tx.Commit()
-		} else {
-			tx.Rollback()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(inbound).Error
+		if err != nil {
+			return err
 		}
-	}()
+		changes, err = s.syncClientTraffics(tx, inbound)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.webhookService.Emit(WebhookInboundCreated, inboundEventData(inbound))
+	s.emitClientChanges(inbound, changes)
+	return nil
+}
 
+func (s *InboundService) AddInbounds(inbounds []*model.Inbound) error {
 	for _, inbound := range inbounds {
-		err = tx.Save(inbound).Error
+		err := checkInboundConfig(inbound)
+		if err != nil {
+			return locale.NewError("inbound.inboundInvalid", "Remark", inbound.Remark, "Error", err)
+		}
+		err = s.checkPortConflict(inbound, 0)
 		if err != nil {
 			return err
 		}
 	}
 
+	changes := make([]*clientChanges, len(inbounds))
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i, inbound := range inbounds {
+			err := tx.Save(inbound).Error
+			if err != nil {
+				return err
+			}
+			changes[i], err = s.syncClientTraffics(tx, inbound)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, inbound := range inbounds {
+		s.webhookService.Emit(WebhookInboundCreated, inboundEventData(inbound))
+		s.emitClientChanges(inbound, changes[i])
+	}
 	return nil
 }
 
 func (s *InboundService) DelInbound(id int) error {
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		return err
+	}
+	emails := make([]string, 0)
 	db := database.GetDB()
-	return db.Delete(model.Inbound{}, id).Error
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(xray.ClientTraffic{}).Where("inbound_id = ?", id).Pluck("email", &emails).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(model.Inbound{}, id).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.webhookService.Emit(WebhookInboundDeleted, inboundEventData(inbound))
+	s.emitClientChanges(inbound, &clientChanges{removed: emails})
+	return nil
 }
 
 func (s *InboundService) DelInboundByPort(port int) error {
 	db := database.GetDB()
 	var inbound model.Inbound
-	db.First(&inbound, "port = ?", port)
-	return db.Delete(&inbound).Error
+	err := db.First(&inbound, "port = ?", port).Error
+	if err != nil {
+		return err
+	}
+	return s.DelInbound(inbound.Id)
 }
 
 func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
@@ -115,51 +323,214 @@ func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
 }
 
 func (s *InboundService) UpdateInbound(inbound *model.Inbound) error {
-	exist, err := s.checkPortExist(inbound.Port, inbound.Id)
+	err := checkInboundConfig(inbound)
 	if err != nil {
 		return err
 	}
-	if exist {
-		return common.NewError("端口已存在:", inbound.Port)
+	inbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
+	err = prepareInternal(inbound)
+	if err != nil {
+		return err
+	}
+	err = s.checkPortConflict(inbound, inbound.Id)
+	if err != nil {
+		return err
 	}
 
 	oldInbound, err := s.GetInbound(inbound.Id)
 	if err != nil {
 		return err
 	}
+	oldTag := oldInbound.Tag
+	wasEnable := oldInbound.Enable
+	oldUp, oldDown := oldInbound.Up, oldInbound.Down
+	wasUsed := oldInbound.Up+oldInbound.Down > 0
+	if oldInbound.ResetPolicy != inbound.ResetPolicy || oldInbound.ResetDay != inbound.ResetDay {
+		oldInbound.LastResetAt = time.Now().UnixMilli()
+	}
+	if wasEnable != inbound.Enable {
+		if inbound.Enable {
+			oldInbound.DisableReason = ""
+		} else {
+			oldInbound.DisableReason = model.DisableReasonManual
+		}
+	}
 	oldInbound.Up = inbound.Up
 	oldInbound.Down = inbound.Down
 	oldInbound.Total = inbound.Total
 	oldInbound.Remark = inbound.Remark
+	oldInbound.Group = inbound.Group
 	oldInbound.Enable = inbound.Enable
 	oldInbound.ExpiryTime = inbound.ExpiryTime
+	oldInbound.ExpiryDays = inbound.ExpiryDays
+	oldInbound.AutoRenew = inbound.AutoRenew
 	oldInbound.Listen = inbound.Listen
 	oldInbound.Port = inbound.Port
 	oldInbound.Protocol = inbound.Protocol
 	oldInbound.Settings = inbound.Settings
 	oldInbound.StreamSettings = inbound.StreamSettings
 	oldInbound.Sniffing = inbound.Sniffing
-	oldInbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
+	oldInbound.Tag = inbound.Tag
+	oldInbound.Internal = inbound.Internal
+	oldInbound.PublicHost = inbound.PublicHost
+	oldInbound.ResetPolicy = inbound.ResetPolicy
+	oldInbound.ResetDay = inbound.ResetDay
+	oldInbound.QuotaMode = inbound.QuotaMode
+	oldInbound.QuotaMultiplier = inbound.QuotaMultiplier
+	oldInbound.QuotaGrace = inbound.QuotaGrace
+	oldInbound.OverageAction = inbound.OverageAction
+	oldInbound.OverageOutbound = inbound.OverageOutbound
+
+	err = s.checkEmailsExist(oldInbound)
+	if err != nil {
+		return err
+	}
+	err = checkResetPolicies(oldInbound)
+	if err != nil {
+		return err
+	}
+	err = checkExpiryPolicies(oldInbound)
+	if err != nil {
+		return err
+	}
+	err = checkQuotaPolicy(oldInbound)
+	if err != nil {
+		return err
+	}
+	err = s.checkFallbackInbounds(oldInbound)
+	if err != nil {
+		return err
+	}
 
+	var changes *clientChanges
 	db := database.GetDB()
-	return db.Save(oldInbound).Error
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if wasUsed && oldInbound.Up == 0 && oldInbound.Down == 0 {
+			err := recordTrafficReset(tx, oldInbound.Id, "", oldUp, oldDown, oldInbound.Total, model.ResetTriggerManual)
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Save(oldInbound).Error
+		if err != nil {
+			return err
+		}
+		if oldTag != oldInbound.Tag {
+			err = renameFallbackInbound(tx, oldInbound.UserId, oldTag, oldInbound.Tag)
+			if err != nil {
+				return err
+			}
+		}
+		changes, err = s.syncClientTraffics(tx, oldInbound)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	data := inboundEventData(oldInbound)
+	s.webhookService.Emit(WebhookInboundUpdated, data)
+	if wasEnable != oldInbound.Enable {
+		if oldInbound.Enable {
+			s.webhookService.Emit(WebhookInboundEnabled, data)
+		} else {
+			data["reason"] = model.DisableReasonManual
+			s.webhookService.Emit(WebhookInboundDisabled, data)
+		}
+	}
+	if wasUsed && oldInbound.Up == 0 && oldInbound.Down == 0 {
+		data := inboundEventData(oldInbound)
+		data["trigger"] = model.ResetTriggerManual
+		s.webhookService.Emit(WebhookInboundReset, data)
+	}
+	s.emitClientChanges(oldInbound, changes)
+	return nil
 }
 
 func (s *InboundService) ClearTrafficByPort(port int) error {
 	db := database.GetDB()
-	Uperr := db.Model(model.Inbound{}).Where("port = ?", port).Update("up", 0).Error
-	if Uperr != nil {
-		fmt.Println("ClearTrafficByPort error:clear up failed")
-		return Uperr
+	inbound := &model.Inbound{}
+	err := db.Model(model.Inbound{}).Where("port = ?", port).First(inbound).Error
+	if err != nil {
+		return err
 	}
-	Downerr := db.Model(model.Inbound{}).Where("port = ?", port).Update("down", 0).Error
-	if Downerr != nil {
-		fmt.Println("ClearTrafficByPort error:clear down failed")
-		return Downerr
+	traffics, err := s.GetClientTraffics(inbound.Id)
+	if err != nil {
+		return err
 	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := recordTrafficReset(tx, inbound.Id, "", inbound.Up, inbound.Down, inbound.Total, model.ResetTriggerManual)
+		if err != nil {
+			return err
+		}
+		for _, traffic := range traffics {
+			err = recordTrafficReset(tx, inbound.Id, traffic.Email, traffic.Up, traffic.Down, traffic.Total, model.ResetTriggerManual)
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).
+			Updates(map[string]interface{}{"up": 0, "down": 0}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(xray.ClientTraffic{}).Where("inbound_id = ?", inbound.Id).
+			Updates(map[string]interface{}{"up": 0, "down": 0}).Error
+	})
+	if err != nil {
+		return err
+	}
+	data := inboundEventData(inbound)
+	data["trigger"] = model.ResetTriggerManual
+	s.webhookService.Emit(WebhookInboundReset, data)
 	return nil
 }
 
+func (s *InboundService) ResetClientTraffic(email string) error {
+	db := database.GetDB()
+	traffic := &xray.ClientTraffic{}
+	err := db.Model(xray.ClientTraffic{}).Where("email = ?", email).First(traffic).Error
+	if err != nil {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := recordTrafficReset(tx, traffic.InboundId, traffic.Email, traffic.Up, traffic.Down, traffic.Total, model.ResetTriggerManual)
+		if err != nil {
+			return err
+		}
+		return tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).
+			Updates(map[string]interface{}{"up": 0, "down": 0}).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.emitClientReset(traffic, model.ResetTriggerManual)
+	return nil
+}
+
+// SetClientEnable 启用或禁用用户并记录禁用原因，被禁用的用户不会写入 xray 配置，返回状态是否发生变化
+func (s *InboundService) SetClientEnable(email string, enable bool, reason string) (bool, error) {
+	traffic, err := s.GetClientTrafficByEmail(email)
+	if database.IsNotFound(err) {
+		return false, locale.NewError("inbound.clientNotFound", "Email", email)
+	} else if err != nil {
+		return false, err
+	}
+	if traffic.Enable == enable {
+		return false, nil
+	}
+	if enable {
+		reason = ""
+	}
+	db := database.GetDB()
+	err = db.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).
+		Updates(map[string]interface{}{"enable": enable, "disable_reason": reason}).Error
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *InboundService) ClearAllInboundTraffic() error {
 	inbounds, _ := s.GetAllInbounds()
 	for _, inbound := range inbounds {
@@ -200,22 +571,201 @@ func (s *InboundService) AddTraffic(traffics []*xray.Traffic) (err error) {
 	return
 }
 
+func (s *InboundService) AddClientTraffic(traffics []*xray.ClientTraffic) (err error) {
+	if len(traffics) == 0 {
+		return nil
+	}
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	for _, traffic := range traffics {
+		err = tx.Model(xray.ClientTraffic{}).
+			Where("email = ?", traffic.Email).
+			Updates(map[string]interface{}{
+				"up":   gorm.Expr("up + ?", traffic.Up),
+				"down": gorm.Expr("down + ?", traffic.Down),
+			}).Error
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *InboundService) GetClientTraffics(inboundId int) ([]*xray.ClientTraffic, error) {
+	db := database.GetDB()
+	traffics := make([]*xray.ClientTraffic, 0)
+	err := db.Model(xray.ClientTraffic{}).Where("inbound_id = ?", inboundId).Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffics, nil
+}
+
+func (s *InboundService) GetClientTrafficByEmail(email string) (*xray.ClientTraffic, error) {
+	db := database.GetDB()
+	traffic := &xray.ClientTraffic{}
+	err := db.Model(xray.ClientTraffic{}).Where("email = ?", email).First(traffic).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffic, nil
+}
+
+func (s *InboundService) GetClientTrafficBySubToken(token string) (*xray.ClientTraffic, error) {
+	db := database.GetDB()
+	traffic := &xray.ClientTraffic{}
+	err := db.Model(xray.ClientTraffic{}).Where("sub_token = ?", token).First(traffic).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffic, nil
+}
+
+// FindClient 按 vmess/vless 的 id、trojan 的密码或订阅 token 查找用户
+func (s *InboundService) FindClient(key string) (*xray.ClientTraffic, error) {
+	if key == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	traffic, err := s.GetClientTrafficBySubToken(key)
+	if err == nil {
+		return traffic, nil
+	} else if !database.IsNotFound(err) {
+		return nil, err
+	}
+	inbounds, err := s.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		clients, err := inbound.GetClients()
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.Email == "" {
+				continue
+			}
+			if (client.ID != "" && client.ID == key) || (client.Password != "" && client.Password == key) {
+				return s.GetClientTrafficByEmail(client.Email)
+			}
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
 func (s *InboundService) DisableInvalidInbounds() (int64, error) {
 	db := database.GetDB()
 	now := time.Now().Unix() * 1000
-	result := db.Model(model.Inbound{}).
-		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
-		Update("enable", false)
-	err := result.Error
-	count := result.RowsAffected
-	return count, err
+	// 开启自动续期的入站到期时先续期而不是禁用，按首次使用计时但还未使用的入站 expiry_time 为 0，不算到期
+	count, err := s.renewExpired(now)
+	if err != nil {
+		return count, err
+	}
+	clients, err := s.disableInvalidClients(now)
+	count += clients
+	if err != nil {
+		return count, err
+	}
+	// 用量按各入站的计算方式和宽限计算，只能先取出再逐个判断
+	inbounds := make([]*model.Inbound, 0)
+	err = db.Model(model.Inbound{}).
+		Where("(total > 0 or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
+		Find(&inbounds).Error
+	if err != nil || len(inbounds) == 0 {
+		return count, err
+	}
+	for _, inbound := range inbounds {
+		var reason string
+		if inbound.ExpiryTime > 0 && inbound.ExpiryTime <= now {
+			reason = model.DisableReasonExpiry
+		}
+		if inbound.IsQuotaExceeded() && (inbound.OverageAction == "" || inbound.OverageAction == model.OverageDisable) {
+			reason = model.DisableReasonQuota
+		}
+		if reason == "" {
+			continue
+		}
+		result := db.Model(model.Inbound{}).Where("id = ?", inbound.Id).
+			Updates(map[string]interface{}{"enable": false, "disable_reason": reason, "over_quota": false})
+		if result.Error != nil {
+			return count, result.Error
+		}
+		count += result.RowsAffected
+		inbound.Enable = false
+		data := inboundEventData(inbound)
+		data["reason"] = reason
+		s.webhookService.Emit(WebhookInboundDisabled, data)
+	}
+	return count, nil
 }
 
-func (s *InboundService) DisableInboundByPort(port int) error {
+// disableInvalidClients 禁用流量超出或到期的用户并记录原因，重启 xray 时会从配置中去掉这些用户
+func (s *InboundService) disableInvalidClients(now int64) (int64, error) {
 	db := database.GetDB()
-	return db.Model(model.Inbound{}).Where("port = ?", port).Update("enable", false).Error
+	traffics := make([]*xray.ClientTraffic, 0)
+	err := db.Model(xray.ClientTraffic{}).
+		Where("(total > 0 or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
+		Find(&traffics).Error
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, traffic := range traffics {
+		reason := clientDisableReason(traffic, now)
+		if reason == "" {
+			continue
+		}
+		result := db.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).
+			Updates(map[string]interface{}{"enable": false, "disable_reason": reason})
+		if result.Error != nil {
+			return count, result.Error
+		}
+		count += result.RowsAffected
+		logger.Infof("client %v disabled, reason: %v", traffic.Email, reason)
+	}
+	return count, nil
 }
-func (s *InboundService) EnableInboundByPort(port int) error {
+
+func (s *InboundService) setEnableByPort(port int, enable bool) error {
 	db := database.GetDB()
-	return db.Model(model.Inbound{}).Where("port = ?", port).Update("enable", true).Error
+	inbound := &model.Inbound{}
+	err := db.Model(model.Inbound{}).Where("port = ?", port).First(inbound).Error
+	if err != nil {
+		return err
+	}
+	if inbound.Enable == enable {
+		return nil
+	}
+	reason := model.DisableReasonManual
+	if enable {
+		reason = ""
+	}
+	err = db.Model(model.Inbound{}).Where("id = ?", inbound.Id).
+		Updates(map[string]interface{}{"enable": enable, "disable_reason": reason}).Error
+	if err != nil {
+		return err
+	}
+	inbound.Enable = enable
+	data := inboundEventData(inbound)
+	if enable {
+		s.webhookService.Emit(WebhookInboundEnabled, data)
+	} else {
+		data["reason"] = model.DisableReasonManual
+		s.webhookService.Emit(WebhookInboundDisabled, data)
+	}
+	return nil
+}
+
+func (s *InboundService) DisableInboundByPort(port int) error {
+	return s.setEnableByPort(port, false)
+}
+func (s *InboundService) EnableInboundByPort(port int) error {
+	return s.setEnableByPort(port, true)
 }
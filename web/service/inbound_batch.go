@@ -0,0 +1,258 @@
+package service
+
+import (
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/web/entity"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BatchEnable  = "enable"
+	BatchDisable = "disable"
+	BatchDelete  = "delete"
+	BatchReset   = "reset"
+	BatchExtend  = "extend"
+	BatchQuota   = "quota"
+	BatchGroup   = "group"
+)
+
+// GetInboundGroups 返回用户已使用的分组名称
+func (s *InboundService) GetInboundGroups(userId int) ([]string, error) {
+	db := database.GetDB()
+	groups := make([]string, 0)
+	err := db.Model(model.Inbound{}).
+		Where("user_id = ? and group_name is not null and group_name != ''", userId).
+		Distinct("group_name").Order("group_name").Pluck("group_name", &groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// batchInbounds 返回批量操作选中的入站，只能选中该用户自己的入站
+func batchInbounds(tx *gorm.DB, userId int, batch *entity.InboundBatch) ([]*model.Inbound, error) {
+	query := tx.Model(model.Inbound{}).Where("user_id = ?", userId)
+	switch {
+	case batch.All:
+	case len(batch.Ids) > 0:
+		query = query.Where("id in ?", batch.Ids)
+	case batch.Group != "":
+		query = query.Where("group_name = ?", batch.Group)
+	default:
+		return nil, locale.NewError("inbound.noneSelected")
+	}
+	inbounds := make([]*model.Inbound, 0)
+	err := query.Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	return inbounds, nil
+}
+
+func checkInboundBatch(batch *entity.InboundBatch) error {
+	switch batch.Action {
+	case BatchEnable, BatchDisable, BatchDelete, BatchReset, BatchGroup:
+	case BatchExtend:
+		if batch.Days <= 0 {
+			return locale.NewError("inbound.invalidExtendDays", "Days", batch.Days)
+		}
+	case BatchQuota:
+		if batch.Total < 0 {
+			return locale.NewError("inbound.negativeTotal", "Total", batch.Total)
+		}
+	default:
+		return locale.NewError("inbound.unsupportedBatch", "Action", batch.Action)
+	}
+	return nil
+}
+
+// BatchInbounds 在一个事务中对选中的入站执行同一操作，返回受影响的入站数量，
+// 事件在事务提交后统一发送，由调用方只重启一次 xray
+func (s *InboundService) BatchInbounds(userId int, batch *entity.InboundBatch) (int, error) {
+	batch.NewGroup = strings.TrimSpace(batch.NewGroup)
+	err := checkInboundBatch(batch)
+	if err != nil {
+		return 0, err
+	}
+	var inbounds []*model.Inbound
+	var emit []func()
+	db := database.GetDB()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		inbounds, err = batchInbounds(tx, userId, batch)
+		if err != nil {
+			return err
+		}
+		emit = make([]func(), 0, len(inbounds))
+		now := time.Now().UnixMilli()
+		for _, inbound := range inbounds {
+			var fn func()
+			switch batch.Action {
+			case BatchEnable, BatchDisable:
+				fn, err = s.batchSetEnable(tx, inbound, batch.Action == BatchEnable)
+			case BatchDelete:
+				fn, err = s.batchDelete(tx, inbound)
+			case BatchReset:
+				fn, err = s.batchReset(tx, inbound)
+			case BatchExtend:
+				fn, err = s.batchExtend(tx, inbound, batch.Days, now)
+			case BatchQuota:
+				fn, err = s.batchQuota(tx, inbound, batch.Total, now)
+			case BatchGroup:
+				err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("group_name", batch.NewGroup).Error
+			}
+			if err != nil {
+				return locale.NewError("inbound.inboundInvalid", "Remark", inbound.Remark, "Error", err)
+			}
+			if fn != nil {
+				emit = append(emit, fn)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	logger.Infof("batch %v on %v inbounds", batch.Action, len(inbounds))
+	for _, fn := range emit {
+		fn()
+	}
+	return len(inbounds), nil
+}
+
+func (s *InboundService) batchSetEnable(tx *gorm.DB, inbound *model.Inbound, enable bool) (func(), error) {
+	if inbound.Enable == enable {
+		return nil, nil
+	}
+	reason := model.DisableReasonManual
+	if enable {
+		reason = ""
+	}
+	err := tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).
+		Updates(map[string]interface{}{"enable": enable, "disable_reason": reason}).Error
+	if err != nil {
+		return nil, err
+	}
+	inbound.Enable = enable
+	return func() {
+		data := inboundEventData(inbound)
+		if enable {
+			s.webhookService.Emit(WebhookInboundEnabled, data)
+		} else {
+			data["reason"] = model.DisableReasonManual
+			s.webhookService.Emit(WebhookInboundDisabled, data)
+		}
+	}, nil
+}
+
+func (s *InboundService) batchDelete(tx *gorm.DB, inbound *model.Inbound) (func(), error) {
+	emails := make([]string, 0)
+	err := tx.Model(xray.ClientTraffic{}).Where("inbound_id = ?", inbound.Id).Pluck("email", &emails).Error
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Where("inbound_id = ?", inbound.Id).Delete(xray.ClientTraffic{}).Error
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Delete(model.Inbound{}, inbound.Id).Error
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		s.webhookService.Emit(WebhookInboundDeleted, inboundEventData(inbound))
+		s.emitClientChanges(inbound, &clientChanges{removed: emails})
+	}, nil
+}
+
+// batchReset 清零入站和其中全部用户的流量，与单个入站的重置相同
+func (s *InboundService) batchReset(tx *gorm.DB, inbound *model.Inbound) (func(), error) {
+	traffics := make([]*xray.ClientTraffic, 0)
+	err := tx.Model(xray.ClientTraffic{}).Where("inbound_id = ?", inbound.Id).Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	err = recordTrafficReset(tx, inbound.Id, "", inbound.Up, inbound.Down, inbound.Total, model.ResetTriggerManual)
+	if err != nil {
+		return nil, err
+	}
+	for _, traffic := range traffics {
+		err = recordTrafficReset(tx, inbound.Id, traffic.Email, traffic.Up, traffic.Down, traffic.Total, model.ResetTriggerManual)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).
+		Updates(map[string]interface{}{"up": 0, "down": 0}).Error
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Model(xray.ClientTraffic{}).Where("inbound_id = ?", inbound.Id).
+		Updates(map[string]interface{}{"up": 0, "down": 0}).Error
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		data := inboundEventData(inbound)
+		data["trigger"] = model.ResetTriggerManual
+		s.webhookService.Emit(WebhookInboundReset, data)
+		for _, traffic := range traffics {
+			s.emitClientReset(traffic, model.ResetTriggerManual)
+		}
+	}, nil
+}
+
+// batchExtend 延长已设置到期时间的入站，已过期的从现在开始计算，因到期被禁用的重新启用
+func (s *InboundService) batchExtend(tx *gorm.DB, inbound *model.Inbound, days int, now int64) (func(), error) {
+	if inbound.ExpiryTime <= 0 {
+		return nil, nil
+	}
+	inbound.ExpiryTime = renewExpiryTime(inbound.ExpiryTime, days, now)
+	updates := map[string]interface{}{"expiry_time": inbound.ExpiryTime}
+	reenable := !inbound.Enable && inbound.DisableReason == model.DisableReasonExpiry
+	if reenable {
+		updates["enable"] = true
+		updates["disable_reason"] = ""
+		inbound.Enable = true
+	}
+	err := tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Updates(updates).Error
+	if err != nil {
+		return nil, err
+	}
+	if !reenable {
+		return nil, nil
+	}
+	return func() {
+		s.webhookService.Emit(WebhookInboundEnabled, inboundEventData(inbound))
+	}, nil
+}
+
+// batchQuota 修改总流量，因流量超出被禁用且新的总量够用的入站重新启用
+func (s *InboundService) batchQuota(tx *gorm.DB, inbound *model.Inbound, total int64, now int64) (func(), error) {
+	inbound.Total = total
+	updates := map[string]interface{}{"total": total}
+	reenable := !inbound.Enable && inbound.DisableReason == model.DisableReasonQuota &&
+		!inbound.IsQuotaExceeded() && (inbound.ExpiryTime == 0 || inbound.ExpiryTime > now)
+	if reenable {
+		updates["enable"] = true
+		updates["disable_reason"] = ""
+		inbound.Enable = true
+	}
+	err := tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Updates(updates).Error
+	if err != nil {
+		return nil, err
+	}
+	if !reenable {
+		return nil, nil
+	}
+	return func() {
+		s.webhookService.Emit(WebhookInboundEnabled, inboundEventData(inbound))
+	}, nil
+}
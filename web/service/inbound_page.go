@@ -0,0 +1,152 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/web/entity"
+	"x-ui/web/locale"
+	"x-ui/xray"
+)
+
+const (
+	InboundStatusEnabled   = "enabled"
+	InboundStatusDisabled  = "disabled"
+	InboundStatusExpired   = "expired"
+	InboundStatusExpiring  = "expiring"
+	InboundStatusOverQuota = "over_quota"
+)
+
+const (
+	defaultPageSize     = 20
+	maxPageSize         = 200
+	defaultExpiringDays = 7
+)
+
+// quotaUsedSQL 与 Inbound.QuotaUsed 的计算方式一致，quotaLimitSQL 与 Inbound.QuotaLimit 一致
+const (
+	quotaUsedSQL = "(case quota_mode when 'down' then down when 'up' then up " +
+		"when 'multiplier' then (up + down) * (case when quota_multiplier > 0 then quota_multiplier else 1 end) " +
+		"else up + down end)"
+	quotaLimitSQL = "(total + total * coalesce(quota_grace, 0) / 100)"
+)
+
+// inboundOrders 是允许排序的字段，未设置到期时间的入站按到期时间排序时排在最后
+var inboundOrders = map[string][]string{
+	"id":      {"id"},
+	"port":    {"port"},
+	"remark":  {"remark"},
+	"traffic": {"up + down"},
+	"expiry":  {"expiry_time = 0", "expiry_time"},
+}
+
+// escapeLike 转义 LIKE 中的通配符，配合 escape '\' 使用
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// GetInboundPage 按条件分页查询入站，结果写入 query 的 Total 和 List
+func (s *InboundService) GetInboundPage(userId int, query *entity.InboundQuery) error {
+	if query.Current < 1 {
+		query.Current = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = defaultPageSize
+	}
+	if query.PageSize > maxPageSize {
+		query.PageSize = maxPageSize
+	}
+	if query.OrderBy == "" {
+		query.OrderBy = "id"
+	}
+	orders, ok := inboundOrders[query.OrderBy]
+	if !ok {
+		return locale.NewError("inbound.unsupportedOrder", "Field", query.OrderBy)
+	}
+
+	db := database.GetDB()
+	tx := db.Model(model.Inbound{}).Where("user_id = ?", userId)
+	key := strings.TrimSpace(query.Key)
+	if key != "" {
+		like := "%" + escapeLike(key) + "%"
+		emails := db.Model(xray.ClientTraffic{}).Select("inbound_id").Where(`email like ? escape '\'`, like)
+		cond := db.Where(`remark like ? escape '\'`, like).
+			Or(`tag like ? escape '\'`, like).
+			Or(`protocol like ? escape '\'`, like).
+			Or("id in (?)", emails)
+		if port, err := strconv.Atoi(key); err == nil {
+			cond = cond.Or("port = ?", port)
+		}
+		tx = tx.Where(cond)
+	}
+	if query.Group != "" {
+		tx = tx.Where("group_name = ?", query.Group)
+	}
+
+	now := time.Now().UnixMilli()
+	switch query.Status {
+	case "":
+	case InboundStatusEnabled:
+		tx = tx.Where("enable = ?", true)
+	case InboundStatusDisabled:
+		tx = tx.Where("enable = ?", false)
+	case InboundStatusExpired:
+		tx = tx.Where("expiry_time > 0 and expiry_time <= ?", now)
+	case InboundStatusExpiring:
+		days := query.ExpiringDays
+		if days <= 0 {
+			days = defaultExpiringDays
+		}
+		tx = tx.Where("expiry_time > ? and expiry_time <= ?", now, now+int64(days)*dayMillis)
+	case InboundStatusOverQuota:
+		tx = tx.Where("total > 0 and " + quotaUsedSQL + " >= " + quotaLimitSQL)
+	default:
+		return locale.NewError("inbound.unsupportedStatus", "Status", query.Status)
+	}
+
+	var total int64
+	err := tx.Count(&total).Error
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if query.Desc && order != "expiry_time = 0" {
+			order += " desc"
+		}
+		tx = tx.Order(order)
+	}
+	if query.OrderBy != "id" {
+		tx = tx.Order("id")
+	}
+	inbounds := make([]*model.Inbound, 0, query.PageSize)
+	err = tx.Preload("ClientStats").
+		Offset((query.Current - 1) * query.PageSize).Limit(query.PageSize).
+		Find(&inbounds).Error
+	if err != nil {
+		return err
+	}
+	query.Total = int(total)
+	query.List = inbounds
+	return nil
+}
+
+// InboundSummary 是用户全部入站的流量汇总
+type InboundSummary struct {
+	Count int64 `json:"count"`
+	Up    int64 `json:"up"`
+	Down  int64 `json:"down"`
+}
+
+func (s *InboundService) GetInboundSummary(userId int) (*InboundSummary, error) {
+	db := database.GetDB()
+	summary := &InboundSummary{}
+	err := db.Model(model.Inbound{}).Where("user_id = ?", userId).
+		Select("count(*) as count, coalesce(sum(up), 0) as up, coalesce(sum(down), 0) as down").
+		Scan(summary).Error
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
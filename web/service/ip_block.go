@@ -0,0 +1,152 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/web/locale"
+)
+
+const (
+	IpBlockSourceManual = "manual"
+	IpBlockSourceSSH    = "ssh"
+)
+
+// 封禁列表的内存缓存，每个请求都要检查，避免频繁查库
+var ipBlockLock sync.RWMutex
+var ipBlockCache map[string]int64
+
+type IpBlockService struct {
+}
+
+func (s *IpBlockService) GetBlockedIps() ([]*model.BlockedIp, error) {
+	db := database.GetDB()
+	ips := make([]*model.BlockedIp, 0)
+	err := db.Model(model.BlockedIp{}).Order("id desc").Find(&ips).Error
+	if err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+// BlockIp 封禁 ip，duration 为 0 表示永久，已封禁的 ip 会更新原因和到期时间
+func (s *IpBlockService) BlockIp(ip string, reason string, source string, duration time.Duration) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return locale.NewError("ipBlock.invalidIp", "IP", ip)
+	}
+	if parsed.IsLoopback() || parsed.IsUnspecified() {
+		return locale.NewError("ipBlock.localIp", "IP", ip)
+	}
+	ip = parsed.String()
+	now := time.Now()
+	var expiresAt int64
+	if duration > 0 {
+		expiresAt = now.Add(duration).UnixMilli()
+	}
+
+	db := database.GetDB()
+	blocked := &model.BlockedIp{}
+	err := db.Model(model.BlockedIp{}).Where("ip = ?", ip).First(blocked).Error
+	if database.IsNotFound(err) {
+		blocked = &model.BlockedIp{
+			Ip:        ip,
+			Reason:    reason,
+			Source:    source,
+			CreatedAt: now.UnixMilli(),
+			ExpiresAt: expiresAt,
+		}
+		err = db.Create(blocked).Error
+	} else if err == nil {
+		err = db.Model(blocked).Updates(map[string]interface{}{
+			"reason":     reason,
+			"source":     source,
+			"expires_at": expiresAt,
+		}).Error
+	}
+	if err != nil {
+		return err
+	}
+	s.invalidateCache()
+	return nil
+}
+
+func (s *IpBlockService) UnblockIp(id int) error {
+	db := database.GetDB()
+	err := db.Delete(model.BlockedIp{}, id).Error
+	if err != nil {
+		return err
+	}
+	s.invalidateCache()
+	return nil
+}
+
+// UnblockIpAddr 按地址解封，供命令行在把自己锁在面板外时使用
+func (s *IpBlockService) UnblockIpAddr(ip string) (int64, error) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	db := database.GetDB()
+	result := db.Where("ip = ?", ip).Delete(model.BlockedIp{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	s.invalidateCache()
+	return result.RowsAffected, nil
+}
+
+// CleanExpired 删除已到期的封禁记录
+func (s *IpBlockService) CleanExpired() {
+	db := database.GetDB()
+	result := db.Where("expires_at > 0 and expires_at <= ?", time.Now().UnixMilli()).Delete(model.BlockedIp{})
+	if result.Error != nil {
+		logger.Warning("clean expired blocked ip failed:", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		s.invalidateCache()
+	}
+}
+
+func (s *IpBlockService) invalidateCache() {
+	ipBlockLock.Lock()
+	ipBlockCache = nil
+	ipBlockLock.Unlock()
+}
+
+func (s *IpBlockService) loadCache() map[string]int64 {
+	ipBlockLock.RLock()
+	cache := ipBlockCache
+	ipBlockLock.RUnlock()
+	if cache != nil {
+		return cache
+	}
+
+	ips, err := s.GetBlockedIps()
+	if err != nil {
+		logger.Warning("load blocked ip failed:", err)
+		return map[string]int64{}
+	}
+	cache = make(map[string]int64, len(ips))
+	for _, blocked := range ips {
+		cache[blocked.Ip] = blocked.ExpiresAt
+	}
+	ipBlockLock.Lock()
+	ipBlockCache = cache
+	ipBlockLock.Unlock()
+	return cache
+}
+
+func (s *IpBlockService) IsBlocked(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	expiresAt, ok := s.loadCache()[ip]
+	if !ok {
+		return false
+	}
+	return expiresAt == 0 || expiresAt > time.Now().UnixMilli()
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"x-ui/config"
+)
+
+// metricsWriter 按 Prometheus 文本格式 (version 0.0.4) 输出指标
+type metricsWriter struct {
+	builder strings.Builder
+}
+
+func (w *metricsWriter) head(name string, help string, metricType string) {
+	fmt.Fprintf(&w.builder, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(&w.builder, "# TYPE %s %s\n", name, metricType)
+}
+
+func (w *metricsWriter) sample(name string, labels map[string]string, value interface{}) {
+	w.builder.WriteString(name)
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", key, escapeLabelValue(labels[key])))
+		}
+		w.builder.WriteString("{")
+		w.builder.WriteString(strings.Join(pairs, ","))
+		w.builder.WriteString("}")
+	}
+	fmt.Fprintf(&w.builder, " %v\n", value)
+}
+
+func (w *metricsWriter) single(name string, help string, metricType string, value interface{}) {
+	w.head(name, help, metricType)
+	w.sample(name, nil, value)
+}
+
+func (w *metricsWriter) String() string {
+	return w.builder.String()
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+type MetricsService struct {
+	xrayService    XrayService
+	serverService  ServerService
+	inboundService InboundService
+}
+
+// 多个抓取请求可能同时到达，上一次的状态用于计算速率，需要加锁
+var metricsStatusLock sync.Mutex
+var metricsLastStatus *Status
+
+func (s *MetricsService) GetMetrics() (string, error) {
+	w := &metricsWriter{}
+
+	w.head("xui_info", "x-ui panel version", "gauge")
+	w.sample("xui_info", map[string]string{"version": config.GetVersion()}, 1)
+
+	metricsStatusLock.Lock()
+	metricsLastStatus = s.serverService.GetStatus(metricsLastStatus)
+	status := metricsLastStatus
+	metricsStatusLock.Unlock()
+	s.writeHostMetrics(w, status)
+	s.writeXrayMetrics(w, status)
+
+	err := s.writeInboundMetrics(w)
+	if err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+func (s *MetricsService) writeHostMetrics(w *metricsWriter, status *Status) {
+	w.single("xui_host_cpu_percent", "Host CPU usage percent", "gauge", status.Cpu)
+	w.single("xui_host_memory_used_bytes", "Host used memory in bytes", "gauge", status.Mem.Current)
+	w.single("xui_host_memory_total_bytes", "Host total memory in bytes", "gauge", status.Mem.Total)
+	w.single("xui_host_swap_used_bytes", "Host used swap in bytes", "gauge", status.Swap.Current)
+	w.single("xui_host_swap_total_bytes", "Host total swap in bytes", "gauge", status.Swap.Total)
+	w.single("xui_host_disk_used_bytes", "Used bytes of the root filesystem", "gauge", status.Disk.Current)
+	w.single("xui_host_disk_total_bytes", "Total bytes of the root filesystem", "gauge", status.Disk.Total)
+	w.single("xui_host_uptime_seconds", "Host uptime in seconds", "gauge", status.Uptime)
+
+	w.head("xui_host_load", "Host load average", "gauge")
+	periods := []string{"1m", "5m", "15m"}
+	for i, load := range status.Loads {
+		if i >= len(periods) {
+			break
+		}
+		w.sample("xui_host_load", map[string]string{"period": periods[i]}, load)
+	}
+
+	w.single("xui_host_tcp_connections", "Number of TCP connections on the host", "gauge", status.TcpCount)
+	w.single("xui_host_udp_connections", "Number of UDP connections on the host", "gauge", status.UdpCount)
+	w.single("xui_host_network_up_bytes_per_second", "Upload speed of all interfaces", "gauge", status.NetIO.Up)
+	w.single("xui_host_network_down_bytes_per_second", "Download speed of all interfaces", "gauge", status.NetIO.Down)
+	w.single("xui_host_network_sent_bytes_total", "Bytes sent by all interfaces since boot", "counter", status.NetTraffic.Sent)
+	w.single("xui_host_network_received_bytes_total", "Bytes received by all interfaces since boot", "counter", status.NetTraffic.Recv)
+}
+
+func (s *MetricsService) writeXrayMetrics(w *metricsWriter, status *Status) {
+	up := 0
+	if status.Xray.State == Running {
+		up = 1
+	}
+	w.single("xui_xray_up", "Whether xray is running", "gauge", up)
+	w.head("xui_xray_info", "Running xray version", "gauge")
+	w.sample("xui_xray_info", map[string]string{"version": status.Xray.Version}, 1)
+	w.single("xui_xray_restarts_total", "Number of xray restarts since the panel started", "counter", s.xrayService.GetXrayRestartCount())
+}
+
+func (s *MetricsService) writeInboundMetrics(w *metricsWriter) error {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return err
+	}
+
+	inboundLabels := func(tag string, remark string, protocol string, port int) map[string]string {
+		return map[string]string{
+			"tag":      tag,
+			"remark":   remark,
+			"protocol": protocol,
+			"port":     fmt.Sprint(port),
+		}
+	}
+
+	w.head("xui_inbound_enabled", "Whether the inbound is enabled", "gauge")
+	for _, inbound := range inbounds {
+		enable := 0
+		if inbound.Enable {
+			enable = 1
+		}
+		w.sample("xui_inbound_enabled", inboundLabels(inbound.Tag, inbound.Remark, string(inbound.Protocol), inbound.Port), enable)
+	}
+	w.head("xui_inbound_up_bytes_total", "Uploaded bytes of the inbound", "counter")
+	for _, inbound := range inbounds {
+		w.sample("xui_inbound_up_bytes_total", inboundLabels(inbound.Tag, inbound.Remark, string(inbound.Protocol), inbound.Port), inbound.Up)
+	}
+	w.head("xui_inbound_down_bytes_total", "Downloaded bytes of the inbound", "counter")
+	for _, inbound := range inbounds {
+		w.sample("xui_inbound_down_bytes_total", inboundLabels(inbound.Tag, inbound.Remark, string(inbound.Protocol), inbound.Port), inbound.Down)
+	}
+	w.head("xui_inbound_quota_bytes", "Traffic quota of the inbound, 0 means unlimited", "gauge")
+	for _, inbound := range inbounds {
+		w.sample("xui_inbound_quota_bytes", inboundLabels(inbound.Tag, inbound.Remark, string(inbound.Protocol), inbound.Port), inbound.Total)
+	}
+	w.head("xui_inbound_expiry_timestamp_seconds", "Expiry time of the inbound, 0 means never", "gauge")
+	for _, inbound := range inbounds {
+		w.sample("xui_inbound_expiry_timestamp_seconds", inboundLabels(inbound.Tag, inbound.Remark, string(inbound.Protocol), inbound.Port), inbound.ExpiryTime/1000)
+	}
+
+	clientLabels := func(tag string, remark string, email string) map[string]string {
+		return map[string]string{
+			"tag":    tag,
+			"remark": remark,
+			"email":  email,
+		}
+	}
+	type clientSample struct {
+		labels map[string]string
+		value  int64
+	}
+	ups := make([]clientSample, 0)
+	downs := make([]clientSample, 0)
+	quotas := make([]clientSample, 0)
+	expiries := make([]clientSample, 0)
+	for _, inbound := range inbounds {
+		for _, client := range inbound.ClientStats {
+			labels := clientLabels(inbound.Tag, inbound.Remark, client.Email)
+			ups = append(ups, clientSample{labels, client.Up})
+			downs = append(downs, clientSample{labels, client.Down})
+			quotas = append(quotas, clientSample{labels, client.Total})
+			expiries = append(expiries, clientSample{labels, client.ExpiryTime / 1000})
+		}
+	}
+	writeClientSamples := func(name string, help string, metricType string, samples []clientSample) {
+		w.head(name, help, metricType)
+		for _, sample := range samples {
+			w.sample(name, sample.labels, sample.value)
+		}
+	}
+	writeClientSamples("xui_client_up_bytes_total", "Uploaded bytes of the client", "counter", ups)
+	writeClientSamples("xui_client_down_bytes_total", "Downloaded bytes of the client", "counter", downs)
+	writeClientSamples("xui_client_quota_bytes", "Traffic quota of the client, 0 means unlimited", "gauge", quotas)
+	writeClientSamples("xui_client_expiry_timestamp_seconds", "Expiry time of the client, 0 means never", "gauge", expiries)
+	return nil
+}
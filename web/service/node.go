@@ -0,0 +1,457 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+var nodeHttpClient = &http.Client{
+	Timeout: time.Second * 15,
+}
+
+// 节点使用自签名证书时不校验证书
+var nodeInsecureHttpClient = &http.Client{
+	Timeout: time.Second * 15,
+	Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+}
+
+// nodeResponse 与 entity.Msg 相同，obj 留到调用处按需要的类型解析
+type nodeResponse struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+// NodeOverview 是节点管理页面显示的节点概况，Id 为 0 表示本机，Error 不为空表示节点无法访问
+type NodeOverview struct {
+	Id     int         `json:"id"`
+	Name   string      `json:"name"`
+	Online bool        `json:"online"`
+	Error  string      `json:"error"`
+	Status *NodeStatus `json:"status"`
+}
+
+type NodeService struct {
+	inboundService   InboundService
+	nodeAgentService NodeAgentService
+}
+
+func (s *NodeService) GetNodes() ([]*model.Node, error) {
+	db := database.GetDB()
+	nodes := make([]*model.Node, 0)
+	err := db.Model(model.Node{}).Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (s *NodeService) GetNode(id int) (*model.Node, error) {
+	db := database.GetDB()
+	node := &model.Node{}
+	err := db.Model(model.Node{}).First(node, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (s *NodeService) checkNode(node *model.Node) error {
+	if !strings.HasPrefix(node.Url, "http://") && !strings.HasPrefix(node.Url, "https://") {
+		return locale.NewError("node.urlScheme")
+	}
+	u, err := url.Parse(node.Url)
+	if err != nil || u.Host == "" {
+		return locale.NewError("node.invalidUrl", "Url", node.Url)
+	}
+	if !strings.HasSuffix(node.Url, "/") {
+		node.Url += "/"
+	}
+	if node.Token == "" {
+		return locale.NewError("node.emptyToken")
+	}
+	if node.Name == "" {
+		node.Name = u.Host
+	}
+	return nil
+}
+
+func (s *NodeService) AddNode(node *model.Node) error {
+	err := s.checkNode(node)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Create(node).Error
+}
+
+func (s *NodeService) UpdateNode(node *model.Node) error {
+	err := s.checkNode(node)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Model(model.Node{}).Where("id = ?", node.Id).Updates(map[string]interface{}{
+		"name":         node.Name,
+		"url":          node.Url,
+		"token":        node.Token,
+		"insecure":     node.Insecure,
+		"enable":       node.Enable,
+		"sync_clients": node.SyncClients,
+	}).Error
+}
+
+func (s *NodeService) DelNode(id int) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("node_id = ?", id).Delete(model.NodeClientTraffic{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(model.Node{}, id).Error
+	})
+}
+
+// call 以 POST 请求节点接口，body 以 JSON 发送，成功时把返回的 obj 解析到 obj 中
+func (s *NodeService) call(node *model.Node, path string, body interface{}, obj interface{}) error {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(http.MethodPost, node.Url+"node/"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+node.Token)
+	client := nodeHttpClient
+	if node.Insecure {
+		client = nodeInsecureHttpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return locale.NewError("node.wrongToken")
+	case http.StatusNotFound:
+		return locale.NewError("node.apiNotFound")
+	default:
+		return locale.NewError("node.badStatus", "Status", resp.Status)
+	}
+	response := &nodeResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return locale.NewError("node.badResponse", "Error", err)
+	}
+	if !response.Success {
+		return common.NewError(response.Msg)
+	}
+	if obj != nil && len(response.Obj) > 0 {
+		return json.Unmarshal(response.Obj, obj)
+	}
+	return nil
+}
+
+// eachNode 并发地对每个节点执行 fn，等待全部完成
+func eachNode(nodes []*model.Node, fn func(i int, node *model.Node)) {
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *model.Node) {
+			defer wg.Done()
+			fn(i, node)
+		}(i, node)
+	}
+	wg.Wait()
+}
+
+func (s *NodeService) GetNodeStatus(node *model.Node) (*NodeStatus, error) {
+	status := &NodeStatus{}
+	err := s.call(node, "status", nil, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// TestNode 检查节点能否访问，用于保存前测试填写的地址和 Token
+func (s *NodeService) TestNode(node *model.Node) (*NodeStatus, error) {
+	err := s.checkNode(node)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetNodeStatus(node)
+}
+
+// GetOverviews 返回本机和所有节点的状态，停用的节点不请求，localName 为按请求语言翻译的本机名称
+func (s *NodeService) GetOverviews(localName string) ([]*NodeOverview, error) {
+	nodes, err := s.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	local := &NodeOverview{Name: localName, Online: true}
+	local.Status, err = s.nodeAgentService.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+	overviews := make([]*NodeOverview, len(nodes))
+	eachNode(nodes, func(i int, node *model.Node) {
+		overview := &NodeOverview{Id: node.Id, Name: node.Name}
+		overviews[i] = overview
+		if !node.Enable {
+			return
+		}
+		status, err := s.GetNodeStatus(node)
+		if err != nil {
+			overview.Error = err.Error()
+			return
+		}
+		overview.Online = true
+		overview.Status = status
+	})
+	return append([]*NodeOverview{local}, overviews...), nil
+}
+
+func (s *NodeService) GetNodeInbounds(id int) ([]*model.Inbound, error) {
+	node, err := s.GetNode(id)
+	if err != nil {
+		return nil, err
+	}
+	inbounds := make([]*model.Inbound, 0)
+	err = s.call(node, "inbounds", nil, &inbounds)
+	if err != nil {
+		return nil, err
+	}
+	return inbounds, nil
+}
+
+// PushInbound 把本机的入站推送到节点，节点上已有同端口的入站时覆盖其配置
+func (s *NodeService) PushInbound(id int, inboundId int) (*model.Inbound, error) {
+	node, err := s.GetNode(id)
+	if err != nil {
+		return nil, err
+	}
+	inbound, err := s.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return nil, err
+	}
+	pushed := &model.Inbound{}
+	err = s.call(node, "inbound/push", inbound, pushed)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("inbound %v pushed to node %v", inbound.Tag, node.Name)
+	return pushed, nil
+}
+
+func (s *NodeService) DelNodeInbound(id int, inboundId int) error {
+	node, err := s.GetNode(id)
+	if err != nil {
+		return err
+	}
+	return s.call(node, "inbound/del/"+strconv.Itoa(inboundId), nil, nil)
+}
+
+func (s *NodeService) RestartNodeXray(id int) error {
+	node, err := s.GetNode(id)
+	if err != nil {
+		return err
+	}
+	return s.call(node, "xray/restart", nil, nil)
+}
+
+func (s *NodeService) setNodeClientEnable(node *model.Node, email string, enable bool) error {
+	body := map[string]interface{}{
+		"email":  email,
+		"enable": enable,
+	}
+	return s.call(node, "client/enable", body, nil)
+}
+
+// clientDisableReason 判断用户在共享的总流量和到期时间内是否可用，不可用时返回禁用原因
+func clientDisableReason(traffic *xray.ClientTraffic, now int64) string {
+	if traffic.Total > 0 && traffic.Up+traffic.Down >= traffic.Total {
+		return model.DisableReasonQuota
+	}
+	if traffic.ExpiryTime > 0 && traffic.ExpiryTime <= now {
+		return model.DisableReasonExpiry
+	}
+	return ""
+}
+
+// addNodeClientTraffic 把节点上与本机同名的用户自上次同步以来新增的流量计入本机用户，
+// 首次同步时只记录当前流量，加入同步之前的用量不计入
+func (s *NodeService) addNodeClientTraffic(nodeId int, traffics []*xray.ClientTraffic, locals map[string]*xray.ClientTraffic) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, traffic := range traffics {
+			if locals[traffic.Email] == nil {
+				continue
+			}
+			last := &model.NodeClientTraffic{}
+			err := tx.Model(model.NodeClientTraffic{}).
+				Where("node_id = ? and email = ?", nodeId, traffic.Email).First(last).Error
+			if database.IsNotFound(err) {
+				err = tx.Create(&model.NodeClientTraffic{
+					NodeId: nodeId,
+					Email:  traffic.Email,
+					Up:     traffic.Up,
+					Down:   traffic.Down,
+				}).Error
+				if err != nil {
+					return err
+				}
+				continue
+			} else if err != nil {
+				return err
+			}
+			up := traffic.Up - last.Up
+			down := traffic.Down - last.Down
+			// 节点上的流量被重置过，重置后的用量都是新增的
+			if up < 0 || down < 0 {
+				up = traffic.Up
+				down = traffic.Down
+			}
+			if up == 0 && down == 0 {
+				continue
+			}
+			err = tx.Model(xray.ClientTraffic{}).Where("email = ?", traffic.Email).
+				Updates(map[string]interface{}{
+					"up":   gorm.Expr("up + ?", up),
+					"down": gorm.Expr("down + ?", down),
+				}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(model.NodeClientTraffic{}).Where("id = ?", last.Id).
+				Updates(map[string]interface{}{"up": traffic.Up, "down": traffic.Down}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SyncClients 把开启了用户同步的节点上同名用户新增的流量计入本机用户，再按本机用户的总流量和到期时间
+// 在本机和各节点上统一启用或禁用该用户，返回本机是否需要重启 xray。无法访问的节点跳过，下次同步时补上
+func (s *NodeService) SyncClients() (bool, error) {
+	db := database.GetDB()
+	nodes := make([]*model.Node, 0)
+	err := db.Model(model.Node{}).Where("enable = ? and sync_clients = ?", true, true).Find(&nodes).Error
+	if err != nil || len(nodes) == 0 {
+		return false, err
+	}
+
+	nodeTraffics := make([][]*xray.ClientTraffic, len(nodes))
+	eachNode(nodes, func(i int, node *model.Node) {
+		traffics := make([]*xray.ClientTraffic, 0)
+		err := s.call(node, "clients", nil, &traffics)
+		if err != nil {
+			logger.Warning("get node clients failed:", node.Name, err)
+			return
+		}
+		nodeTraffics[i] = traffics
+	})
+
+	locals, err := s.nodeAgentService.GetClientTraffics()
+	if err != nil {
+		return false, err
+	}
+	localMap := make(map[string]*xray.ClientTraffic, len(locals))
+	for _, traffic := range locals {
+		localMap[traffic.Email] = traffic
+	}
+	synced := map[string]bool{}
+	for i, node := range nodes {
+		err = s.addNodeClientTraffic(node.Id, nodeTraffics[i], localMap)
+		if err != nil {
+			return false, err
+		}
+		for _, traffic := range nodeTraffics[i] {
+			if localMap[traffic.Email] != nil {
+				synced[traffic.Email] = true
+			}
+		}
+	}
+	if len(synced) == 0 {
+		return false, nil
+	}
+
+	// 重新读取加上节点流量后的用量
+	locals, err = s.nodeAgentService.GetClientTraffics()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UnixMilli()
+	needRestart := false
+	allowed := map[string]bool{}
+	for _, traffic := range locals {
+		if !synced[traffic.Email] {
+			continue
+		}
+		reason := clientDisableReason(traffic, now)
+		enable := reason == ""
+		// 只重新启用因流量超出或到期被禁用的用户，手动禁用的用户保持禁用，节点上也一起禁用
+		if enable && !traffic.Enable &&
+			traffic.DisableReason != model.DisableReasonQuota && traffic.DisableReason != model.DisableReasonExpiry {
+			enable = false
+		}
+		allowed[traffic.Email] = enable
+		if traffic.Enable == enable {
+			continue
+		}
+		changed, err := s.inboundService.SetClientEnable(traffic.Email, enable, reason)
+		if err != nil {
+			return needRestart, err
+		}
+		if !changed {
+			continue
+		}
+		if enable {
+			logger.Infof("client %v enabled, shared quota available", traffic.Email)
+		} else {
+			logger.Infof("client %v disabled, shared quota exceeded or expired", traffic.Email)
+		}
+		needRestart = true
+	}
+	eachNode(nodes, func(i int, node *model.Node) {
+		for _, traffic := range nodeTraffics[i] {
+			enable, ok := allowed[traffic.Email]
+			if !ok || traffic.Enable == enable {
+				continue
+			}
+			err := s.setNodeClientEnable(node, traffic.Email, enable)
+			if err != nil {
+				logger.Warning("set node client enable failed:", node.Name, traffic.Email, err)
+			}
+		}
+	})
+	return needRestart, nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"x-ui/config"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/web/locale"
+	"x-ui/xray"
+)
+
+// NodeStatus 是节点接口返回的本机状态和入站流量汇总
+type NodeStatus struct {
+	Version      string  `json:"version"`
+	Status       *Status `json:"status"`
+	InboundCount int     `json:"inboundCount"`
+	ClientCount  int     `json:"clientCount"`
+	Up           int64   `json:"up"`
+	Down         int64   `json:"down"`
+}
+
+// 上次通过节点接口获取的状态，用于计算两次请求之间的网速
+var agentStatusLock sync.Mutex
+var agentLastStatus *Status
+
+// NodeAgentService 实现节点接口，供主面板查看状态、推送入站和同步用户
+type NodeAgentService struct {
+	inboundService InboundService
+	serverService  ServerService
+	xrayService    XrayService
+	userService    UserService
+}
+
+func (s *NodeAgentService) GetStatus() (*NodeStatus, error) {
+	agentStatusLock.Lock()
+	agentLastStatus = s.serverService.GetStatus(agentLastStatus)
+	status := agentLastStatus
+	agentStatusLock.Unlock()
+
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	nodeStatus := &NodeStatus{
+		Version:      config.GetVersion(),
+		Status:       status,
+		InboundCount: len(inbounds),
+	}
+	for _, inbound := range inbounds {
+		nodeStatus.Up += inbound.Up
+		nodeStatus.Down += inbound.Down
+		nodeStatus.ClientCount += len(inbound.ClientStats)
+	}
+	return nodeStatus, nil
+}
+
+func (s *NodeAgentService) GetClientTraffics() ([]*xray.ClientTraffic, error) {
+	db := database.GetDB()
+	traffics := make([]*xray.ClientTraffic, 0)
+	err := db.Model(xray.ClientTraffic{}).Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffics, nil
+}
+
+// PushInbound 按端口添加或覆盖入站，覆盖时保留本机已统计的流量，返回保存后的入站
+func (s *NodeAgentService) PushInbound(inbound *model.Inbound) (*model.Inbound, error) {
+	inbound.Id = 0
+	inbound.ClientStats = nil
+	if inbound.Port == 0 {
+		return nil, locale.NewError("node.emptyPort")
+	}
+	inbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
+
+	db := database.GetDB()
+	old := &model.Inbound{}
+	err := db.Model(model.Inbound{}).Where("port = ?", inbound.Port).First(old).Error
+	if database.IsNotFound(err) {
+		user, err := s.userService.GetFirstUser()
+		if err != nil {
+			return nil, err
+		}
+		inbound.UserId = user.Id
+		inbound.Up = 0
+		inbound.Down = 0
+		inbound.CreatedAt = 0
+		inbound.LastResetAt = 0
+		inbound.DisableReason = ""
+		inbound.OverQuota = false
+		err = s.inboundService.AddInbound(inbound)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		inbound.Id = old.Id
+		inbound.Up = old.Up
+		inbound.Down = old.Down
+		err = s.inboundService.UpdateInbound(inbound)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.xrayService.SetToNeedRestart()
+	return s.inboundService.GetInbound(inbound.Id)
+}
+
+func (s *NodeAgentService) DelInbound(id int) error {
+	err := s.inboundService.DelInbound(id)
+	if err != nil {
+		return err
+	}
+	s.xrayService.SetToNeedRestart()
+	return nil
+}
+
+// SetClientEnable 由主面板统一启用或禁用用户，按手动禁用处理，本机的定时重置和续期不会重新启用
+func (s *NodeAgentService) SetClientEnable(email string, enable bool) error {
+	changed, err := s.inboundService.SetClientEnable(email, enable, model.DisableReasonManual)
+	if err != nil {
+		return err
+	}
+	if changed {
+		s.xrayService.SetToNeedRestart()
+	}
+	return nil
+}
+
+func (s *NodeAgentService) RestartXray() error {
+	return s.xrayService.RestartXray(true)
+}
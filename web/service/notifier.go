@@ -0,0 +1,339 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"x-ui/util/common"
+)
+
+// Notifier 是通知渠道的统一接口
+type Notifier interface {
+	Send(event NotifyEvent, title string, msg string) error
+}
+
+// notifyConfig 汇总了所有渠道类型可能用到的配置项，各渠道只读取自己需要的字段
+type notifyConfig struct {
+	Url      string `json:"url"`
+	Secret   string `json:"secret"`
+	ChatId   int64  `json:"chatId"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Tls      bool   `json:"tls"`
+	Server   string `json:"server"`
+	Topic    string `json:"topic"`
+	Token    string `json:"token"`
+	Priority int    `json:"priority"`
+}
+
+var notifyHttpClient = &http.Client{
+	Timeout: time.Second * 15,
+}
+
+func postNotify(req *http.Request) error {
+	resp, err := notifyHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return common.NewErrorf("unexpected status %v: %s", resp.Status, string(body))
+	}
+	return nil
+}
+
+func postJson(url string, obj interface{}, headers map[string]string) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return postNotify(req)
+}
+
+type telegramNotifier struct {
+	telegramService TelegramService
+	chatId          int64
+}
+
+func (n *telegramNotifier) Send(event NotifyEvent, title string, msg string) error {
+	return n.telegramService.SendMsgToChat(n.chatId, title+"\r\n"+msg)
+}
+
+// webhookNotifier 以 JSON 推送通知，配置了 secret 时在 X-XUI-Signature 中附带 HMAC-SHA256 签名
+func signPayload(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookNotifier struct {
+	url    string
+	secret string
+}
+
+func (n *webhookNotifier) Send(event NotifyEvent, title string, msg string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"event":   event,
+		"title":   title,
+		"message": msg,
+		"time":    time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		req.Header.Set("X-XUI-Signature", signPayload(n.secret, data))
+	}
+	return postNotify(req)
+}
+
+type discordNotifier struct {
+	url string
+}
+
+func (n *discordNotifier) Send(event NotifyEvent, title string, msg string) error {
+	return postJson(n.url, map[string]string{
+		"content": fmt.Sprintf("**%s**\n%s", title, msg),
+	}, nil)
+}
+
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) Send(event NotifyEvent, title string, msg string) error {
+	return postJson(n.url, map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", title, msg),
+	}, nil)
+}
+
+type ntfyNotifier struct {
+	server string
+	topic  string
+	token  string
+}
+
+func (n *ntfyNotifier) Send(event NotifyEvent, title string, msg string) error {
+	server := n.server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	url := strings.TrimSuffix(server, "/") + "/" + n.topic
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", string(event))
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return postNotify(req)
+}
+
+type gotifyNotifier struct {
+	server   string
+	token    string
+	priority int
+}
+
+func (n *gotifyNotifier) Send(event NotifyEvent, title string, msg string) error {
+	url := strings.TrimSuffix(n.server, "/") + "/message"
+	return postJson(url, map[string]interface{}{
+		"title":    title,
+		"message":  msg,
+		"priority": n.priority,
+	}, map[string]string{"X-Gotify-Key": n.token})
+}
+
+type smtpNotifier struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+	tls      bool
+}
+
+// encodeMailHeader 标题中可能包含用户备注和邮箱，去掉换行防止注入其他邮件头，非 ASCII 字符按 RFC 2047 编码
+func encodeMailHeader(value string) string {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	return mime.QEncoding.Encode("UTF-8", value)
+}
+
+func (n *smtpNotifier) Send(event NotifyEvent, title string, msg string) error {
+	addr := net.JoinHostPort(n.host, strconv.Itoa(n.port))
+	from := n.from
+	if from == "" {
+		from = n.username
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		from, strings.Join(n.to, ","), encodeMailHeader(title), msg)
+
+	var client *smtp.Client
+	if n.tls {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second * 15}, "tcp", addr, &tls.Config{ServerName: n.host})
+		if err != nil {
+			return err
+		}
+		client, err = smtp.NewClient(conn, n.host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	} else {
+		conn, err := net.DialTimeout("tcp", addr, time.Second*15)
+		if err != nil {
+			return err
+		}
+		client, err = smtp.NewClient(conn, n.host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			err = client.StartTLS(&tls.Config{ServerName: n.host})
+			if err != nil {
+				client.Close()
+				return err
+			}
+		}
+	}
+	defer client.Close()
+
+	if n.username != "" {
+		err := client.Auth(smtp.PlainAuth("", n.username, n.password, n.host))
+		if err != nil {
+			return err
+		}
+	}
+	err := client.Mail(from)
+	if err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(body))
+	if err != nil {
+		w.Close()
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func newNotifier(channelType string, configStr string) (Notifier, error) {
+	config := &notifyConfig{}
+	if configStr != "" {
+		err := json.Unmarshal([]byte(configStr), config)
+		if err != nil {
+			return nil, common.NewError("invalid notify channel config:", err)
+		}
+	}
+	requireUrl := func() error {
+		if config.Url == "" {
+			return common.NewError("url can not be empty")
+		}
+		return nil
+	}
+	switch channelType {
+	case "telegram":
+		return &telegramNotifier{chatId: config.ChatId}, nil
+	case "webhook":
+		if err := requireUrl(); err != nil {
+			return nil, err
+		}
+		return &webhookNotifier{url: config.Url, secret: config.Secret}, nil
+	case "discord":
+		if err := requireUrl(); err != nil {
+			return nil, err
+		}
+		return &discordNotifier{url: config.Url}, nil
+	case "slack":
+		if err := requireUrl(); err != nil {
+			return nil, err
+		}
+		return &slackNotifier{url: config.Url}, nil
+	case "ntfy":
+		if config.Topic == "" {
+			return nil, common.NewError("ntfy topic can not be empty")
+		}
+		return &ntfyNotifier{server: config.Server, topic: config.Topic, token: config.Token}, nil
+	case "gotify":
+		if config.Server == "" || config.Token == "" {
+			return nil, common.NewError("gotify server and token can not be empty")
+		}
+		return &gotifyNotifier{server: config.Server, token: config.Token, priority: config.Priority}, nil
+	case "smtp":
+		if config.Host == "" || config.To == "" {
+			return nil, common.NewError("smtp host and recipients can not be empty")
+		}
+		port := config.Port
+		if port == 0 {
+			port = 25
+			if config.Tls {
+				port = 465
+			}
+		}
+		to := make([]string, 0)
+		for _, addr := range strings.Split(config.To, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr != "" {
+				to = append(to, addr)
+			}
+		}
+		return &smtpNotifier{
+			host:     config.Host,
+			port:     port,
+			username: config.Username,
+			password: config.Password,
+			from:     config.From,
+			to:       to,
+			tls:      config.Tls,
+		}, nil
+	default:
+		return nil, common.NewError("unknown notify channel type:", channelType)
+	}
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/web/locale"
+)
+
+type NotifyEvent string
+
+const (
+	EventReport   NotifyEvent = "report"
+	EventLogin    NotifyEvent = "login"
+	EventSSHLogin NotifyEvent = "ssh_login"
+	EventAlert    NotifyEvent = "alert"
+	EventBotAuth  NotifyEvent = "bot_auth"
+	EventQuota    NotifyEvent = "quota"
+	EventTest     NotifyEvent = "test"
+)
+
+// 发送失败后的重试间隔，依次递增
+var notifyRetryDelays = []time.Duration{
+	time.Second * 2,
+	time.Second * 10,
+	time.Second * 30,
+}
+
+type NotifyService struct {
+	telegramService TelegramService
+}
+
+func (s *NotifyService) GetChannels() ([]*model.NotifyChannel, error) {
+	db := database.GetDB()
+	channels := make([]*model.NotifyChannel, 0)
+	err := db.Model(model.NotifyChannel{}).Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+func (s *NotifyService) GetChannel(id int) (*model.NotifyChannel, error) {
+	db := database.GetDB()
+	channel := &model.NotifyChannel{}
+	err := db.Model(model.NotifyChannel{}).First(channel, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+func (s *NotifyService) AddChannel(channel *model.NotifyChannel) error {
+	_, err := newNotifier(channel.Type, channel.Config)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Create(channel).Error
+}
+
+func (s *NotifyService) UpdateChannel(channel *model.NotifyChannel) error {
+	_, err := newNotifier(channel.Type, channel.Config)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Save(channel).Error
+}
+
+func (s *NotifyService) DelChannel(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.NotifyChannel{}, id).Error
+}
+
+func channelHasEvent(channel *model.NotifyChannel, event NotifyEvent) bool {
+	for _, e := range strings.Split(channel.Events, ",") {
+		if strings.TrimSpace(e) == string(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *NotifyService) notifier(channel *model.NotifyChannel) (Notifier, error) {
+	notifier, err := newNotifier(channel.Type, channel.Config)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := notifier.(*telegramNotifier); ok {
+		n.telegramService = s.telegramService
+	}
+	return notifier, nil
+}
+
+func withHostname(msg string) string {
+	name, err := os.Hostname()
+	if err != nil {
+		return msg
+	}
+	return locale.Bot("notify.hostname", "Hostname", name) + "\r\n" + msg
+}
+
+// InboundReport 生成入站流量和到期时间的通知内容
+func InboundReport(inbound *model.Inbound) string {
+	return locale.Bot("report.inbound",
+		"Remark", inbound.Remark,
+		"Port", inbound.Port,
+		"Up", common.FormatTraffic(inbound.Up),
+		"Down", common.FormatTraffic(inbound.Down),
+		"Total", common.FormatTraffic(inbound.Up+inbound.Down),
+		"Expiry", formatExpiry(inbound.ExpiryTime))
+}
+
+// NotifyOverQuota 通知设置为仅通知或转发的入站流量已超出
+func (s *NotifyService) NotifyOverQuota(inbound *model.Inbound) {
+	msg := locale.Bot("quota.info",
+		"Remark", inbound.Remark,
+		"Port", inbound.Port,
+		"Used", common.FormatTraffic(inbound.QuotaUsed()),
+		"Total", common.FormatTraffic(inbound.Total))
+	if inbound.OverageAction == model.OverageRedirect {
+		msg += "\r\n" + locale.Bot("quota.actionRedirect", "Outbound", overageOutbound(inbound))
+	} else {
+		msg += "\r\n" + locale.Bot("quota.actionNotify")
+	}
+	s.Notify(EventQuota, locale.Bot("quota.title"), msg)
+}
+
+// sendWithRetry 失败时按 notifyRetryDelays 退避重试
+func (s *NotifyService) sendWithRetry(channel *model.NotifyChannel, event NotifyEvent, title string, msg string) {
+	notifier, err := s.notifier(channel)
+	if err != nil {
+		logger.Warningf("notify channel %s invalid: %v", channel.Name, err)
+		return
+	}
+	for i := 0; ; i++ {
+		err = notifier.Send(event, title, msg)
+		if err == nil {
+			return
+		}
+		if i >= len(notifyRetryDelays) {
+			logger.Warningf("send %s notification via %s failed, giving up: %v", event, channel.Name, err)
+			return
+		}
+		logger.Debugf("send %s notification via %s failed, retry in %v: %v", event, channel.Name, notifyRetryDelays[i], err)
+		time.Sleep(notifyRetryDelays[i])
+	}
+}
+
+// Notify 把事件推送到订阅了该事件的所有渠道，没有任何渠道订阅时退回到原有的 Telegram 推送
+func (s *NotifyService) Notify(event NotifyEvent, title string, msg string) {
+	channels, err := s.GetChannels()
+	if err != nil {
+		logger.Warning("get notify channels failed:", err)
+		return
+	}
+	msg = withHostname(msg)
+	sent := false
+	for _, channel := range channels {
+		if !channel.Enable || !channelHasEvent(channel, event) {
+			continue
+		}
+		sent = true
+		go s.sendWithRetry(channel, event, title, msg)
+	}
+	if !sent {
+		s.telegramService.SendMsgToTgbot(title + "\r\n" + msg)
+	}
+}
+
+// NotifyChannel 推送到指定渠道，channelId 为 0 时按事件订阅分发
+func (s *NotifyService) NotifyChannel(channelId int, event NotifyEvent, title string, msg string) {
+	if channelId <= 0 {
+		s.Notify(event, title, msg)
+		return
+	}
+	channel, err := s.GetChannel(channelId)
+	if err != nil {
+		logger.Warningf("get notify channel %d failed: %v", channelId, err)
+		return
+	}
+	if !channel.Enable {
+		return
+	}
+	go s.sendWithRetry(channel, event, title, withHostname(msg))
+}
+
+// TestChannel 同步发送一条测试消息，便于在界面上直接看到错误
+func (s *NotifyService) TestChannel(channel *model.NotifyChannel) error {
+	notifier, err := s.notifier(channel)
+	if err != nil {
+		return err
+	}
+	err = notifier.Send(EventTest, locale.Bot("notify.testTitle"), withHostname(locale.Bot("notify.testMsg")))
+	if err != nil {
+		return common.NewError("send test notification failed:", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/web/entity"
+	"x-ui/web/locale"
+	"x-ui/xray"
+)
+
+// portUse 记录面板自身或 xray 模版中占用的端口
+type portUse struct {
+	name   *locale.Error
+	listen string
+	tcp    bool
+	udp    bool
+}
+
+// inboundTransport 返回入站监听 TCP 和 UDP 中的哪些，kcp 和 quic 只用 UDP
+func inboundTransport(protocol string, settings string, streamSettings string) (tcp bool, udp bool) {
+	stream, err := xray.ParseStreamSettings(streamSettings)
+	if err == nil {
+		switch stream.GetNetwork() {
+		case "kcp", "quic":
+			return false, true
+		}
+	}
+
+	network := "tcp"
+	parsed, err := xray.ParseInboundSettings(protocol, settings)
+	switch s := parsed.(type) {
+	case *xray.ShadowsocksSettings:
+		network = s.Network
+		if network == "" {
+			network = "tcp,udp"
+		}
+	case *xray.DokodemoSettings:
+		if s.Network != "" {
+			network = s.Network
+		}
+	case nil:
+		if err != nil && model.Protocol(protocol) == model.Shadowsocks {
+			network = "tcp,udp"
+		}
+	}
+	for _, n := range strings.Split(network, ",") {
+		switch strings.TrimSpace(n) {
+		case "tcp":
+			tcp = true
+		case "udp":
+			udp = true
+		}
+	}
+	return
+}
+
+func isAnyAddress(listen string) bool {
+	if listen == "" {
+		return true
+	}
+	ip := net.ParseIP(listen)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// isSocketPath 判断 listen 是否为 unix domain socket，此时端口不生效
+func isSocketPath(listen string) bool {
+	return strings.HasPrefix(listen, "/") || strings.HasPrefix(listen, "@")
+}
+
+// listenOverlap 判断两个监听地址是否会抢同一个端口
+func listenOverlap(a string, b string) bool {
+	if isSocketPath(a) || isSocketPath(b) {
+		return false
+	}
+	if isAnyAddress(a) || isAnyAddress(b) {
+		return true
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return a == b
+}
+
+// reservedPorts 返回面板端口、伪装站点端口和 xray 模版中入站占用的端口
+func (s *InboundService) reservedPorts() (map[int][]portUse, error) {
+	uses := map[int][]portUse{}
+	webPort, err := s.settingService.GetPort()
+	if err != nil {
+		return nil, err
+	}
+	webListen, err := s.settingService.GetListen()
+	if err != nil {
+		return nil, err
+	}
+	uses[webPort] = append(uses[webPort], portUse{name: locale.NewError("port.panel"), listen: webListen, tcp: true})
+
+	decoyEnable, err := s.settingService.GetDecoyEnable()
+	if err != nil {
+		return nil, err
+	}
+	if decoyEnable {
+		decoyPort, err := s.settingService.GetDecoyPort()
+		if err != nil {
+			return nil, err
+		}
+		uses[decoyPort] = append(uses[decoyPort], portUse{name: locale.NewError("port.decoy"), listen: "127.0.0.1", tcp: true})
+	}
+
+	template, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig := &xray.Config{}
+	err = json.Unmarshal([]byte(template), xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range xrayConfig.InboundConfigs {
+		var listen string
+		_ = json.Unmarshal(inbound.Listen, &listen)
+		tcp, udp := inboundTransport(inbound.Protocol, string(inbound.Settings), string(inbound.StreamSettings))
+		name := locale.NewError("port.template", "Tag", inbound.Tag)
+		uses[inbound.Port] = append(uses[inbound.Port], portUse{name: name, listen: listen, tcp: tcp, udp: udp})
+	}
+	return uses, nil
+}
+
+// checkPortBindable 尝试在本机监听该端口，检查是否被其他程序占用或监听地址不可用
+func checkPortBindable(listen string, port int, tcp bool, udp bool) error {
+	if isSocketPath(listen) {
+		return nil
+	}
+	host := listen
+	if isAnyAddress(listen) {
+		host = ""
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if tcp {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return locale.NewError("port.tcpUnavailable", "Addr", addr, "Error", err)
+		}
+		l.Close()
+	}
+	if udp {
+		l, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return locale.NewError("port.udpUnavailable", "Addr", addr, "Error", err)
+		}
+		l.Close()
+	}
+	return nil
+}
+
+// checkPortConflict 检查入站端口是否与其他入站、面板、xray 模版或本机其他程序冲突，
+// 修改入站且端口未变时该端口正被 xray 占用，不再检查本机
+func (s *InboundService) checkPortConflict(inbound *model.Inbound, ignoreId int) error {
+	if inbound.Port <= 0 || inbound.Port > 65535 {
+		return locale.NewError("port.invalid", "Port", inbound.Port)
+	}
+	exist, err := s.checkPortExist(inbound.Port, ignoreId)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return locale.NewError("port.exists", "Port", inbound.Port)
+	}
+
+	tcp, udp := inboundTransport(string(inbound.Protocol), inbound.Settings, inbound.StreamSettings)
+	uses, err := s.reservedPorts()
+	if err != nil {
+		return err
+	}
+	for _, use := range uses[inbound.Port] {
+		if listenOverlap(use.listen, inbound.Listen) && (use.tcp && tcp || use.udp && udp) {
+			return locale.NewError("port.used", "Name", use.name, "Port", inbound.Port)
+		}
+	}
+
+	if ignoreId > 0 {
+		old, err := s.GetInbound(ignoreId)
+		if err != nil {
+			return err
+		}
+		if old.Enable && old.Port == inbound.Port && p != nil && p.IsRunning() {
+			return nil
+		}
+	}
+	return checkPortBindable(inbound.Listen, inbound.Port, tcp, udp)
+}
+
+// AllocatePort 在设置的端口范围内随机选择一个可用端口，TCP 和 UDP 都可用才会选中
+func (s *InboundService) AllocatePort(listen string) (int, error) {
+	ranges, err := s.settingService.GetPortRanges()
+	if err != nil {
+		return 0, err
+	}
+	excludes, err := s.settingService.GetPortExcludes()
+	if err != nil {
+		return 0, err
+	}
+	uses, err := s.reservedPorts()
+	if err != nil {
+		return 0, err
+	}
+	used := make([]int, 0)
+	err = database.GetDB().Model(model.Inbound{}).Pluck("port", &used).Error
+	if err != nil {
+		return 0, err
+	}
+	skip := map[int]bool{}
+	for _, port := range used {
+		skip[port] = true
+	}
+	for port := range uses {
+		skip[port] = true
+	}
+
+	candidates := make([]int, 0)
+	for _, r := range ranges {
+		for port := r.From; port <= r.To; port++ {
+			if skip[port] || portExcluded(excludes, port) {
+				continue
+			}
+			skip[port] = true
+			candidates = append(candidates, port)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	for _, port := range candidates {
+		if checkPortBindable(listen, port, true, true) == nil {
+			return port, nil
+		}
+	}
+	return 0, locale.NewError("port.noFree")
+}
+
+func portExcluded(excludes []entity.PortRange, port int) bool {
+	for _, r := range excludes {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
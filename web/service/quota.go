@@ -0,0 +1,161 @@
+package service
+
+import (
+	"encoding/json"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/web/locale"
+	"x-ui/xray"
+)
+
+func checkQuotaPolicy(inbound *model.Inbound) error {
+	switch inbound.QuotaMode {
+	case "", model.QuotaModeBoth, model.QuotaModeDown, model.QuotaModeUp:
+	case model.QuotaModeMultiplier:
+		if inbound.QuotaMultiplier <= 0 {
+			return locale.NewError("quota.invalidMultiplier", "Multiplier", inbound.QuotaMultiplier)
+		}
+	default:
+		return locale.NewError("quota.unsupportedMode", "Mode", inbound.QuotaMode)
+	}
+	if inbound.QuotaGrace < 0 {
+		return locale.NewError("quota.negativeGrace", "Grace", inbound.QuotaGrace)
+	}
+	switch inbound.OverageAction {
+	case "", model.OverageDisable, model.OverageNotify, model.OverageRedirect:
+	default:
+		return locale.NewError("quota.unsupportedAction", "Action", inbound.OverageAction)
+	}
+	return nil
+}
+
+// overageOutbound 返回超出流量后转发的出站 tag
+func overageOutbound(inbound *model.Inbound) string {
+	if inbound.OverageOutbound == "" {
+		return model.OverageDefaultOutbound
+	}
+	return inbound.OverageOutbound
+}
+
+// CheckOverQuota 更新设置为仅通知或转发的入站的超出状态，返回新超出流量的入站，
+// 以及是否有转发的入站状态发生变化需要重启 xray
+func (s *InboundService) CheckOverQuota() ([]*model.Inbound, bool, error) {
+	db := database.GetDB()
+	inbounds := make([]*model.Inbound, 0)
+	err := db.Model(model.Inbound{}).
+		Where("enable = ? and overage_action in ?", true, []string{model.OverageNotify, model.OverageRedirect}).
+		Find(&inbounds).Error
+	if err != nil {
+		return nil, false, err
+	}
+	exceeded := make([]*model.Inbound, 0)
+	needRestart := false
+	for _, inbound := range inbounds {
+		overQuota := inbound.IsQuotaExceeded()
+		if overQuota == inbound.OverQuota {
+			continue
+		}
+		err = db.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("over_quota", overQuota).Error
+		if err != nil {
+			return exceeded, needRestart, err
+		}
+		inbound.OverQuota = overQuota
+		if inbound.OverageAction == model.OverageRedirect {
+			needRestart = true
+		}
+		if !overQuota {
+			logger.Infof("inbound %v back under quota", inbound.Id)
+			continue
+		}
+		logger.Infof("inbound %v over quota, action: %v", inbound.Id, inbound.OverageAction)
+		exceeded = append(exceeded, inbound)
+		data := inboundEventData(inbound)
+		data["action"] = inbound.OverageAction
+		if inbound.OverageAction == model.OverageRedirect {
+			data["outbound"] = overageOutbound(inbound)
+		}
+		s.webhookService.Emit(WebhookInboundOverQuota, data)
+	}
+	return exceeded, needRestart, nil
+}
+
+// applyOverageRouting 把超出流量且设置为转发的入站路由到指定出站，规则放在最前面以免被模板中的规则先匹配，
+// 模板中没有该出站时添加同名的 blackhole 出站，宁可断流也不让超出的流量继续直连
+func applyOverageRouting(xrayConfig *xray.Config, inbounds []*model.Inbound) error {
+	tags := map[string][]string{}
+	order := make([]string, 0)
+	for _, inbound := range inbounds {
+		if !inbound.Enable || !inbound.OverQuota || inbound.OverageAction != model.OverageRedirect {
+			continue
+		}
+		outbound := overageOutbound(inbound)
+		if _, ok := tags[outbound]; !ok {
+			order = append(order, outbound)
+		}
+		tags[outbound] = append(tags[outbound], inbound.Tag)
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	routing := map[string]interface{}{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		err := json.Unmarshal(xrayConfig.RouterConfig, &routing)
+		if err != nil {
+			return err
+		}
+	}
+	oldRules, _ := routing["rules"].([]interface{})
+	rules := make([]interface{}, 0, len(order)+len(oldRules))
+	for _, outbound := range order {
+		rules = append(rules, map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  tags[outbound],
+			"outboundTag": outbound,
+		})
+	}
+	routing["rules"] = append(rules, oldRules...)
+	routerConfig, err := json.Marshal(routing)
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = routerConfig
+
+	outbounds := make([]map[string]interface{}, 0)
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		err = json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds)
+		if err != nil {
+			return err
+		}
+	}
+	exists := map[string]bool{}
+	for _, outbound := range outbounds {
+		if tag, ok := outbound["tag"].(string); ok {
+			exists[tag] = true
+		}
+	}
+	added := false
+	for _, tag := range order {
+		if exists[tag] {
+			continue
+		}
+		if tag != model.OverageDefaultOutbound {
+			logger.Warningf("overage outbound %v not found, using blackhole instead", tag)
+		}
+		outbounds = append(outbounds, map[string]interface{}{
+			"protocol": "blackhole",
+			"settings": map[string]interface{}{},
+			"tag":      tag,
+		})
+		added = true
+	}
+	if added {
+		outboundConfigs, err := json.Marshal(outbounds)
+		if err != nil {
+			return err
+		}
+		xrayConfig.OutboundConfigs = outboundConfigs
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+)
+
+const (
+	StatResolutionMinute = 60
+	StatResolution5Min   = 300
+	StatResolutionHour   = 3600
+)
+
+// 各粒度数据的保留时长，超出的数据在聚合时清理
+var statRetention = map[int]time.Duration{
+	StatResolutionMinute: time.Hour * 24,
+	StatResolution5Min:   time.Hour * 24 * 7,
+	StatResolutionHour:   time.Hour * 24 * 90,
+}
+
+// 每个粒度由哪个更细的粒度聚合而来
+var statSource = map[int]int{
+	StatResolution5Min: StatResolutionMinute,
+	StatResolutionHour: StatResolution5Min,
+}
+
+const statRingSize = 360
+
+// statRing 保存最近的原始采样，用于聚合分钟粒度的数据
+type statRing struct {
+	lock    sync.Mutex
+	samples [statRingSize]*Status
+	next    int
+	count   int
+}
+
+func (r *statRing) put(status *Status) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.samples[r.next] = status
+	r.next = (r.next + 1) % statRingSize
+	if r.count < statRingSize {
+		r.count++
+	}
+}
+
+func (r *statRing) since(t time.Time) []*Status {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]*Status, 0)
+	for i := 0; i < r.count; i++ {
+		status := r.samples[(r.next-r.count+i+statRingSize)%statRingSize]
+		if !status.T.Before(t) {
+			result = append(result, status)
+		}
+	}
+	return result
+}
+
+func (r *statRing) last() *Status {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.count == 0 {
+		return nil
+	}
+	return r.samples[(r.next-1+statRingSize)%statRingSize]
+}
+
+var statSamples = &statRing{}
+
+type ServerStatService struct {
+	serverService ServerService
+}
+
+// Sample 采集一次服务器状态并放入内存环形缓冲区
+func (s *ServerStatService) Sample() *Status {
+	status := s.serverService.GetStatus(statSamples.last())
+	statSamples.put(status)
+	return status
+}
+
+func statusToStat(status *Status) *model.ServerStat {
+	stat := &model.ServerStat{
+		Time:     status.T.Unix(),
+		Cpu:      status.Cpu,
+		Mem:      status.Mem.Current,
+		MemTotal: status.Mem.Total,
+		Swap:     status.Swap.Current,
+		Disk:     status.Disk.Current,
+		TcpCount: status.TcpCount,
+		UdpCount: status.UdpCount,
+		NetUp:    status.NetIO.Up,
+		NetDown:  status.NetIO.Down,
+	}
+	if len(status.Loads) == 3 {
+		stat.Load1 = status.Loads[0]
+		stat.Load5 = status.Loads[1]
+		stat.Load15 = status.Loads[2]
+	}
+	return stat
+}
+
+func averageStats(stats []*model.ServerStat) *model.ServerStat {
+	avg := &model.ServerStat{}
+	n := len(stats)
+	if n == 0 {
+		return avg
+	}
+	var cpu, load1, load5, load15 float64
+	var mem, memTotal, swap, disk, netUp, netDown uint64
+	var tcp, udp int
+	for _, stat := range stats {
+		cpu += stat.Cpu
+		load1 += stat.Load1
+		load5 += stat.Load5
+		load15 += stat.Load15
+		mem += stat.Mem
+		memTotal += stat.MemTotal
+		swap += stat.Swap
+		disk += stat.Disk
+		netUp += stat.NetUp
+		netDown += stat.NetDown
+		tcp += stat.TcpCount
+		udp += stat.UdpCount
+	}
+	avg.Cpu = cpu / float64(n)
+	avg.Load1 = load1 / float64(n)
+	avg.Load5 = load5 / float64(n)
+	avg.Load15 = load15 / float64(n)
+	avg.Mem = mem / uint64(n)
+	avg.MemTotal = memTotal / uint64(n)
+	avg.Swap = swap / uint64(n)
+	avg.Disk = disk / uint64(n)
+	avg.NetUp = netUp / uint64(n)
+	avg.NetDown = netDown / uint64(n)
+	avg.TcpCount = tcp / n
+	avg.UdpCount = udp / n
+	return avg
+}
+
+// Rollup 把 end 之前最近一个周期的数据聚合成 resolution 粒度的一条记录，并清理过期数据
+func (s *ServerStatService) Rollup(resolution int, end time.Time) error {
+	period := time.Duration(resolution) * time.Second
+	end = end.Truncate(period)
+	start := end.Add(-period)
+
+	var stats []*model.ServerStat
+	if resolution == StatResolutionMinute {
+		samples := statSamples.since(start)
+		for _, sample := range samples {
+			if sample.T.Before(end) {
+				stats = append(stats, statusToStat(sample))
+			}
+		}
+	} else {
+		source, ok := statSource[resolution]
+		if !ok {
+			return common.NewError("unknown stat resolution:", resolution)
+		}
+		db := database.GetDB()
+		err := db.Model(model.ServerStat{}).
+			Where("resolution = ? and time >= ? and time < ?", source, start.Unix(), end.Unix()).
+			Find(&stats).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	db := database.GetDB()
+	if len(stats) > 0 {
+		stat := averageStats(stats)
+		stat.Resolution = resolution
+		stat.Time = start.Unix()
+		err := db.Create(stat).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	expire := end.Add(-statRetention[resolution]).Unix()
+	return db.Where("resolution = ? and time < ?", resolution, expire).Delete(model.ServerStat{}).Error
+}
+
+// pickResolution 根据查询范围选择合适的粒度，使返回的点数保持在几百个以内
+func pickResolution(from time.Time, to time.Time) int {
+	duration := to.Sub(from)
+	switch {
+	case duration <= time.Hour*6:
+		return StatResolutionMinute
+	case duration <= time.Hour*24*3:
+		return StatResolution5Min
+	default:
+		return StatResolutionHour
+	}
+}
+
+func (s *ServerStatService) GetHistory(from time.Time, to time.Time, resolution int) ([]*model.ServerStat, int, error) {
+	if !to.After(from) {
+		return nil, 0, common.NewError("invalid time range:", from, to)
+	}
+	if _, ok := statRetention[resolution]; !ok {
+		resolution = pickResolution(from, to)
+	}
+	db := database.GetDB()
+	stats := make([]*model.ServerStat, 0)
+	err := db.Model(model.ServerStat{}).
+		Where("resolution = ? and time >= ? and time <= ?", resolution, from.Unix(), to.Unix()).
+		Order("time asc").
+		Find(&stats).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return stats, resolution, nil
+}
@@ -15,24 +15,63 @@ import (
 	"x-ui/util/random"
 	"x-ui/util/reflect_util"
 	"x-ui/web/entity"
+	"x-ui/web/locale"
 )
 
 //go:embed config.json
 var xrayTemplateConfig string
 
 var defaultValueMap = map[string]string{
-	"xrayTemplateConfig": xrayTemplateConfig,
-	"webListen":          "",
-	"webPort":            "54321",
-	"webCertFile":        "",
-	"webKeyFile":         "",
-	"secret":             random.Seq(32),
-	"webBasePath":        "/",
-	"timeLocation":       "Asia/Shanghai",
-	"tgBotEnable":        "false",
-	"tgBotToken":         "",
-	"tgBotChatId":        "0",
-	"tgRunTime":          "",
+	"xrayTemplateConfig":  xrayTemplateConfig,
+	"webListen":           "",
+	"webPort":             "54321",
+	"webCertFile":         "",
+	"webKeyFile":          "",
+	"secret":              random.Seq(32),
+	"webBasePath":         "/",
+	"timeLocation":        "Asia/Shanghai",
+	"tgBotEnable":         "false",
+	"tgBotToken":          "",
+	"tgBotChatId":         "0",
+	"tgRunTime":           "",
+	"tgBotAdmins":         "",
+	"tgLang":              "zh_Hans",
+	"tgBotMode":           "polling",
+	"tgBotApiServer":      "",
+	"tgWebhookUrl":        "",
+	"tgWebhookSecret":     random.Seq(32),
+	"tgClientBotEnable":   "false",
+	"tgClientWarnPercent": "90",
+	"tgClientWarnDays":    "3",
+	"subEnable":           "false",
+	"subURI":              "",
+	"metricsEnable":       "false",
+	"metricsToken":        "",
+	"metricsUsername":     "",
+	"metricsPassword":     "",
+	"sshWatchEnable":      "true",
+	"sshLogSource":        "auto",
+	"sshLogPath":          "",
+	"sshBruteThreshold":   "5",
+	"sshBruteWindow":      "10",
+	"sshAutoBlock":        "false",
+	"sshBlockDuration":    "24",
+	"portRange":           "10000-60000",
+	"portExclude":         "",
+	"decoyEnable":         "false",
+	"decoyPort":           "10080",
+	"decoyTemplate":       "nginx",
+	"decoyFallback":       "true",
+	"decoyPanelProxy":     "false",
+	"publicAddressV4":     "",
+	"publicAddressV6":     "",
+	"publicAddressDetect": "true",
+	"nodeAgentEnable":     "false",
+	"nodeAgentToken":      "",
+	"xrayDownloadMirror":  "",
+	"xrayDownloadProxy":   "",
+	"xrayCorePrevious":    "",
+	"geoUpdateInterval":   "0",
 }
 
 type SettingService struct {
@@ -210,6 +249,29 @@ func (s *SettingService) SetTgBotChatId(chatId int) error {
 	return s.setInt("tgBotChatId", chatId)
 }
 
+// GetTgBotAdmins 返回机器人管理员列表，未配置时 tgBotChatId 拥有全部命令权限，
+// 但仅限私聊，群组的 chat id 为负数，否则群里的每个成员都会成为管理员
+func (s *SettingService) GetTgBotAdmins() (entity.TgBotAdmins, error) {
+	str, err := s.getString("tgBotAdmins")
+	if err != nil {
+		return nil, err
+	}
+	admins, err := entity.ParseTgBotAdmins(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(admins) == 0 {
+		chatId, err := s.GetTgBotChatId()
+		if err != nil {
+			return nil, err
+		}
+		if chatId > 0 {
+			admins[int64(chatId)] = map[string]bool{"*": true}
+		}
+	}
+	return admins, nil
+}
+
 func (s *SettingService) SetTgbotenabled(value bool) error {
 	return s.setBool("tgBotEnable", value)
 }
@@ -226,6 +288,123 @@ func (s *SettingService) GetTgbotRuntime() (string, error) {
 	return s.getString("tgRunTime")
 }
 
+// GetTgBotMode 返回机器人接收消息的方式，polling 或 webhook
+func (s *SettingService) GetTgBotMode() (string, error) {
+	return s.getString("tgBotMode")
+}
+
+// GetTgBotApiServer 返回自定义的 Bot API 服务地址，为空时使用官方地址
+func (s *SettingService) GetTgBotApiServer() (string, error) {
+	return s.getString("tgBotApiServer")
+}
+
+func (s *SettingService) GetTgWebhookUrl() (string, error) {
+	return s.getString("tgWebhookUrl")
+}
+
+func (s *SettingService) GetTgWebhookSecret() (string, error) {
+	secret, err := s.getString("tgWebhookSecret")
+	if secret == defaultValueMap["tgWebhookSecret"] {
+		err := s.saveSetting("tgWebhookSecret", secret)
+		if err != nil {
+			logger.Warning("save telegram webhook secret failed:", err)
+		}
+	}
+	return secret, err
+}
+
+// GetTgLang 返回电报机器人回复和通知消息使用的语言
+func (s *SettingService) GetTgLang() (string, error) {
+	return s.getString("tgLang")
+}
+
+func (s *SettingService) GetTgClientBotEnable() (bool, error) {
+	return s.getBool("tgClientBotEnable")
+}
+
+func (s *SettingService) GetTgClientWarnPercent() (int, error) {
+	return s.getInt("tgClientWarnPercent")
+}
+
+func (s *SettingService) GetTgClientWarnDays() (int, error) {
+	return s.getInt("tgClientWarnDays")
+}
+
+func (s *SettingService) GetSSHWatchEnable() (bool, error) {
+	return s.getBool("sshWatchEnable")
+}
+
+// GetSSHLogSource 返回 SSH 登录日志的来源：auto、journald 或 file
+func (s *SettingService) GetSSHLogSource() (string, error) {
+	return s.getString("sshLogSource")
+}
+
+// GetSSHLogPath 返回 SSH 认证日志文件路径，为空时自动查找
+func (s *SettingService) GetSSHLogPath() (string, error) {
+	return s.getString("sshLogPath")
+}
+
+// GetSSHBruteThreshold 返回统计窗口内判定为暴力破解的失败次数，0 表示不合并
+func (s *SettingService) GetSSHBruteThreshold() (int, error) {
+	return s.getInt("sshBruteThreshold")
+}
+
+// GetSSHBruteWindow 返回暴力破解的统计窗口（分钟）
+func (s *SettingService) GetSSHBruteWindow() (int, error) {
+	return s.getInt("sshBruteWindow")
+}
+
+func (s *SettingService) GetSSHAutoBlock() (bool, error) {
+	return s.getBool("sshAutoBlock")
+}
+
+// GetSSHBlockDuration 返回自动封禁的时长（小时），0 表示永久
+func (s *SettingService) GetSSHBlockDuration() (int, error) {
+	return s.getInt("sshBlockDuration")
+}
+
+// GetPortRanges 返回随机分配入站端口的范围
+func (s *SettingService) GetPortRanges() ([]entity.PortRange, error) {
+	str, err := s.getString("portRange")
+	if err != nil {
+		return nil, err
+	}
+	return entity.ParsePortRanges(str)
+}
+
+// GetPortExcludes 返回随机分配入站端口时排除的端口
+func (s *SettingService) GetPortExcludes() ([]entity.PortRange, error) {
+	str, err := s.getString("portExclude")
+	if err != nil {
+		return nil, err
+	}
+	return entity.ParsePortRanges(str)
+}
+
+func (s *SettingService) GetSubEnable() (bool, error) {
+	return s.getBool("subEnable")
+}
+
+func (s *SettingService) GetSubURI() (string, error) {
+	return s.getString("subURI")
+}
+
+func (s *SettingService) GetMetricsEnable() (bool, error) {
+	return s.getBool("metricsEnable")
+}
+
+func (s *SettingService) GetMetricsToken() (string, error) {
+	return s.getString("metricsToken")
+}
+
+func (s *SettingService) GetMetricsUsername() (string, error) {
+	return s.getString("metricsUsername")
+}
+
+func (s *SettingService) GetMetricsPassword() (string, error) {
+	return s.getString("metricsPassword")
+}
+
 func (s *SettingService) GetPort() (int, error) {
 	return s.getInt("webPort")
 }
@@ -253,6 +432,82 @@ func (s *SettingService) GetSecret() ([]byte, error) {
 	return []byte(secret), err
 }
 
+func (s *SettingService) GetDecoyEnable() (bool, error) {
+	return s.getBool("decoyEnable")
+}
+
+// GetDecoyPort 返回伪装站点监听的端口，只监听 127.0.0.1
+func (s *SettingService) GetDecoyPort() (int, error) {
+	return s.getInt("decoyPort")
+}
+
+// GetDecoyTemplate 返回伪装站点当前使用的模版名称，使用上传的网站时为 upload
+func (s *SettingService) GetDecoyTemplate() (string, error) {
+	return s.getString("decoyTemplate")
+}
+
+func (s *SettingService) SetDecoyTemplate(name string) error {
+	return s.setString("decoyTemplate", name)
+}
+
+// GetDecoyFallback 返回是否把伪装站点作为 vless/trojan 入站的默认回落
+func (s *SettingService) GetDecoyFallback() (bool, error) {
+	return s.getBool("decoyFallback")
+}
+
+// GetDecoyPanelProxy 返回是否可以通过伪装站点的面板根路径访问面板
+func (s *SettingService) GetDecoyPanelProxy() (bool, error) {
+	return s.getBool("decoyPanelProxy")
+}
+
+// GetPublicAddressV4 返回设置的 IPv4 公网地址或域名，为空时自动获取
+func (s *SettingService) GetPublicAddressV4() (string, error) {
+	return s.getString("publicAddressV4")
+}
+
+// GetPublicAddressV6 返回设置的 IPv6 公网地址或域名，为空时自动获取
+func (s *SettingService) GetPublicAddressV6() (string, error) {
+	return s.getString("publicAddressV6")
+}
+
+// GetPublicAddressDetect 返回是否允许通过外部服务查询公网 IP，关闭时只从本机网卡中查找
+func (s *SettingService) GetPublicAddressDetect() (bool, error) {
+	return s.getBool("publicAddressDetect")
+}
+
+// GetNodeAgentEnable 返回是否允许其他面板通过节点接口管理本机
+func (s *SettingService) GetNodeAgentEnable() (bool, error) {
+	return s.getBool("nodeAgentEnable")
+}
+
+func (s *SettingService) GetNodeAgentToken() (string, error) {
+	return s.getString("nodeAgentToken")
+}
+
+// GetXrayDownloadMirror 返回下载 xray 时拼接在 GitHub 下载地址之前的镜像地址
+func (s *SettingService) GetXrayDownloadMirror() (string, error) {
+	return s.getString("xrayDownloadMirror")
+}
+
+// GetXrayDownloadProxy 返回访问 GitHub 时使用的代理，如 socks5://127.0.0.1:1080
+func (s *SettingService) GetXrayDownloadProxy() (string, error) {
+	return s.getString("xrayDownloadProxy")
+}
+
+// GetXrayCorePrevious 返回上一次切换前使用的 xray 版本，用于回滚
+func (s *SettingService) GetXrayCorePrevious() (string, error) {
+	return s.getString("xrayCorePrevious")
+}
+
+func (s *SettingService) SetXrayCorePrevious(version string) error {
+	return s.setString("xrayCorePrevious", version)
+}
+
+// GetGeoUpdateInterval 返回自动更新 geo 文件的间隔小时数，0 表示不自动更新
+func (s *SettingService) GetGeoUpdateInterval() (int, error) {
+	return s.getInt("geoUpdateInterval")
+}
+
 func (s *SettingService) GetBasePath() (string, error) {
 	basePath, err := s.getString("webBasePath")
 	if err != nil {
@@ -299,5 +554,6 @@ func (s *SettingService) UpdateAllSetting(allSetting *entity.AllSetting) error {
 			errs = append(errs, err)
 		}
 	}
+	locale.SetBotLanguage(allSetting.TgLang)
 	return common.Combine(errs...)
 }
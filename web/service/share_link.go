@@ -0,0 +1,445 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// ShareLink 是某个用户的分享链接，没有多用户的协议 Email 为空
+type ShareLink struct {
+	Email string `json:"email"`
+	Link  string `json:"link"`
+}
+
+// headerValue 取出 Host 之类的头，tcp 的头是数组，ws 的头是字符串
+func headerValue(headers map[string]interface{}, name string) string {
+	for key, value := range headers {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			return v
+		case []interface{}:
+			if len(v) > 0 {
+				return fmt.Sprint(v[0])
+			}
+		}
+	}
+	return ""
+}
+
+// streamParams 生成 vless/trojan 链接中与传输方式相关的参数
+func streamParams(stream *xray.StreamSettings) url.Values {
+	params := url.Values{}
+	params.Set("type", stream.GetNetwork())
+	params.Set("security", stream.GetSecurity())
+	switch stream.GetNetwork() {
+	case "tcp":
+		if tcp := stream.TCPSettings; tcp != nil && tcp.Header.Type == "http" {
+			params.Set("path", strings.Join(tcp.Header.Request.Path, ","))
+			if host := headerValue(tcp.Header.Request.Headers, "host"); host != "" {
+				params.Set("host", host)
+			}
+		}
+	case "kcp":
+		if kcp := stream.KCPSettings; kcp != nil {
+			params.Set("headerType", kcp.Header.Type)
+			params.Set("seed", kcp.Seed)
+		}
+	case "ws":
+		if ws := stream.WSSettings; ws != nil {
+			params.Set("path", ws.Path)
+			if host := headerValue(ws.Headers, "host"); host != "" {
+				params.Set("host", host)
+			}
+		}
+	case "http":
+		if h := stream.HTTPSettings; h != nil {
+			params.Set("path", h.Path)
+			params.Set("host", strings.Join(h.Host, ","))
+		}
+	case "quic":
+		if quic := stream.QUICSettings; quic != nil {
+			params.Set("quicSecurity", quic.Security)
+			params.Set("key", quic.Key)
+			params.Set("headerType", quic.Header.Type)
+		}
+	case "grpc":
+		if grpc := stream.GRPCSettings; grpc != nil {
+			params.Set("serviceName", grpc.ServiceName)
+		}
+	}
+	if sni := stream.ServerName(); sni != "" {
+		params.Set("sni", sni)
+	}
+	return params
+}
+
+func linkRemark(inbound *model.Inbound, email string) string {
+	if email == "" {
+		return inbound.Remark
+	}
+	return inbound.Remark + "-" + email
+}
+
+func encodeRemark(remark string) string {
+	return strings.ReplaceAll(url.QueryEscape(remark), "+", "%20")
+}
+
+// clientProfile 是客户端连接入站的某个用户需要的信息，分享链接和客户端配置都由它生成，
+// Id 用于 vmess/vless，Password 用于 trojan、shadowsocks、socks、http 和 mtproto 的 secret
+type clientProfile struct {
+	Remark   string
+	Email    string
+	Protocol model.Protocol
+	Address  string
+	Port     int
+	Stream   *xray.StreamSettings
+	Id       string
+	AlterId  int
+	Flow     string
+	Method   string
+	Username string
+	Password string
+}
+
+func (p *clientProfile) hostPort() string {
+	return net.JoinHostPort(p.Address, strconv.Itoa(p.Port))
+}
+
+// genClientProfiles 生成入站下每个用户的连接信息，address 为客户端连接用的地址，
+// 没有客户端的协议返回空列表
+func genClientProfiles(inbound *model.Inbound, address string) ([]*clientProfile, error) {
+	switch inbound.Protocol {
+	case model.VMess, model.VLESS, model.Trojan, model.Shadowsocks, model.Socks, model.Http, model.MTProto:
+	default:
+		return []*clientProfile{}, nil
+	}
+	settings, err := xray.ParseInboundSettings(string(inbound.Protocol), inbound.Settings)
+	if err != nil {
+		return nil, common.NewError("inbound settings invalid:", err)
+	}
+	stream, err := xray.ParseStreamSettings(inbound.StreamSettings)
+	if err != nil {
+		return nil, common.NewError("inbound stream settings invalid:", err)
+	}
+	newProfile := func(email string) *clientProfile {
+		return &clientProfile{
+			Remark:   linkRemark(inbound, email),
+			Email:    email,
+			Protocol: inbound.Protocol,
+			Address:  address,
+			Port:     inbound.Port,
+			Stream:   stream,
+		}
+	}
+
+	profiles := make([]*clientProfile, 0)
+	switch settings := settings.(type) {
+	case *xray.VmessSettings:
+		for _, client := range settings.Clients {
+			profile := newProfile(client.Email)
+			profile.Id = client.Id
+			profile.AlterId = client.AlterId
+			profiles = append(profiles, profile)
+		}
+	case *xray.VlessSettings:
+		for _, client := range settings.Clients {
+			profile := newProfile(client.Email)
+			profile.Id = client.Id
+			profile.Flow = client.Flow
+			profiles = append(profiles, profile)
+		}
+	case *xray.TrojanSettings:
+		for _, client := range settings.Clients {
+			profile := newProfile(client.Email)
+			profile.Password = client.Password
+			profile.Flow = client.Flow
+			profiles = append(profiles, profile)
+		}
+	case *xray.ShadowsocksSettings:
+		profile := newProfile(settings.Email)
+		profile.Remark = inbound.Remark
+		profile.Method = settings.Method
+		profile.Password = settings.Password
+		profiles = append(profiles, profile)
+	case *xray.SocksSettings:
+		if settings.Auth != "password" {
+			profiles = append(profiles, newProfile(""))
+			break
+		}
+		profiles = append(profiles, accountProfiles(inbound, settings.Accounts, newProfile)...)
+	case *xray.HttpSettings:
+		if len(settings.Accounts) == 0 {
+			profiles = append(profiles, newProfile(""))
+			break
+		}
+		profiles = append(profiles, accountProfiles(inbound, settings.Accounts, newProfile)...)
+	case *xray.MtprotoSettings:
+		for _, user := range settings.Users {
+			profile := newProfile(user.Email)
+			profile.Password = user.Secret
+			profiles = append(profiles, profile)
+		}
+	}
+	// 只有 xtls 使用流控，面板在其他情况下也会保留默认值
+	if stream.GetSecurity() != "xtls" {
+		for _, profile := range profiles {
+			profile.Flow = ""
+		}
+	}
+	return profiles, nil
+}
+
+// accountProfiles socks 和 http 的账号没有 email，备注中使用用户名区分
+func accountProfiles(inbound *model.Inbound, accounts []xray.Account, newProfile func(string) *clientProfile) []*clientProfile {
+	profiles := make([]*clientProfile, 0, len(accounts))
+	for _, account := range accounts {
+		profile := newProfile("")
+		profile.Remark = linkRemark(inbound, account.User)
+		profile.Username = account.User
+		profile.Password = account.Pass
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+func genVmessLink(p *clientProfile) (string, error) {
+	stream := p.Stream
+	network := stream.GetNetwork()
+	headerType := "none"
+	host := ""
+	path := ""
+	switch network {
+	case "tcp":
+		if tcp := stream.TCPSettings; tcp != nil {
+			if tcp.Header.Type != "" {
+				headerType = tcp.Header.Type
+			}
+			if tcp.Header.Type == "http" {
+				path = strings.Join(tcp.Header.Request.Path, ",")
+				host = headerValue(tcp.Header.Request.Headers, "host")
+			}
+		}
+	case "kcp":
+		if kcp := stream.KCPSettings; kcp != nil {
+			headerType = kcp.Header.Type
+			path = kcp.Seed
+		}
+	case "ws":
+		if ws := stream.WSSettings; ws != nil {
+			path = ws.Path
+			host = headerValue(ws.Headers, "host")
+		}
+	case "http":
+		network = "h2"
+		if h := stream.HTTPSettings; h != nil {
+			path = h.Path
+			host = strings.Join(h.Host, ",")
+		}
+	case "quic":
+		if quic := stream.QUICSettings; quic != nil {
+			headerType = quic.Header.Type
+			host = quic.Security
+			path = quic.Key
+		}
+	case "grpc":
+		if grpc := stream.GRPCSettings; grpc != nil {
+			path = grpc.ServiceName
+		}
+	}
+	obj := struct {
+		V    string `json:"v"`
+		Ps   string `json:"ps"`
+		Add  string `json:"add"`
+		Port int    `json:"port"`
+		Id   string `json:"id"`
+		Aid  int    `json:"aid"`
+		Net  string `json:"net"`
+		Type string `json:"type"`
+		Host string `json:"host"`
+		Path string `json:"path"`
+		Tls  string `json:"tls"`
+		Sni  string `json:"sni,omitempty"`
+	}{
+		V:    "2",
+		Ps:   p.Remark,
+		Add:  p.Address,
+		Port: p.Port,
+		Id:   p.Id,
+		Aid:  p.AlterId,
+		Net:  network,
+		Type: headerType,
+		Host: host,
+		Path: path,
+		Tls:  stream.GetSecurity(),
+		Sni:  stream.ServerName(),
+	}
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func genUrlLink(scheme string, user string, p *clientProfile) string {
+	params := streamParams(p.Stream)
+	if p.Flow != "" {
+		params.Set("flow", p.Flow)
+	}
+	return fmt.Sprintf("%s://%s@%s?%s#%s", scheme, url.PathEscape(user), p.hostPort(), params.Encode(), encodeRemark(p.Remark))
+}
+
+func genSSLink(p *clientProfile) string {
+	remark := encodeRemark(p.Remark)
+	if strings.HasPrefix(p.Method, "2022-blake3") {
+		return fmt.Sprintf("ss://%s:%s@%s#%s", p.Method, url.PathEscape(p.Password), p.hostPort(), remark)
+	}
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte(p.Method + ":" + p.Password + "@" + p.hostPort()))
+	return fmt.Sprintf("ss://%s#%s", userInfo, remark)
+}
+
+// genSocksLink 使用 v2rayN 的格式，用户名和密码经 base64 编码
+func genSocksLink(p *clientProfile) string {
+	remark := encodeRemark(p.Remark)
+	if p.Username == "" {
+		return fmt.Sprintf("socks://%s#%s", p.hostPort(), remark)
+	}
+	userInfo := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+	return fmt.Sprintf("socks://%s@%s#%s", userInfo, p.hostPort(), remark)
+}
+
+func genHttpLink(p *clientProfile) string {
+	link := url.URL{Scheme: "http", Host: p.hostPort(), Fragment: p.Remark}
+	if p.Username != "" {
+		link.User = url.UserPassword(p.Username, p.Password)
+	}
+	return link.String()
+}
+
+// genMtprotoLink 生成 Telegram 客户端可以直接打开的代理链接
+func genMtprotoLink(p *clientProfile) string {
+	params := url.Values{}
+	params.Set("server", p.Address)
+	params.Set("port", strconv.Itoa(p.Port))
+	params.Set("secret", p.Password)
+	return "tg://proxy?" + params.Encode()
+}
+
+func genShareLink(p *clientProfile) (string, error) {
+	switch p.Protocol {
+	case model.VMess:
+		return genVmessLink(p)
+	case model.VLESS:
+		return genUrlLink("vless", p.Id, p), nil
+	case model.Trojan:
+		return genUrlLink("trojan", p.Password, p), nil
+	case model.Shadowsocks:
+		return genSSLink(p), nil
+	case model.Socks:
+		return genSocksLink(p), nil
+	case model.Http:
+		return genHttpLink(p), nil
+	case model.MTProto:
+		return genMtprotoLink(p), nil
+	}
+	return "", locale.NewError("shareLink.unsupportedProtocol", "Protocol", p.Protocol)
+}
+
+// inboundAddress 返回客户端连接入站用的地址，依次使用入站设置的公开地址、tls 的 serverName、
+// 监听地址，监听所有地址时使用本机的公网地址，只监听 0.0.0.0 时只能使用 IPv4
+func (s *InboundService) inboundAddress(inbound *model.Inbound) string {
+	if inbound.PublicHost != "" {
+		return inbound.PublicHost
+	}
+	if stream, err := xray.ParseStreamSettings(inbound.StreamSettings); err == nil {
+		if sni := stream.ServerName(); sni != "" {
+			return sni
+		}
+	}
+	if !isAnyAddress(inbound.Listen) && !isSocketPath(inbound.Listen) {
+		return inbound.Listen
+	}
+	if inbound.Listen == "0.0.0.0" {
+		return s.addressService.GetPublicAddressV4()
+	}
+	return s.addressService.GetPublicAddress()
+}
+
+// GenShareLinks 生成入站下所有用户的分享链接，address 为客户端连接用的地址，
+// 没有分享链接的协议返回空列表
+func GenShareLinks(inbound *model.Inbound, address string) ([]ShareLink, error) {
+	profiles, err := genClientProfiles(inbound, address)
+	if err != nil {
+		return nil, err
+	}
+	return profileLinks(profiles)
+}
+
+func profileLinks(profiles []*clientProfile) ([]ShareLink, error) {
+	links := make([]ShareLink, 0, len(profiles))
+	for _, profile := range profiles {
+		link, err := genShareLink(profile)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, ShareLink{Email: profile.Email, Link: link})
+	}
+	return links, nil
+}
+
+// clientProfiles 生成入站下每个用户的连接信息，内部入站使用回落所在入站的地址、端口和 tls 设置
+func (s *InboundService) clientProfiles(inbound *model.Inbound) ([]*clientProfile, error) {
+	public, err := s.publicInbound(inbound)
+	if err != nil {
+		return nil, err
+	}
+	return genClientProfiles(public, s.inboundAddress(public))
+}
+
+// GetShareLinks 生成入站的分享链接
+func (s *InboundService) GetShareLinks(inbound *model.Inbound) ([]ShareLink, error) {
+	profiles, err := s.clientProfiles(inbound)
+	if err != nil {
+		return nil, err
+	}
+	return profileLinks(profiles)
+}
+
+// GetClientConfig 生成入站的完整客户端配置，email 不为空时只包含该用户
+func (s *InboundService) GetClientConfig(inbound *model.Inbound, email string, format string) ([]byte, error) {
+	profiles, err := s.clientProfiles(inbound)
+	if err != nil {
+		return nil, err
+	}
+	if email != "" {
+		filtered := make([]*clientProfile, 0, 1)
+		for _, profile := range profiles {
+			if profile.Email == email {
+				filtered = append(filtered, profile)
+			}
+		}
+		if len(filtered) == 0 {
+			return nil, locale.NewError("inbound.clientNotFound", "Email", email)
+		}
+		profiles = filtered
+	}
+	return GenClientConfig(format, profiles)
+}
+
+// GenQrCode 生成分享链接的二维码 PNG
+func GenQrCode(content string, size int) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
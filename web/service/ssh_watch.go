@@ -0,0 +1,431 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/web/locale"
+)
+
+const (
+	SSHLogSourceAuto    = "auto"
+	SSHLogSourceJournal = "journald"
+	SSHLogSourceFile    = "file"
+)
+
+// 未指定日志文件时按顺序查找，Debian/Ubuntu 为 auth.log，RHEL 系为 secure
+var sshDefaultLogPaths = []string{"/var/log/auth.log", "/var/log/secure"}
+
+var (
+	sshAcceptedRe = regexp.MustCompile(`Accepted (\S+) for (\S+) from (\S+) port (\d+)`)
+	sshFailedRe   = regexp.MustCompile(`Failed (\S+) for (invalid user )?(\S*) from (\S+) port (\d+)`)
+	sshInvalidRe  = regexp.MustCompile(`Invalid user (\S*) from (\S+) port (\d+)`)
+)
+
+// 一条告警里最多列出的用户名数量
+const sshMaxReportUsers = 5
+
+type SSHLoginEvent struct {
+	Time    time.Time
+	Success bool
+	User    string
+	Ip      string
+	Port    string
+	Method  string
+}
+
+// sshFailState 是某个 IP 在当前统计窗口内的失败登录，reported 为已经通知过的次数
+type sshFailState struct {
+	first    time.Time
+	last     time.Time
+	count    int
+	reported int
+	alerted  bool
+	users    map[string]bool
+	pending  []*SSHLoginEvent
+	// 已经以 Invalid user 记录过的连接，跳过紧随其后的同一连接的 Failed 记录
+	invalidConns map[string]bool
+}
+
+// 同一时间只运行一个监听，sshWatchStop 关闭时监听退出
+var sshWatchLock sync.Mutex
+var sshWatchStop chan struct{}
+
+var sshFailLock sync.Mutex
+var sshFailStates = map[string]*sshFailState{}
+
+type SSHWatchService struct {
+	settingService SettingService
+	notifyService  NotifyService
+	ipBlockService IpBlockService
+}
+
+// Start 按设置开始监听 SSH 登录日志，已在运行时不做任何事
+func (s *SSHWatchService) Start() error {
+	sshWatchLock.Lock()
+	defer sshWatchLock.Unlock()
+	if sshWatchStop != nil {
+		return nil
+	}
+	source, err := s.settingService.GetSSHLogSource()
+	if err != nil {
+		return err
+	}
+	path, err := s.settingService.GetSSHLogPath()
+	if err != nil {
+		return err
+	}
+	source, path, err = resolveSSHLogSource(source, path)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	if source == SSHLogSourceJournal {
+		go s.watchJournal(stop)
+		logger.Info("ssh login watcher started on journald")
+	} else {
+		go s.watchFile(path, stop)
+		logger.Info("ssh login watcher started on", path)
+	}
+	sshWatchStop = stop
+	return nil
+}
+
+// Stop 停止监听，可以重复调用
+func (s *SSHWatchService) Stop() {
+	sshWatchLock.Lock()
+	defer sshWatchLock.Unlock()
+	if sshWatchStop == nil {
+		return
+	}
+	close(sshWatchStop)
+	sshWatchStop = nil
+	logger.Info("ssh login watcher stopped")
+}
+
+// Reload 按当前设置重新启动监听，sshWatchEnable 关闭时只停止
+func (s *SSHWatchService) Reload() error {
+	s.Stop()
+	enable, err := s.settingService.GetSSHWatchEnable()
+	if err != nil || !enable {
+		return err
+	}
+	return s.Start()
+}
+
+func resolveSSHLogSource(source string, path string) (string, string, error) {
+	if source == SSHLogSourceJournal {
+		if _, err := exec.LookPath("journalctl"); err != nil {
+			return "", "", common.NewError("journalctl not found")
+		}
+		return source, "", nil
+	}
+	if path == "" {
+		for _, p := range sshDefaultLogPaths {
+			if _, err := os.Stat(p); err == nil {
+				path = p
+				break
+			}
+		}
+	}
+	if path != "" {
+		return SSHLogSourceFile, path, nil
+	}
+	if source == SSHLogSourceFile {
+		return "", "", common.NewError("ssh auth log file not found")
+	}
+	if _, err := exec.LookPath("journalctl"); err == nil {
+		return SSHLogSourceJournal, "", nil
+	}
+	return "", "", common.NewError("neither ssh auth log file nor journalctl found")
+}
+
+func sshWatchStopped(stop chan struct{}, wait time.Duration) bool {
+	select {
+	case <-stop:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+// watchJournal 跟随 journald 中 sshd 的日志，journalctl 意外退出时等待后重新启动
+func (s *SSHWatchService) watchJournal(stop chan struct{}) {
+	for {
+		// 新版 OpenSSH 的认证日志由 sshd-session 进程输出，同一字段的多个条件是或的关系
+		cmd := exec.Command("journalctl", "-f", "-n", "0", "-o", "cat", "_COMM=sshd", "_COMM=sshd-session")
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			logger.Warning("start journalctl failed:", err)
+		} else {
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-stop:
+					_ = cmd.Process.Kill()
+				case <-done:
+				}
+			}()
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				s.handleLine(scanner.Text())
+			}
+			_ = cmd.Wait()
+			close(done)
+		}
+		if sshWatchStopped(stop, time.Second*10) {
+			return
+		}
+	}
+}
+
+// watchFile 从文件末尾开始跟随日志，文件被轮转或截断后从新文件开头继续读
+func (s *SSHWatchService) watchFile(path string, stop chan struct{}) {
+	var file *os.File
+	var reader *bufio.Reader
+	var partial string
+	fromStart := false
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		if file == nil {
+			f, err := os.Open(path)
+			if err != nil {
+				if sshWatchStopped(stop, time.Second*5) {
+					return
+				}
+				continue
+			}
+			if !fromStart {
+				if _, err := f.Seek(0, io.SeekEnd); err != nil {
+					f.Close()
+					logger.Warning("seek ssh auth log failed:", err)
+					return
+				}
+			}
+			file = f
+			reader = bufio.NewReader(f)
+			partial = ""
+			fromStart = true
+		}
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				// 行还没写完整，留到下次拼接
+				partial += line
+				break
+			}
+			s.handleLine(partial + line)
+			partial = ""
+		}
+
+		if sshWatchStopped(stop, time.Second) {
+			return
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			// 轮转时旧文件已移走、新文件尚未创建
+			continue
+		}
+		current, err := file.Stat()
+		offset, seekErr := file.Seek(0, io.SeekCurrent)
+		if err != nil || seekErr != nil || !os.SameFile(stat, current) || stat.Size() < offset {
+			file.Close()
+			file = nil
+		}
+	}
+}
+
+// parseSSHLogLine 解析 sshd 的认证日志，不是登录记录时返回 nil
+func parseSSHLogLine(line string) *SSHLoginEvent {
+	now := time.Now()
+	if m := sshAcceptedRe.FindStringSubmatch(line); m != nil {
+		return &SSHLoginEvent{Time: now, Success: true, Method: m[1], User: m[2], Ip: m[3], Port: m[4]}
+	}
+	if m := sshFailedRe.FindStringSubmatch(line); m != nil {
+		return &SSHLoginEvent{Time: now, Method: m[1], User: m[3], Ip: m[4], Port: m[5]}
+	}
+	if m := sshInvalidRe.FindStringSubmatch(line); m != nil {
+		return &SSHLoginEvent{Time: now, Method: "invalid_user", User: m[1], Ip: m[2], Port: m[3]}
+	}
+	return nil
+}
+
+func (s *SSHWatchService) handleLine(line string) {
+	event := parseSSHLogLine(strings.TrimSpace(line))
+	if event == nil {
+		return
+	}
+	if event.Success {
+		s.notifySuccess(event)
+		return
+	}
+	window, err := s.settingService.GetSSHBruteWindow()
+	if err != nil {
+		return
+	}
+	s.recordFailure(event, time.Duration(window)*time.Minute)
+}
+
+func (s *SSHWatchService) notifySuccess(event *SSHLoginEvent) {
+	// 登录成功说明之前的失败是输错密码，不再算作暴力破解
+	sshFailLock.Lock()
+	delete(sshFailStates, event.Ip)
+	sshFailLock.Unlock()
+
+	msg := locale.Bot("ssh.info",
+		"Username", event.User,
+		"Method", event.Method,
+		"IP", event.Ip,
+		"Time", event.Time.Format("2006-01-02 15:04:05"))
+	s.notifyService.Notify(EventSSHLogin, locale.Bot("ssh.title"), msg)
+}
+
+func (s *SSHWatchService) recordFailure(event *SSHLoginEvent, window time.Duration) {
+	sshFailLock.Lock()
+	defer sshFailLock.Unlock()
+	state, ok := sshFailStates[event.Ip]
+	if ok && event.Time.Sub(state.first) > window && state.reported == state.count {
+		ok = false
+	}
+	if !ok {
+		state = &sshFailState{
+			first:        event.Time,
+			users:        map[string]bool{},
+			invalidConns: map[string]bool{},
+		}
+		sshFailStates[event.Ip] = state
+	}
+	conn := event.Ip + ":" + event.Port
+	if event.Method == "invalid_user" {
+		state.invalidConns[conn] = true
+	} else if state.invalidConns[conn] {
+		delete(state.invalidConns, conn)
+		return
+	}
+	state.count++
+	state.last = event.Time
+	state.users[event.User] = true
+	if !state.alerted {
+		state.pending = append(state.pending, event)
+	}
+}
+
+type sshFailReport struct {
+	ip      string
+	brute   bool
+	count   int
+	users   []string
+	first   time.Time
+	last    time.Time
+	pending []*SSHLoginEvent
+}
+
+// Flush 把积累的失败登录发出通知，短时间内失败次数达到阈值的 IP 合并为一条暴力破解告警
+func (s *SSHWatchService) Flush() {
+	threshold, err := s.settingService.GetSSHBruteThreshold()
+	if err != nil {
+		return
+	}
+	window, err := s.settingService.GetSSHBruteWindow()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	reports := make([]*sshFailReport, 0)
+	sshFailLock.Lock()
+	for ip, state := range sshFailStates {
+		if state.reported == state.count {
+			if now.Sub(state.first) > time.Duration(window)*time.Minute {
+				delete(sshFailStates, ip)
+			}
+			continue
+		}
+		report := &sshFailReport{ip: ip, count: state.count, first: state.first, last: state.last}
+		if threshold > 0 && state.count >= threshold {
+			if !state.alerted {
+				report.brute = true
+				for user := range state.users {
+					report.users = append(report.users, user)
+				}
+				sort.Strings(report.users)
+				reports = append(reports, report)
+			}
+			state.alerted = true
+		} else {
+			report.pending = state.pending
+			reports = append(reports, report)
+		}
+		state.pending = nil
+		state.reported = state.count
+	}
+	sshFailLock.Unlock()
+
+	for _, report := range reports {
+		if report.brute {
+			s.reportBruteForce(report)
+		} else {
+			s.reportFailures(report)
+		}
+	}
+}
+
+func (s *SSHWatchService) reportFailures(report *sshFailReport) {
+	lines := make([]string, 0, len(report.pending))
+	for _, event := range report.pending {
+		lines = append(lines, locale.Bot("ssh.failInfo",
+			"Username", event.User,
+			"Method", event.Method,
+			"IP", event.Ip,
+			"Time", event.Time.Format("2006-01-02 15:04:05")))
+	}
+	s.notifyService.Notify(EventSSHLogin, locale.Bot("ssh.failTitle"), strings.Join(lines, "\r\n \r\n"))
+}
+
+func (s *SSHWatchService) reportBruteForce(report *sshFailReport) {
+	users := report.users
+	if len(users) > sshMaxReportUsers {
+		users = append(users[:sshMaxReportUsers], "...")
+	}
+	msg := locale.Bot("ssh.bruteInfo",
+		"IP", report.ip,
+		"Count", report.count,
+		"Users", strings.Join(users, ", "),
+		"First", report.first.Format("2006-01-02 15:04:05"),
+		"Last", report.last.Format("2006-01-02 15:04:05"))
+
+	autoBlock, err := s.settingService.GetSSHAutoBlock()
+	if err == nil && autoBlock {
+		hours, err := s.settingService.GetSSHBlockDuration()
+		if err == nil {
+			reason := locale.Bot("ssh.blockReason", "Count", report.count)
+			err = s.ipBlockService.BlockIp(report.ip, reason, IpBlockSourceSSH, time.Duration(hours)*time.Hour)
+		}
+		if err != nil {
+			logger.Warning("block ssh brute force ip failed:", report.ip, err)
+		} else {
+			logger.Info("blocked ssh brute force ip", report.ip)
+			msg += "\r\n" + locale.Bot("ssh.blocked")
+		}
+	}
+	s.notifyService.Notify(EventSSHLogin, locale.Bot("ssh.bruteTitle"), msg)
+}
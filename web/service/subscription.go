@@ -0,0 +1,173 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+	"x-ui/database/model"
+	"x-ui/web/locale"
+	"x-ui/xray"
+)
+
+type SubscriptionService struct {
+	inboundService InboundService
+	settingService SettingService
+	addressService AddressService
+}
+
+// Subscription 是某个用户的订阅内容及用量
+type Subscription struct {
+	Traffic  *xray.ClientTraffic
+	Inbound  *model.Inbound
+	Links    []string
+	profiles []*clientProfile
+}
+
+// clientUsage 返回用户实际生效的用量、总量和到期时间，用户自身未设置时使用入站的
+func clientUsage(traffic *xray.ClientTraffic, inbound *model.Inbound) (used int64, total int64, expiryTime int64) {
+	used = traffic.Up + traffic.Down
+	total = traffic.Total
+	if total == 0 && inbound != nil && inbound.Total > 0 {
+		used = inbound.QuotaUsed()
+		total = inbound.Total
+	}
+	expiryTime = traffic.ExpiryTime
+	if inbound != nil && inbound.ExpiryTime > 0 && (expiryTime == 0 || inbound.ExpiryTime < expiryTime) {
+		expiryTime = inbound.ExpiryTime
+	}
+	return
+}
+
+func (s *SubscriptionService) clientProfiles(traffic *xray.ClientTraffic, inbound *model.Inbound) ([]*clientProfile, error) {
+	profiles, err := s.inboundService.clientProfiles(inbound)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*clientProfile, 0, 1)
+	for _, profile := range profiles {
+		if profile.Email == traffic.Email {
+			result = append(result, profile)
+		}
+	}
+	return result, nil
+}
+
+func (s *SubscriptionService) getSubscription(traffic *xray.ClientTraffic) (*Subscription, error) {
+	inbound, err := s.inboundService.GetInbound(traffic.InboundId)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := s.clientProfiles(traffic, inbound)
+	if err != nil {
+		return nil, err
+	}
+	shareLinks, err := profileLinks(profiles)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]string, 0, len(shareLinks))
+	for _, link := range shareLinks {
+		links = append(links, link.Link)
+	}
+	return &Subscription{
+		Traffic:  traffic,
+		Inbound:  inbound,
+		Links:    links,
+		profiles: profiles,
+	}, nil
+}
+
+func (s *SubscriptionService) GetSubscription(token string) (*Subscription, error) {
+	traffic, err := s.inboundService.GetClientTrafficBySubToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return s.getSubscription(traffic)
+}
+
+func (s *SubscriptionService) GetSubscriptionByEmail(email string) (*Subscription, error) {
+	traffic, err := s.inboundService.GetClientTrafficByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	return s.getSubscription(traffic)
+}
+
+// UserInfo 生成 Subscription-Userinfo 响应头，客户端据此显示用量和到期时间
+func (sub *Subscription) UserInfo() string {
+	_, total, expiryTime := clientUsage(sub.Traffic, sub.Inbound)
+	up, down := sub.Traffic.Up, sub.Traffic.Down
+	if sub.Traffic.Total == 0 && total > 0 {
+		up, down = sub.Inbound.Up, sub.Inbound.Down
+	}
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", up, down, total, expiryTime/1000)
+}
+
+// ClientConfig 生成订阅用户的完整客户端配置，format 为 ClientFormatXray 等
+func (sub *Subscription) ClientConfig(format string) ([]byte, error) {
+	return GenClientConfig(format, sub.profiles)
+}
+
+// GetSubURL 返回用户的订阅地址，未启用订阅时返回空字符串
+func (s *SubscriptionService) GetSubURL(token string) (string, error) {
+	enable, err := s.settingService.GetSubEnable()
+	if err != nil || !enable {
+		return "", err
+	}
+	uri, err := s.settingService.GetSubURI()
+	if err != nil {
+		return "", err
+	}
+	if uri == "" {
+		port, err := s.settingService.GetPort()
+		if err != nil {
+			return "", err
+		}
+		basePath, err := s.settingService.GetBasePath()
+		if err != nil {
+			return "", err
+		}
+		certFile, err := s.settingService.GetCertFile()
+		if err != nil {
+			return "", err
+		}
+		scheme := "http"
+		if certFile != "" {
+			scheme = "https"
+		}
+		uri = fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(s.addressService.GetPublicAddress(), strconv.Itoa(port)), basePath)
+	}
+	return uri + "sub/" + token, nil
+}
+
+// SubURL 是某个用户的订阅地址
+type SubURL struct {
+	Email string `json:"email"`
+	Url   string `json:"url"`
+}
+
+// GetInboundSubURLs 返回入站下所有用户的订阅地址，未启用订阅时返回错误
+func (s *SubscriptionService) GetInboundSubURLs(inboundId int) ([]SubURL, error) {
+	traffics, err := s.inboundService.GetClientTraffics(inboundId)
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]SubURL, 0, len(traffics))
+	for _, traffic := range traffics {
+		url, err := s.GetSubURL(traffic.SubToken)
+		if err != nil {
+			return nil, err
+		}
+		if url == "" {
+			return nil, locale.NewError("shareLink.subDisabled")
+		}
+		subs = append(subs, SubURL{Email: traffic.Email, Url: url})
+	}
+	return subs, nil
+}
+
+// remainDays 返回距离到期的天数，向下取整
+func remainDays(expiryTime int64) int64 {
+	return (expiryTime/1000 - time.Now().Unix()) / 86400
+}
@@ -1,14 +1,21 @@
 package service
 
 import (
+	"crypto/subtle"
 	"fmt"
-	"log"
+	"net"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 	"x-ui/logger"
 	"x-ui/util/common"
+	"x-ui/util/random"
+	"x-ui/web/entity"
+	"x-ui/web/locale"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/shirou/gopsutil/host"
@@ -18,12 +25,18 @@ import (
 //This should be global variable,and only one instance
 var botInstace *tgbotapi.BotAPI
 
+// 保护 botInstace 的启动、停止和替换，botWebhook 表示当前是否处于 webhook 模式
+var botLock sync.RWMutex
+var botWebhook bool
+
 //结构体类型大写表示可以被其他包访问
 type TelegramService struct {
-	xrayService    XrayService
-	serverService  ServerService
-	inboundService InboundService
-	settingService SettingService
+	xrayService     XrayService
+	xrayCoreService XrayCoreService
+	inboundService  InboundService
+	settingService  SettingService
+	userService     UserService
+	addressService  AddressService
 }
 
 func (s *TelegramService) GetsystemStatus() string {
@@ -34,215 +47,488 @@ func (s *TelegramService) GetsystemStatus() string {
 		fmt.Println("get hostname error:", err)
 		return ""
 	}
-	status = fmt.Sprintf("主机名称:%s\r\n", name)
-	status += fmt.Sprintf("系统类型:%s\r\n", runtime.GOOS)
-	status += fmt.Sprintf("系统架构:%s\r\n", runtime.GOARCH)
+	status = locale.Bot("tgbot.status.hostname", "Hostname", name) + "\r\n"
+	status += locale.Bot("tgbot.status.os", "OS", runtime.GOOS) + "\r\n"
+	status += locale.Bot("tgbot.status.arch", "Arch", runtime.GOARCH) + "\r\n"
 	avgState, err := load.Avg()
 	if err != nil {
 		logger.Warning("get load avg failed:", err)
 	} else {
-		status += fmt.Sprintf("系统负载:%.2f,%.2f,%.2f\r\n", avgState.Load1, avgState.Load5, avgState.Load15)
+		load := fmt.Sprintf("%.2f,%.2f,%.2f", avgState.Load1, avgState.Load5, avgState.Load15)
+		status += locale.Bot("tgbot.status.load", "Load", load) + "\r\n"
 	}
 	upTime, err := host.Uptime()
 	if err != nil {
 		logger.Warning("get uptime failed:", err)
 	} else {
-		status += fmt.Sprintf("运行时间:%s\r\n", common.FormatTime(upTime))
+		status += locale.Bot("tgbot.status.uptime", "Uptime", common.FormatTime(upTime)) + "\r\n"
 	}
 	//xray version
-	status += fmt.Sprintf("xray版本:%s\r\n", s.xrayService.GetXrayVersion())
+	status += locale.Bot("tgbot.status.xrayVersion", "Version", s.xrayService.GetXrayVersion()) + "\r\n"
 	//ip address
-	var ip string
-	ip = common.GetMyIpAddr()
-	status += fmt.Sprintf("IP地址:%s\r\n \r\n", ip)
+	status += locale.Bot("report.ip", "IP", s.addressService.GetPublicAddresses()) + "\r\n \r\n"
 	//get traffic
 	inbouds, err := s.inboundService.GetAllInbounds()
 	if err != nil {
 		logger.Warning("StatsNotifyJob run error:", err)
 	}
 	for _, inbound := range inbouds {
-		status += fmt.Sprintf("节点名称:%s\r\n端口:%d\r\n上行流量↑:%s\r\n下行流量↓:%s\r\n总流量:%s\r\n", inbound.Remark, inbound.Port, common.FormatTraffic(inbound.Up), common.FormatTraffic(inbound.Down), common.FormatTraffic((inbound.Up + inbound.Down)))
-		if inbound.ExpiryTime == 0 {
-			status += fmt.Sprintf("到期时间:无限期\r\n \r\n")
-		} else {
-			status += fmt.Sprintf("到期时间:%s\r\n \r\n", time.Unix((inbound.ExpiryTime/1000), 0).Format("2006-01-02 15:04:05"))
-		}
+		status += InboundReport(inbound) + "\r\n \r\n"
 	}
 	return status
 }
 
-func (s *TelegramService) StartRun() {
-	logger.Info("telegram service ready to run")
-	s.settingService = SettingService{}
-	tgBottoken, err := s.settingService.GetTgBotToken()
-	if err != nil || tgBottoken == "" {
-		logger.Infof("telegram service start run failed,GetTgBotToken fail,err:%v,tgBottoken:%s", err, tgBottoken)
-		return
+const (
+	TgBotModePolling = "polling"
+	TgBotModeWebhook = "webhook"
+)
+
+func getBot() *tgbotapi.BotAPI {
+	botLock.RLock()
+	defer botLock.RUnlock()
+	return botInstace
+}
+
+func newBot(token string, apiServer string) (*tgbotapi.BotAPI, error) {
+	if apiServer == "" {
+		return tgbotapi.NewBotAPI(token)
 	}
-	logger.Infof("TelegramService GetTgBotToken:%s", tgBottoken)
-	botInstace, err = tgbotapi.NewBotAPI(tgBottoken)
+	endpoint := strings.TrimSuffix(apiServer, "/") + "/bot%s/%s"
+	return tgbotapi.NewBotAPIWithAPIEndpoint(token, endpoint)
+}
+
+// Start 按设置启动机器人，轮询模式在后台接收消息，webhook 模式向 Telegram 注册回调地址，已启动时不做任何事
+func (s *TelegramService) Start() error {
+	botLock.Lock()
+	defer botLock.Unlock()
+	if botInstace != nil {
+		return nil
+	}
+	token, err := s.settingService.GetTgBotToken()
 	if err != nil {
-		logger.Infof("telegram service start run failed,NewBotAPI fail:%v,tgBottoken:%s", err, tgBottoken)
+		return err
+	}
+	if token == "" {
+		return common.NewError("telegram bot token is empty")
+	}
+	apiServer, err := s.settingService.GetTgBotApiServer()
+	if err != nil {
+		return err
+	}
+	mode, err := s.settingService.GetTgBotMode()
+	if err != nil {
+		return err
+	}
+	bot, err := newBot(token, apiServer)
+	if err != nil {
+		return common.NewError("create telegram bot failed:", err)
+	}
+
+	if mode == TgBotModeWebhook {
+		link, err := s.webhookURL()
+		if err != nil {
+			return err
+		}
+		webhook, err := tgbotapi.NewWebhook(link)
+		if err != nil {
+			return err
+		}
+		if _, err := bot.Request(webhook); err != nil {
+			return common.NewError("set telegram webhook failed:", err)
+		}
+	} else {
+		// 设置过 webhook 时 getUpdates 会失败，切换回轮询前先删除
+		if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			return common.NewError("delete telegram webhook failed:", err)
+		}
+		config := tgbotapi.NewUpdate(0)
+		config.Timeout = 60
+		updates := bot.GetUpdatesChan(config)
+		go func() {
+			for update := range updates {
+				s.handleUpdate(update)
+			}
+		}()
+	}
+	botInstace = bot
+	botWebhook = mode == TgBotModeWebhook
+	logger.Infof("telegram bot @%s started in %s mode", bot.Self.UserName, mode)
+	return nil
+}
+
+// Stop 停止接收消息，webhook 模式下同时删除注册的回调地址，可以重复调用
+func (s *TelegramService) Stop() {
+	botLock.Lock()
+	bot := botInstace
+	webhook := botWebhook
+	botInstace = nil
+	botLock.Unlock()
+	if bot == nil {
 		return
 	}
-	botInstace.Debug = false
-	fmt.Printf("Authorized on account %s", botInstace.Self.UserName)
-	//get all my commands
-	commands, err := botInstace.GetMyCommands()
+	if webhook {
+		if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			logger.Warning("delete telegram webhook failed:", err)
+		}
+	} else {
+		bot.StopReceivingUpdates()
+	}
+	logger.Info("telegram bot stopped")
+}
+
+// Reload 按当前设置重新启动机器人，tgBotEnable 关闭时只停止
+func (s *TelegramService) Reload() error {
+	s.Stop()
+	enable, err := s.settingService.GetTgbotenabled()
+	if err != nil || !enable {
+		return err
+	}
+	return s.Start()
+}
+
+// webhookURL 返回注册到 Telegram 的回调地址，未设置面板公网地址时使用本机 IP、面板端口和根路径
+func (s *TelegramService) webhookURL() (string, error) {
+	base, err := s.settingService.GetTgWebhookUrl()
 	if err != nil {
-		logger.Warning("telegram service start run error,GetMyCommandsfail:", err)
+		return "", err
 	}
-	for _, command := range commands {
-		fmt.Printf("command %s,Description:%s \r\n", command.Command, command.Description)
+	if base == "" {
+		port, err := s.settingService.GetPort()
+		if err != nil {
+			return "", err
+		}
+		basePath, err := s.settingService.GetBasePath()
+		if err != nil {
+			return "", err
+		}
+		ip := s.addressService.GetPublicAddress()
+		if ip == "" {
+			return "", common.NewError("get public ip failed, please set the webhook url")
+		}
+		base = fmt.Sprintf("https://%s%s", net.JoinHostPort(ip, strconv.Itoa(port)), basePath)
+	}
+	secret, err := s.settingService.GetTgWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	return base + "tgbot/" + secret, nil
+}
+
+// HandleWebhook 处理 Telegram 推送到回调地址的消息，secret 不匹配或未处于 webhook 模式时返回错误
+func (s *TelegramService) HandleWebhook(secret string, r *http.Request) error {
+	botLock.RLock()
+	bot := botInstace
+	webhook := botWebhook
+	botLock.RUnlock()
+	if bot == nil || !webhook {
+		return common.NewError("telegram webhook not enabled")
+	}
+	expected, err := s.settingService.GetTgWebhookSecret()
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 {
+		return common.NewError("telegram webhook secret mismatch")
+	}
+	update, err := bot.HandleUpdate(r)
+	if err != nil {
+		return err
+	}
+	go s.handleUpdate(*update)
+	return nil
+}
+
+func (s *TelegramService) handleUpdate(update tgbotapi.Update) {
+	var text string
+	var chatId int64
+	switch {
+	case update.CallbackQuery != nil:
+		s.handleCallback(update.CallbackQuery)
+		return
+	case update.Message == nil:
+		return
+	case update.Message.IsCommand():
+		chatId = update.Message.Chat.ID
+		text = s.handleCommand(update.Message)
+	default:
+		chatId = update.Message.Chat.ID
+		text = s.handleConversation(update.Message)
+	}
+	if text == "" {
+		return
+	}
+	s.send(tgbotapi.NewMessage(chatId, text))
+}
+
+// 需要二次确认的危险命令
+var tgConfirmCommands = map[string]bool{
+	"delete":   true,
+	"clearall": true,
+}
+
+const tgConfirmTimeout = time.Minute
+
+type tgPendingConfirm struct {
+	command string
+	args    string
+	code    string
+	expire  time.Time
+}
+
+var tgConfirmLock sync.Mutex
+
+// 按 chat id 和 user id 记录等待确认的命令
+var tgPendingConfirms = map[string]*tgPendingConfirm{}
+
+// 同一 id 的越权尝试在这段时间内只上报一次
+const tgDeniedReportInterval = time.Minute * 10
+
+var tgDeniedReported = map[int64]time.Time{}
+
+// tgCaller 是发起命令或点击按钮的会话和用户
+type tgCaller struct {
+	chatId   int64
+	userId   int64
+	username string
+}
+
+func callerOfMessage(message *tgbotapi.Message) *tgCaller {
+	caller := &tgCaller{chatId: message.Chat.ID}
+	if message.From != nil {
+		caller.userId = message.From.ID
+		caller.username = message.From.UserName
+	}
+	return caller
+}
+
+func callerOfCallback(query *tgbotapi.CallbackQuery) *tgCaller {
+	caller := &tgCaller{}
+	if query.Message != nil {
+		caller.chatId = query.Message.Chat.ID
+	}
+	if query.From != nil {
+		caller.userId = query.From.ID
+		caller.username = query.From.UserName
 	}
-	//get update
-	chanMessage := tgbotapi.NewUpdate(0)
-	chanMessage.Timeout = 60
+	return caller
+}
+
+func (c *tgCaller) key() string {
+	return fmt.Sprintf("%d:%d", c.chatId, c.userId)
+}
 
-	updates := botInstace.GetUpdatesChan(chanMessage)
+// isAllowed chat id 或发送者 user id 之一在管理员列表中拥有该命令权限即可
+func (s *TelegramService) isAllowed(admins entity.TgBotAdmins, caller *tgCaller, command string) bool {
+	if caller.chatId != 0 && admins.Allow(caller.chatId, command) {
+		return true
+	}
+	return caller.userId != 0 && admins.Allow(caller.userId, command)
+}
 
-	for update := range updates {
-		if update.Message == nil {
-			//NOTE:may ther are different bot instance,we could use different bot endApiPoint
-			updates.Clear()
-			continue
+func (s *TelegramService) isAdmin(admins entity.TgBotAdmins, caller *tgCaller) bool {
+	if _, ok := admins[caller.chatId]; ok && caller.chatId != 0 {
+		return true
+	}
+	_, ok := admins[caller.userId]
+	return ok && caller.userId != 0
+}
+
+func (s *TelegramService) reportDenied(caller *tgCaller, action string) {
+	id := caller.userId
+	if id == 0 {
+		id = caller.chatId
+	}
+	now := time.Now()
+	tgConfirmLock.Lock()
+	// 过了上报间隔的记录已经没有用了，顺便清理掉，避免大量陌生用户让记录无限增长
+	for key, last := range tgDeniedReported {
+		if now.Sub(last) >= tgDeniedReportInterval {
+			delete(tgDeniedReported, key)
 		}
+	}
+	if _, ok := tgDeniedReported[id]; ok {
+		tgConfirmLock.Unlock()
+		return
+	}
+	tgDeniedReported[id] = now
+	tgConfirmLock.Unlock()
 
-		if !update.Message.IsCommand() {
-			continue
+	logger.Warningf("telegram bot action %s denied, chat id: %d, user id: %d, username: %s",
+		action, caller.chatId, caller.userId, caller.username)
+	info := locale.Bot("tgbot.denied.info", "Action", action, "ChatId", caller.chatId,
+		"UserId", caller.userId, "Username", caller.username)
+	notifyService := NotifyService{}
+	notifyService.Notify(EventBotAuth, locale.Bot("tgbot.denied.title"), info)
+}
+
+func (s *TelegramService) handleCommand(message *tgbotapi.Message) string {
+	command := message.Command()
+	caller := callerOfMessage(message)
+	admins, err := s.settingService.GetTgBotAdmins()
+	if err != nil {
+		logger.Warning("get telegram bot admins failed:", err)
+		return locale.Bot("tgbot.adminsInvalid")
+	}
+	if !s.isAdmin(admins, caller) {
+		if enable, _ := s.settingService.GetTgClientBotEnable(); enable && tgClientCommands[command] {
+			return s.handleClientCommand(message)
 		}
+		s.reportDenied(caller, message.Text)
+		return locale.Bot("tgbot.unauthorized")
+	}
+
+	switch command {
+	case "confirm":
+		return s.confirmCommand(admins, caller, message.CommandArguments())
+	case "cancel":
+		tgConfirmLock.Lock()
+		delete(tgPendingConfirms, caller.key())
+		delete(tgConversations, caller.key())
+		tgConfirmLock.Unlock()
+		return locale.Bot("tgbot.canceled")
+	case "menu":
+		if !s.isAllowed(admins, caller, "inbounds") {
+			s.reportDenied(caller, message.Text)
+			return locale.Bot("tgbot.commandDenied", "Command", command)
+		}
+		s.sendInboundList(caller.chatId, 0, admins, caller)
+		return ""
+	case "delete", "restart", "disable", "enable", "clear", "clearall", "version", "status":
+		if !s.isAllowed(admins, caller, command) {
+			s.reportDenied(caller, message.Text)
+			return locale.Bot("tgbot.commandDenied", "Command", command)
+		}
+	default:
+		return s.helpText(admins, caller)
+	}
+
+	if tgConfirmCommands[command] {
+		pending := &tgPendingConfirm{
+			command: command,
+			args:    message.CommandArguments(),
+			code:    random.Seq(6),
+			expire:  time.Now().Add(tgConfirmTimeout),
+		}
+		tgConfirmLock.Lock()
+		tgPendingConfirms[caller.key()] = pending
+		tgConfirmLock.Unlock()
+		return locale.Bot("tgbot.confirm.prompt", "Command", pending.command, "Args", pending.args,
+			"Code", pending.code, "Seconds", int(tgConfirmTimeout.Seconds()))
+	}
+	return s.runCommand(command, message.CommandArguments())
+}
 
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+func (s *TelegramService) confirmCommand(admins entity.TgBotAdmins, caller *tgCaller, code string) string {
+	code = strings.TrimSpace(code)
+	tgConfirmLock.Lock()
+	pending := tgPendingConfirms[caller.key()]
+	if pending != nil && pending.code == code {
+		delete(tgPendingConfirms, caller.key())
+	}
+	tgConfirmLock.Unlock()
 
-		// Extract the command from the Message.
-		switch update.Message.Command() {
+	if pending == nil || time.Now().After(pending.expire) {
+		return locale.Bot("tgbot.confirm.none")
+	}
+	if pending.code != code {
+		return locale.Bot("tgbot.confirm.mismatch")
+	}
+	if !s.isAllowed(admins, caller, pending.command) {
+		s.reportDenied(caller, "/"+pending.command)
+		return locale.Bot("tgbot.commandDenied", "Command", pending.command)
+	}
+	return s.runCommand(pending.command, pending.args)
+}
+
+func (s *TelegramService) helpText(admins entity.TgBotAdmins, caller *tgCaller) string {
+	commands := []string{"inbounds", "delete", "restart", "status", "enable", "disable", "clear", "clearall", "version"}
+	text := ""
+	for _, command := range commands {
+		if s.isAllowed(admins, caller, command) {
+			text += locale.Bot("tgbot.help."+command) + "\n"
+		}
+	}
+	return text + locale.Bot("tgbot.help.more")
+}
+
+func (s *TelegramService) runCommand(command string, args string) string {
+	switch command {
+	case "delete", "disable", "enable", "clear":
+		port, err := strconv.Atoi(args)
+		if err != nil {
+			return locale.Bot("tgbot.invalidPort")
+		}
+		switch command {
 		case "delete":
-			inboundPortStr := update.Message.CommandArguments()
-			inboundPortValue, err := strconv.Atoi(inboundPortStr)
-			if err != nil {
-				msg.Text = "Invalid inbound port,please check it"
-				break
-			}
-			//logger.Infof("Will delete port:%d inbound", inboundPortValue)
-			error := s.inboundService.DelInboundByPort(inboundPortValue)
-			if error != nil {
-				msg.Text = fmt.Sprintf("delete inbound whoes port is %d failed", inboundPortValue)
-			} else {
-				msg.Text = fmt.Sprintf("delete inbound whoes port is %d success", inboundPortValue)
-			}
-		case "restart":
-			err := s.xrayService.RestartXray(true)
-			if err != nil {
-				msg.Text = fmt.Sprintln("Restart xray failed,error:", err)
-			} else {
-				msg.Text = "Restart xray success"
-			}
+			err = s.inboundService.DelInboundByPort(port)
 		case "disable":
-			inboundPortStr := update.Message.CommandArguments()
-			inboundPortValue, err := strconv.Atoi(inboundPortStr)
-			if err != nil {
-				msg.Text = "Invalid inbound port,please check it"
-				break
-			}
-			//logger.Infof("Will delete port:%d inbound", inboundPortValue)
-			error := s.inboundService.DisableInboundByPort(inboundPortValue)
-			if error != nil {
-				msg.Text = fmt.Sprintf("disable inbound whoes port is %d failed,err:%s", inboundPortValue, error)
-			} else {
-				msg.Text = fmt.Sprintf("disable inbound whoes port is %d success", inboundPortValue)
-			}
+			err = s.inboundService.DisableInboundByPort(port)
 		case "enable":
-			inboundPortStr := update.Message.CommandArguments()
-			inboundPortValue, err := strconv.Atoi(inboundPortStr)
-			if err != nil {
-				msg.Text = "Invalid inbound port,please check it"
-				break
-			}
-			//logger.Infof("Will delete port:%d inbound", inboundPortValue)
-			error := s.inboundService.EnableInboundByPort(inboundPortValue)
-			if error != nil {
-				msg.Text = fmt.Sprintf("enable inbound whoes port is %d failed,err:%s", inboundPortValue, error)
-			} else {
-				msg.Text = fmt.Sprintf("enable inbound whoes port is %d success", inboundPortValue)
-			}
+			err = s.inboundService.EnableInboundByPort(port)
 		case "clear":
-			inboundPortStr := update.Message.CommandArguments()
-			inboundPortValue, err := strconv.Atoi(inboundPortStr)
-			if err != nil {
-				msg.Text = "Invalid inbound port,please check it"
-				break
-			}
-			error := s.inboundService.ClearTrafficByPort(inboundPortValue)
-			if error != nil {
-				msg.Text = fmt.Sprintf("Clear Traffic whose port is %d failed,err:%s", inboundPortValue, error)
-			} else {
-				msg.Text = fmt.Sprintf("Clear Traffic whose port is %d success", inboundPortValue)
-			}
-
-		case "clearall":
-			error := s.inboundService.ClearAllInboundTraffic()
-			if error != nil {
-				msg.Text = fmt.Sprintf("Clear All inbound Traffic failed,err:%s", error)
-			} else {
-				msg.Text = fmt.Sprintf("Clear All inbound Traffic success")
-			}
-		case "version":
-			versionStr := update.Message.CommandArguments()
-			currentVersion, _ := s.serverService.GetXrayVersions()
-			if currentVersion[0] == versionStr {
-				msg.Text = fmt.Sprintf("can't change same version to %s", versionStr)
-			}
-			error := s.serverService.UpdateXray(versionStr)
-			if error != nil {
-				msg.Text = fmt.Sprintf("change version to %s failed,err:%s", versionStr, error)
-			} else {
-				msg.Text = fmt.Sprintf("change version to %s  success", versionStr)
-			}
-		case "status":
-			msg.Text = s.GetsystemStatus()
-		default:
-			//NOTE:here we need string as a new line each one,we should use ``
-			msg.Text = `/delete will help you delete inbound according port
-/restart will restart xray,this command will not restart x-ui
-/status will get current system info
-/enable will enable inbound according port
-/disable will disable inbound according port
-/clear will clear inbound traffic accoring port
-/clearall will cleal all inbouns traffic
-/version will change xray version to specific one
-You can input /help to see more commands`
+			err = s.inboundService.ClearTrafficByPort(port)
 		}
-
-		if _, err := botInstace.Send(msg); err != nil {
-			log.Panic(err)
+		if err != nil {
+			return locale.Bot("tgbot.run."+command+"Fail", "Port", port, "Error", err)
 		}
+		if command != "clear" {
+			s.xrayService.SetToNeedRestart()
+		}
+		return locale.Bot("tgbot.run."+command+"Success", "Port", port)
+	case "restart":
+		err := s.xrayService.RestartXray(true)
+		if err != nil {
+			return locale.Bot("tgbot.run.restartFail", "Error", err)
+		}
+		return locale.Bot("tgbot.run.restartSuccess")
+	case "clearall":
+		err := s.inboundService.ClearAllInboundTraffic()
+		if err != nil {
+			return locale.Bot("tgbot.run.clearallFail", "Error", err)
+		}
+		return locale.Bot("tgbot.run.clearallSuccess")
+	case "version":
+		if normalizeXrayVersion(s.xrayService.GetXrayVersion()) == normalizeXrayVersion(args) {
+			return locale.Bot("tgbot.run.versionSame", "Version", args)
+		}
+		version, err := s.xrayCoreService.InstallRelease(args)
+		if err == nil {
+			err = s.xrayCoreService.Switch(version)
+		}
+		if err != nil {
+			return locale.Bot("tgbot.run.versionFail", "Version", args, "Error", err)
+		}
+		return locale.Bot("tgbot.run.versionSuccess", "Version", args)
+	case "status":
+		return s.GetsystemStatus()
 	}
-
+	return ""
 }
 
 func (s *TelegramService) SendMsgToTgbot(msg string) {
 	logger.Info("SendMsgToTgbot entered")
-	tgBotid, err := s.settingService.GetTgBotChatId()
+	err := s.SendMsgToChat(0, msg)
 	if err != nil {
-		logger.Warning("sendMsgToTgbot failed,GetTgBotChatId fail:", err)
-		return
-	}
-	if tgBotid == 0 {
-		logger.Warning("sendMsgToTgbot failed,GetTgBotChatId illegal")
-		return
-	}
-
-	info := tgbotapi.NewMessage(int64(tgBotid), msg)
-	if botInstace != nil {
-		botInstace.Send(info)
-	} else {
-		logger.Warning("bot instance is nil")
+		logger.Warning("sendMsgToTgbot failed:", err)
 	}
 }
 
-//NOTE:This function can't be called repeatly
-func (s *TelegramService) StopRunAndClose() {
-	if botInstace != nil {
-		botInstace.StopReceivingUpdates()
+// SendMsgToChat 发送消息到指定会话，chatId 为 0 时发送到设置中的 tgBotChatId
+func (s *TelegramService) SendMsgToChat(chatId int64, msg string) error {
+	if chatId == 0 {
+		tgBotid, err := s.settingService.GetTgBotChatId()
+		if err != nil {
+			return err
+		}
+		chatId = int64(tgBotid)
+	}
+	if chatId == 0 {
+		return common.NewError("telegram chat id illegal")
+	}
+	bot := getBot()
+	if bot == nil {
+		return common.NewError("bot instance is nil")
 	}
+	_, err := bot.Send(tgbotapi.NewMessage(chatId, msg))
+	return err
 }
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 开启用户自助查询后非管理员可以使用的命令，其他命令仍按无权限处理
+var tgClientCommands = map[string]bool{
+	"start":  true,
+	"help":   true,
+	"link":   true,
+	"unlink": true,
+	"usage":  true,
+	"links":  true,
+	"sub":    true,
+}
+
+// handleClientCommand 处理非管理员用户的命令，只能查看自己绑定的账号
+func (s *TelegramService) handleClientCommand(message *tgbotapi.Message) string {
+	caller := callerOfMessage(message)
+	if !message.Chat.IsPrivate() {
+		return locale.Bot("tgbot.client.privateOnly")
+	}
+	switch message.Command() {
+	case "link":
+		return s.linkClient(caller, message.CommandArguments())
+	case "unlink":
+		return s.unlinkClient(caller, strings.TrimSpace(message.CommandArguments()))
+	case "usage":
+		return s.clientUsageText(caller)
+	case "links":
+		return s.sendClientLinks(caller)
+	case "sub":
+		return s.clientSubText(caller)
+	}
+	return locale.Bot("tgbot.client.help")
+}
+
+// handleClientText 非管理员用户在私聊中直接发送的文本视为 UUID 或订阅 token，尝试绑定
+func (s *TelegramService) handleClientText(message *tgbotapi.Message) string {
+	if !message.Chat.IsPrivate() || message.Text == "" {
+		return ""
+	}
+	if enable, _ := s.settingService.GetTgClientBotEnable(); !enable {
+		return ""
+	}
+	caller := callerOfMessage(message)
+	admins, err := s.settingService.GetTgBotAdmins()
+	if err != nil || s.isAdmin(admins, caller) {
+		return ""
+	}
+	return s.linkClient(caller, message.Text)
+}
+
+func (s *TelegramService) getBindings(tgUserId int64) ([]*model.TgClientBinding, error) {
+	db := database.GetDB()
+	bindings := make([]*model.TgClientBinding, 0)
+	err := db.Model(model.TgClientBinding{}).Where("tg_user_id = ?", tgUserId).Find(&bindings).Error
+	return bindings, err
+}
+
+func (s *TelegramService) linkClient(caller *tgCaller, key string) string {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return locale.Bot("tgbot.client.linkUsage")
+	}
+	traffic, err := s.inboundService.FindClient(key)
+	if database.IsNotFound(err) {
+		return locale.Bot("tgbot.client.notFound")
+	} else if err != nil {
+		logger.Warning("telegram find client failed:", err)
+		return locale.Bot("tgbot.client.queryFail")
+	}
+
+	db := database.GetDB()
+	var count int64
+	err = db.Model(model.TgClientBinding{}).
+		Where("tg_user_id = ? and email = ?", caller.userId, traffic.Email).
+		Count(&count).Error
+	if err != nil {
+		return locale.Bot("tgbot.client.linkFail")
+	}
+	if count > 0 {
+		return locale.Bot("tgbot.client.linked", "Email", traffic.Email)
+	}
+	binding := &model.TgClientBinding{
+		TgUserId:  caller.userId,
+		ChatId:    caller.chatId,
+		Email:     traffic.Email,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := db.Create(binding).Error; err != nil {
+		logger.Warning("telegram create client binding failed:", err)
+		return locale.Bot("tgbot.client.linkFail")
+	}
+	logger.Infof("telegram user %d linked to client %s", caller.userId, traffic.Email)
+	return locale.Bot("tgbot.client.linkSuccess", "Email", traffic.Email) + "\n\n" + locale.Bot("tgbot.client.help")
+}
+
+func (s *TelegramService) unlinkClient(caller *tgCaller, email string) string {
+	db := database.GetDB().Where("tg_user_id = ?", caller.userId)
+	if email != "" {
+		db = db.Where("email = ?", email)
+	}
+	result := db.Delete(model.TgClientBinding{})
+	if result.Error != nil {
+		return locale.Bot("tgbot.client.unlinkFail")
+	}
+	if result.RowsAffected == 0 {
+		return locale.Bot("tgbot.client.noBinding")
+	}
+	return locale.Bot("tgbot.client.unlinked")
+}
+
+func (s *TelegramService) boundSubscriptions(caller *tgCaller) ([]*Subscription, string) {
+	bindings, err := s.getBindings(caller.userId)
+	if err != nil {
+		return nil, locale.Bot("tgbot.client.queryFail")
+	}
+	if len(bindings) == 0 {
+		return nil, locale.Bot("tgbot.client.notLinked")
+	}
+	subscriptionService := SubscriptionService{}
+	subs := make([]*Subscription, 0, len(bindings))
+	for _, binding := range bindings {
+		sub, err := subscriptionService.GetSubscriptionByEmail(binding.Email)
+		if err != nil {
+			logger.Warning("telegram get subscription failed:", binding.Email, err)
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 0 {
+		return nil, locale.Bot("tgbot.client.bindingGone")
+	}
+	return subs, ""
+}
+
+func clientUsageDetail(traffic *xray.ClientTraffic, inbound *model.Inbound) string {
+	used, total, expiryTime := clientUsage(traffic, inbound)
+	text := locale.Bot("tgbot.client.account", "Email", traffic.Email) + "\n"
+	if !traffic.Enable || !inbound.Enable {
+		text += locale.Bot("tgbot.client.stateDisabled") + "\n"
+	} else {
+		text += locale.Bot("tgbot.client.stateNormal") + "\n"
+	}
+	text += locale.Bot("tgbot.menu.traffic", "Up", common.FormatTraffic(traffic.Up), "Down", common.FormatTraffic(traffic.Down)) + "\n"
+	if total > 0 {
+		remain := total - used
+		if remain < 0 {
+			remain = 0
+		}
+		text += locale.Bot("tgbot.menu.usage", "Used", common.FormatTraffic(used), "Total", common.FormatTraffic(total)) + "\n"
+		text += locale.Bot("tgbot.client.remain", "Remain", common.FormatTraffic(remain)) + "\n"
+	} else {
+		text += locale.Bot("tgbot.menu.unlimitedTotal") + "\n"
+	}
+	text += locale.Bot("tgbot.menu.expiry", "Expiry", formatExpiry(expiryTime)) + "\n"
+	if expiryTime > 0 {
+		text += locale.Bot("tgbot.client.remainDays", "Days", remainDays(expiryTime)) + "\n"
+	}
+	return text
+}
+
+func (s *TelegramService) clientUsageText(caller *tgCaller) string {
+	subs, errText := s.boundSubscriptions(caller)
+	if subs == nil {
+		return errText
+	}
+	texts := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		texts = append(texts, clientUsageDetail(sub.Traffic, sub.Inbound))
+	}
+	return strings.Join(texts, "\n")
+}
+
+func (s *TelegramService) sendClientLinks(caller *tgCaller) string {
+	subs, errText := s.boundSubscriptions(caller)
+	if subs == nil {
+		return errText
+	}
+	for _, sub := range subs {
+		s.sendShareLinks(caller.chatId, sub.Inbound, sub.Traffic.Email)
+	}
+	return ""
+}
+
+func (s *TelegramService) clientSubText(caller *tgCaller) string {
+	subs, errText := s.boundSubscriptions(caller)
+	if subs == nil {
+		return errText
+	}
+	subscriptionService := SubscriptionService{}
+	texts := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		url, err := subscriptionService.GetSubURL(sub.Traffic.SubToken)
+		if err != nil {
+			return locale.Bot("tgbot.client.subFail")
+		}
+		if url == "" {
+			return locale.Bot("tgbot.client.subDisabled")
+		}
+		texts = append(texts, sub.Traffic.Email+"\n"+url)
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// CheckClientWarnings 给绑定了账号的用户发送流量和到期提醒，每种提醒在条件解除前只发送一次
+func (s *TelegramService) CheckClientWarnings() {
+	enable, err := s.settingService.GetTgClientBotEnable()
+	if err != nil || !enable || getBot() == nil {
+		return
+	}
+	warnPercent, err := s.settingService.GetTgClientWarnPercent()
+	if err != nil {
+		return
+	}
+	warnDays, err := s.settingService.GetTgClientWarnDays()
+	if err != nil {
+		return
+	}
+
+	db := database.GetDB()
+	bindings := make([]*model.TgClientBinding, 0)
+	if err := db.Model(model.TgClientBinding{}).Find(&bindings).Error; err != nil {
+		logger.Warning("get telegram client bindings failed:", err)
+		return
+	}
+	subscriptionService := SubscriptionService{}
+	for _, binding := range bindings {
+		sub, err := subscriptionService.GetSubscriptionByEmail(binding.Email)
+		if database.IsNotFound(err) {
+			db.Delete(binding)
+			continue
+		} else if err != nil {
+			continue
+		}
+		used, total, expiryTime := clientUsage(sub.Traffic, sub.Inbound)
+
+		quotaReached := warnPercent > 0 && total > 0 && used*100 >= total*int64(warnPercent)
+		expiryNear := warnDays > 0 && expiryTime > 0 &&
+			expiryTime-time.Now().UnixMilli() <= int64(warnDays)*86400*1000
+		// 每种提醒发送成功后立即保存，避免另一种提醒发送失败时重复发送
+		if quotaReached && !binding.QuotaWarned {
+			msg := locale.Bot("tgbot.client.quotaWarn", "Email", binding.Email, "Used", common.FormatTraffic(used), "Total", common.FormatTraffic(total)) + "\n\n"
+			if s.SendMsgToChat(binding.ChatId, msg+clientUsageDetail(sub.Traffic, sub.Inbound)) == nil {
+				db.Model(binding).Update("quota_warned", true)
+			}
+		} else if !quotaReached && binding.QuotaWarned {
+			db.Model(binding).Update("quota_warned", false)
+		}
+		if expiryNear && !binding.ExpiryWarned {
+			msg := locale.Bot("tgbot.client.expiryWarn", "Email", binding.Email, "Expiry", formatExpiry(expiryTime)) + "\n\n"
+			if s.SendMsgToChat(binding.ChatId, msg+clientUsageDetail(sub.Traffic, sub.Inbound)) == nil {
+				db.Model(binding).Update("expiry_warned", true)
+			}
+		} else if !expiryNear && binding.ExpiryWarned {
+			db.Model(binding).Update("expiry_warned", false)
+		}
+	}
+}
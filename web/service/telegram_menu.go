@@ -0,0 +1,580 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/random"
+	"x-ui/web/entity"
+	"x-ui/web/locale"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xtls/xray-core/common/uuid"
+)
+
+// 入站列表每页显示的数量
+const tgInboundPageSize = 8
+
+const tgConversationTimeout = time.Minute * 10
+
+// tgConversation 记录引导式创建的进度，step 为下一条文本消息要填写的内容
+type tgConversation struct {
+	step      string
+	inboundId int
+	protocol  string
+	port      int
+	expire    time.Time
+}
+
+// 按 chat id 和 user id 记录进行中的对话，与 tgPendingConfirms 共用 tgConfirmLock
+var tgConversations = map[string]*tgConversation{}
+
+// 按钮回调所需的权限，回调数据格式为 "动作:参数"
+var tgCallbackPermissions = map[string]string{
+	"list":   "inbounds",
+	"ib":     "inbounds",
+	"ln":     "inbounds",
+	"on":     "enable",
+	"off":    "disable",
+	"rs":     "clear",
+	"rs!":    "clear",
+	"ex":     "extend",
+	"ac":     "create",
+	"ni":     "create",
+	"np":     "create",
+	"cancel": "inbounds",
+}
+
+func tgButton(text string, data string) tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardButtonData(text, data)
+}
+
+func (s *TelegramService) send(c tgbotapi.Chattable) {
+	bot := getBot()
+	if bot == nil {
+		return
+	}
+	if _, err := bot.Send(c); err != nil {
+		logger.Warning("telegram send message failed:", err)
+	}
+}
+
+func (s *TelegramService) inboundListMarkup(page int, admins entity.TgBotAdmins, caller *tgCaller) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return "", nil, err
+	}
+	pages := (len(inbounds) + tgInboundPageSize - 1) / tgInboundPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for i := page * tgInboundPageSize; i < len(inbounds) && i < (page+1)*tgInboundPageSize; i++ {
+		inbound := inbounds[i]
+		state := "✅"
+		if !inbound.Enable {
+			state = "❌"
+		}
+		text := fmt.Sprintf("%s %s :%d", state, inbound.Remark, inbound.Port)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgButton(text, fmt.Sprintf("ib:%d:%d", inbound.Id, page))))
+	}
+	nav := make([]tgbotapi.InlineKeyboardButton, 0)
+	if page > 0 {
+		nav = append(nav, tgButton(locale.Bot("tgbot.menu.prevPage"), fmt.Sprintf("list:%d", page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgButton(locale.Bot("tgbot.menu.nextPage"), fmt.Sprintf("list:%d", page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	if s.isAllowed(admins, caller, "create") {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgButton(locale.Bot("tgbot.menu.newInbound"), "ni")))
+	}
+	text := locale.Bot("tgbot.menu.listTitle", "Page", page+1, "Pages", pages, "Count", len(inbounds))
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return text, &markup, nil
+}
+
+func (s *TelegramService) sendInboundList(chatId int64, page int, admins entity.TgBotAdmins, caller *tgCaller) {
+	text, markup, err := s.inboundListMarkup(page, admins, caller)
+	if err != nil {
+		s.send(tgbotapi.NewMessage(chatId, locale.Bot("tgbot.menu.listFail", "Error", err)))
+		return
+	}
+	msg := tgbotapi.NewMessage(chatId, text)
+	msg.ReplyMarkup = markup
+	s.send(msg)
+}
+
+func formatExpiry(expiryTime int64) string {
+	if expiryTime == 0 {
+		return locale.Bot("report.unlimitedExpiry")
+	}
+	return time.Unix(expiryTime/1000, 0).Format("2006-01-02 15:04:05")
+}
+
+func (s *TelegramService) inboundDetail(inbound *model.Inbound) string {
+	text := locale.Bot("tgbot.menu.remark", "Remark", inbound.Remark) + "\n"
+	text += locale.Bot("tgbot.menu.protocol", "Protocol", inbound.Protocol) + "\n"
+	text += locale.Bot("tgbot.menu.port", "Port", inbound.Port) + "\n"
+	if inbound.Enable {
+		text += locale.Bot("tgbot.menu.enabled") + "\n"
+	} else {
+		text += locale.Bot("tgbot.menu.disabled") + "\n"
+	}
+	text += locale.Bot("tgbot.menu.traffic", "Up", common.FormatTraffic(inbound.Up), "Down", common.FormatTraffic(inbound.Down)) + "\n"
+	if inbound.Total > 0 {
+		text += locale.Bot("tgbot.menu.usage", "Used", common.FormatTraffic(inbound.QuotaUsed()), "Total", common.FormatTraffic(inbound.Total)) + "\n"
+	} else {
+		text += locale.Bot("tgbot.menu.unlimitedTotal") + "\n"
+	}
+	text += locale.Bot("tgbot.menu.expiry", "Expiry", formatExpiry(inbound.ExpiryTime)) + "\n"
+	traffics, err := s.inboundService.GetClientTraffics(inbound.Id)
+	if err == nil && len(traffics) > 0 {
+		text += "\n" + locale.Bot("tgbot.menu.clients") + "\n"
+		for _, traffic := range traffics {
+			text += fmt.Sprintf("%s  %s", traffic.Email, common.FormatTraffic(traffic.Up+traffic.Down))
+			if traffic.Total > 0 {
+				text += " / " + common.FormatTraffic(traffic.Total)
+			}
+			text += "\n"
+		}
+	}
+	return text
+}
+
+func (s *TelegramService) inboundDetailMarkup(inbound *model.Inbound, page int, admins entity.TgBotAdmins, caller *tgCaller) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	row := make([]tgbotapi.InlineKeyboardButton, 0)
+	if inbound.Enable && s.isAllowed(admins, caller, "disable") {
+		row = append(row, tgButton(locale.Bot("tgbot.menu.disable"), fmt.Sprintf("off:%d:%d", inbound.Id, page)))
+	} else if !inbound.Enable && s.isAllowed(admins, caller, "enable") {
+		row = append(row, tgButton(locale.Bot("tgbot.menu.enable"), fmt.Sprintf("on:%d:%d", inbound.Id, page)))
+	}
+	if s.isAllowed(admins, caller, "clear") {
+		row = append(row, tgButton(locale.Bot("tgbot.menu.reset"), fmt.Sprintf("rs:%d:%d", inbound.Id, page)))
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	if s.isAllowed(admins, caller, "extend") {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgButton(locale.Bot("tgbot.menu.extend", "Days", 7), fmt.Sprintf("ex:%d:%d:7", inbound.Id, page)),
+			tgButton(locale.Bot("tgbot.menu.extend", "Days", 30), fmt.Sprintf("ex:%d:%d:30", inbound.Id, page)),
+		))
+	}
+	row = []tgbotapi.InlineKeyboardButton{tgButton(locale.Bot("tgbot.menu.links"), fmt.Sprintf("ln:%d:%d", inbound.Id, page))}
+	if s.isAllowed(admins, caller, "create") && inboundHasClients(inbound) {
+		row = append(row, tgButton(locale.Bot("tgbot.menu.addClient"), fmt.Sprintf("ac:%d:%d", inbound.Id, page)))
+	}
+	rows = append(rows, row)
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgButton(locale.Bot("tgbot.menu.back"), fmt.Sprintf("list:%d", page))))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func inboundHasClients(inbound *model.Inbound) bool {
+	switch inbound.Protocol {
+	case model.VMess, model.VLESS, model.Trojan:
+		return true
+	}
+	return false
+}
+
+// editMessage 用新的内容和按钮替换按钮所在的消息
+func (s *TelegramService) editMessage(query *tgbotapi.CallbackQuery, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	if query.Message == nil {
+		return
+	}
+	var edit tgbotapi.EditMessageTextConfig
+	if markup != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *markup)
+	} else {
+		edit = tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	}
+	s.send(edit)
+}
+
+func (s *TelegramService) showInbound(query *tgbotapi.CallbackQuery, id int, page int, admins entity.TgBotAdmins, caller *tgCaller) {
+	inbound, err := s.inboundService.GetInbound(id)
+	if err != nil {
+		s.editMessage(query, locale.Bot("tgbot.menu.notFound"), nil)
+		return
+	}
+	markup := s.inboundDetailMarkup(inbound, page, admins, caller)
+	s.editMessage(query, s.inboundDetail(inbound), &markup)
+}
+
+// sendShareLinks 以二维码图片加链接文字的形式发送分享链接，email 为空时发送入站下所有用户的
+func (s *TelegramService) sendShareLinks(chatId int64, inbound *model.Inbound, email string) {
+	links, err := s.inboundService.GetShareLinks(inbound)
+	if err != nil {
+		s.send(tgbotapi.NewMessage(chatId, locale.Bot("tgbot.menu.linkFail", "Error", err)))
+		return
+	}
+	if len(links) == 0 {
+		s.send(tgbotapi.NewMessage(chatId, locale.Bot("tgbot.menu.noLinks")))
+		return
+	}
+	for _, link := range links {
+		if email != "" && link.Email != email {
+			continue
+		}
+		png, err := GenQrCode(link.Link, 512)
+		if err != nil {
+			s.send(tgbotapi.NewMessage(chatId, link.Link))
+			continue
+		}
+		photo := tgbotapi.NewPhoto(chatId, tgbotapi.FileBytes{Name: "qrcode.png", Bytes: png})
+		caption := link.Link
+		if link.Email != "" {
+			caption = link.Email + "\n" + caption
+		}
+		photo.Caption = caption
+		s.send(photo)
+	}
+}
+
+func (s *TelegramService) handleCallback(query *tgbotapi.CallbackQuery) {
+	caller := callerOfCallback(query)
+	answer := ""
+	defer func() {
+		bot := getBot()
+		if bot == nil {
+			return
+		}
+		if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+			logger.Debug("telegram answer callback failed:", err)
+		}
+	}()
+
+	admins, err := s.settingService.GetTgBotAdmins()
+	if err != nil {
+		answer = locale.Bot("tgbot.adminsInvalid")
+		return
+	}
+	parts := strings.Split(query.Data, ":")
+	action := parts[0]
+	permission, ok := tgCallbackPermissions[action]
+	if !ok {
+		return
+	}
+	if !s.isAdmin(admins, caller) || !s.isAllowed(admins, caller, permission) {
+		s.reportDenied(caller, "button "+query.Data)
+		answer = locale.Bot("tgbot.menu.denied")
+		return
+	}
+	args := make([]int, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			args = append(args, 0)
+			continue
+		}
+		args = append(args, n)
+	}
+	arg := func(i int) int {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+
+	switch action {
+	case "list":
+		text, markup, err := s.inboundListMarkup(arg(0), admins, caller)
+		if err != nil {
+			answer = err.Error()
+			return
+		}
+		s.editMessage(query, text, markup)
+	case "ib":
+		s.showInbound(query, arg(0), arg(1), admins, caller)
+	case "ln":
+		inbound, err := s.inboundService.GetInbound(arg(0))
+		if err != nil {
+			answer = locale.Bot("tgbot.menu.notFound")
+			return
+		}
+		s.sendShareLinks(caller.chatId, inbound, "")
+	case "on", "off":
+		inbound, err := s.inboundService.GetInbound(arg(0))
+		if err != nil {
+			answer = locale.Bot("tgbot.menu.notFound")
+			return
+		}
+		if action == "on" {
+			err = s.inboundService.EnableInboundByPort(inbound.Port)
+		} else {
+			err = s.inboundService.DisableInboundByPort(inbound.Port)
+		}
+		if err != nil {
+			answer = err.Error()
+			return
+		}
+		s.xrayService.SetToNeedRestart()
+		s.showInbound(query, inbound.Id, arg(1), admins, caller)
+	case "rs":
+		markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgButton(locale.Bot("tgbot.menu.confirmReset"), fmt.Sprintf("rs!:%d:%d", arg(0), arg(1))),
+			tgButton(locale.Bot("tgbot.menu.cancel"), fmt.Sprintf("ib:%d:%d", arg(0), arg(1))),
+		))
+		s.editMessage(query, locale.Bot("tgbot.menu.resetPrompt"), &markup)
+	case "rs!":
+		inbound, err := s.inboundService.GetInbound(arg(0))
+		if err != nil {
+			answer = locale.Bot("tgbot.menu.notFound")
+			return
+		}
+		err = s.inboundService.ClearTrafficByPort(inbound.Port)
+		if err != nil {
+			answer = err.Error()
+			return
+		}
+		answer = locale.Bot("tgbot.menu.resetDone")
+		s.showInbound(query, inbound.Id, arg(1), admins, caller)
+	case "ex":
+		inbound, err := s.inboundService.GetInbound(arg(0))
+		if err != nil {
+			answer = locale.Bot("tgbot.menu.notFound")
+			return
+		}
+		if inbound.ExpiryTime == 0 {
+			answer = locale.Bot("tgbot.menu.noExpiry")
+			return
+		}
+		base := inbound.ExpiryTime
+		now := time.Now().Unix() * 1000
+		if base < now {
+			base = now
+		}
+		inbound.ExpiryTime = base + int64(arg(2))*24*3600*1000
+		err = s.inboundService.UpdateInbound(inbound)
+		if err != nil {
+			answer = err.Error()
+			return
+		}
+		s.xrayService.SetToNeedRestart()
+		answer = locale.Bot("tgbot.menu.extended", "Expiry", formatExpiry(inbound.ExpiryTime))
+		s.showInbound(query, inbound.Id, arg(1), admins, caller)
+	case "ac":
+		s.startConversation(caller, &tgConversation{step: "email", inboundId: arg(0)})
+		s.send(tgbotapi.NewMessage(caller.chatId, locale.Bot("tgbot.menu.askEmail")))
+	case "ni":
+		markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgButton("vmess", "np:vmess"),
+			tgButton("vless", "np:vless"),
+			tgButton("trojan", "np:trojan"),
+			tgButton("shadowsocks", "np:shadowsocks"),
+		), tgbotapi.NewInlineKeyboardRow(tgButton(locale.Bot("tgbot.menu.cancel"), "cancel")))
+		s.editMessage(query, locale.Bot("tgbot.menu.askProtocol"), &markup)
+	case "np":
+		if len(parts) < 2 {
+			return
+		}
+		s.startConversation(caller, &tgConversation{step: "port", protocol: parts[1]})
+		s.editMessage(query, locale.Bot("tgbot.menu.askPort", "Protocol", parts[1]), nil)
+	case "cancel":
+		tgConfirmLock.Lock()
+		delete(tgConversations, caller.key())
+		tgConfirmLock.Unlock()
+		s.editMessage(query, locale.Bot("tgbot.menu.canceled"), nil)
+	}
+}
+
+func (s *TelegramService) startConversation(caller *tgCaller, conversation *tgConversation) {
+	conversation.expire = time.Now().Add(tgConversationTimeout)
+	tgConfirmLock.Lock()
+	tgConversations[caller.key()] = conversation
+	tgConfirmLock.Unlock()
+}
+
+// handleConversation 处理引导式创建中用户输入的文本，没有进行中的对话时交给用户机器人处理
+func (s *TelegramService) handleConversation(message *tgbotapi.Message) string {
+	caller := callerOfMessage(message)
+	tgConfirmLock.Lock()
+	conversation := tgConversations[caller.key()]
+	if conversation != nil && time.Now().After(conversation.expire) {
+		delete(tgConversations, caller.key())
+		conversation = nil
+	}
+	tgConfirmLock.Unlock()
+	if conversation == nil {
+		return s.handleClientText(message)
+	}
+
+	admins, err := s.settingService.GetTgBotAdmins()
+	if err != nil || !s.isAllowed(admins, caller, "create") {
+		s.reportDenied(caller, message.Text)
+		return locale.Bot("tgbot.unauthorized")
+	}
+
+	text := strings.TrimSpace(message.Text)
+	switch conversation.step {
+	case "port":
+		port, err := strconv.Atoi(text)
+		if err != nil || port < 0 || port > 65535 {
+			return locale.Bot("tgbot.menu.invalidPort")
+		}
+		if port == 0 {
+			// 与面板新建入站相同，按设置的端口范围和排除端口分配，并检查本机是否可用
+			port, err = s.inboundService.AllocatePort("")
+			if err != nil {
+				return locale.Bot("tgbot.menu.createFail", "Error", err)
+			}
+		}
+		exist, err := s.inboundService.checkPortExist(port, 0)
+		if err != nil {
+			return err.Error()
+		}
+		if exist {
+			return locale.Bot("tgbot.menu.portUsed", "Port", port)
+		}
+		conversation.port = port
+		conversation.step = "remark"
+		return locale.Bot("tgbot.menu.askRemark")
+	case "remark":
+		tgConfirmLock.Lock()
+		delete(tgConversations, caller.key())
+		tgConfirmLock.Unlock()
+		inbound, err := s.createInbound(conversation.protocol, conversation.port, text)
+		if err != nil {
+			return locale.Bot("tgbot.menu.createFail", "Error", err)
+		}
+		s.send(tgbotapi.NewMessage(caller.chatId, locale.Bot("tgbot.menu.createSuccess")+"\n\n"+s.inboundDetail(inbound)))
+		s.sendShareLinks(caller.chatId, inbound, "")
+		return ""
+	case "email":
+		if text == "" {
+			return locale.Bot("tgbot.menu.emptyEmail")
+		}
+		tgConfirmLock.Lock()
+		delete(tgConversations, caller.key())
+		tgConfirmLock.Unlock()
+		inbound, err := s.addClient(conversation.inboundId, text)
+		if err != nil {
+			return locale.Bot("tgbot.menu.addClientFail", "Error", err)
+		}
+		s.send(tgbotapi.NewMessage(caller.chatId, locale.Bot("tgbot.menu.addClientSuccess")))
+		s.sendShareLinks(caller.chatId, inbound, text)
+		return ""
+	}
+	return ""
+}
+
+func newClientSettings(protocol model.Protocol, email string) map[string]interface{} {
+	client := map[string]interface{}{
+		"email":      email,
+		"totalGB":    0,
+		"expiryTime": 0,
+	}
+	switch protocol {
+	case model.VMess:
+		id := uuid.New()
+		client["id"] = id.String()
+		client["alterId"] = 0
+	case model.VLESS:
+		id := uuid.New()
+		client["id"] = id.String()
+		client["flow"] = ""
+	case model.Trojan:
+		client["password"] = random.Seq(10)
+		client["flow"] = ""
+	}
+	return client
+}
+
+func (s *TelegramService) createInbound(protocol string, port int, remark string) (*model.Inbound, error) {
+	var settings map[string]interface{}
+	p := model.Protocol(protocol)
+	switch p {
+	case model.VMess:
+		settings = map[string]interface{}{
+			"clients":                   []interface{}{newClientSettings(p, random.Seq(8))},
+			"disableInsecureEncryption": false,
+		}
+	case model.VLESS:
+		settings = map[string]interface{}{
+			"clients":    []interface{}{newClientSettings(p, random.Seq(8))},
+			"decryption": "none",
+			"fallbacks":  []interface{}{},
+		}
+	case model.Trojan:
+		settings = map[string]interface{}{
+			"clients":   []interface{}{newClientSettings(p, random.Seq(8))},
+			"fallbacks": []interface{}{},
+		}
+	case model.Shadowsocks:
+		settings = map[string]interface{}{
+			"method":   "chacha20-poly1305",
+			"password": random.Seq(10),
+			"network":  "tcp,udp",
+		}
+	default:
+		return nil, common.NewError("unsupported protocol:", protocol)
+	}
+	settingsJson, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userService.GetFirstUser()
+	if err != nil {
+		return nil, err
+	}
+	inbound := &model.Inbound{
+		UserId:         user.Id,
+		Remark:         remark,
+		Enable:         true,
+		Port:           port,
+		Protocol:       p,
+		Settings:       string(settingsJson),
+		StreamSettings: `{"network": "tcp", "security": "none", "tcpSettings": {"header": {"type": "none"}}}`,
+		Sniffing:       `{"enabled": true, "destOverride": ["http", "tls"]}`,
+		Tag:            fmt.Sprintf("inbound-%v", port),
+	}
+	err = s.inboundService.AddInbound(inbound)
+	if err != nil {
+		return nil, err
+	}
+	s.xrayService.SetToNeedRestart()
+	return inbound, nil
+}
+
+func (s *TelegramService) addClient(inboundId int, email string) (*model.Inbound, error) {
+	inbound, err := s.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return nil, err
+	}
+	if !inboundHasClients(inbound) {
+		return nil, common.NewError("inbound protocol has no clients:", inbound.Protocol)
+	}
+	settings := map[string]interface{}{}
+	err = json.Unmarshal([]byte(inbound.Settings), &settings)
+	if err != nil {
+		return nil, err
+	}
+	clients, _ := settings["clients"].([]interface{})
+	settings["clients"] = append(clients, newClientSettings(inbound.Protocol, email))
+	settingsJson, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	inbound.Settings = string(settingsJson)
+	err = s.inboundService.UpdateInbound(inbound)
+	if err != nil {
+		return nil, err
+	}
+	s.xrayService.SetToNeedRestart()
+	return inbound, nil
+}
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/web/locale"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+func checkResetPolicy(policy string, day int) error {
+	switch policy {
+	case "", model.ResetNever, model.ResetDaily:
+	case model.ResetWeekly:
+		if day < 0 || day > 6 {
+			return locale.NewError("reset.invalidWeekday", "Day", day)
+		}
+	case model.ResetMonthly:
+		if day < 1 || day > 31 {
+			return locale.NewError("reset.invalidMonthDay", "Day", day)
+		}
+	case model.ResetInterval:
+		if day < 1 {
+			return locale.NewError("reset.invalidInterval", "Day", day)
+		}
+	default:
+		return locale.NewError("reset.unsupportedPolicy", "Policy", policy)
+	}
+	return nil
+}
+
+// checkResetPolicies 检查入站和其中每个用户的重置周期
+func checkResetPolicies(inbound *model.Inbound) error {
+	err := checkResetPolicy(inbound.ResetPolicy, inbound.ResetDay)
+	if err != nil {
+		return err
+	}
+	clients, err := inbound.GetClients()
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		err = checkResetPolicy(client.ResetPolicy, client.ResetDay)
+		if err != nil {
+			return locale.NewError("inbound.clientInvalid", "Email", client.Email, "Error", err)
+		}
+	}
+	return nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// monthlyResetTime 返回 t 所在月份的重置时间，当月没有 day 号时取最后一天
+func monthlyResetTime(t time.Time, day int) time.Time {
+	lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, t.Location())
+}
+
+// resetDue 判断自上次重置 last 以来是否到了重置时间，返回是否需要重置以及应记录的重置时间。
+// 按天数间隔重置时记录的是应当重置的时间点而不是当前时间，避免任务的执行间隔让周期逐渐推后
+func resetDue(policy string, day int, last time.Time, now time.Time) (bool, time.Time) {
+	var boundary time.Time
+	switch policy {
+	case model.ResetDaily:
+		boundary = startOfDay(now)
+	case model.ResetWeekly:
+		offset := (int(now.Weekday()) - day + 7) % 7
+		boundary = startOfDay(now).AddDate(0, 0, -offset)
+	case model.ResetMonthly:
+		boundary = monthlyResetTime(now, day)
+		if boundary.After(now) {
+			firstDay := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+			boundary = monthlyResetTime(firstDay.AddDate(0, -1, 0), day)
+		}
+	case model.ResetInterval:
+		if day < 1 {
+			return false, last
+		}
+		period := time.Duration(day) * time.Hour * 24
+		elapsed := now.Sub(last)
+		if elapsed < period {
+			return false, last
+		}
+		return true, last.Add(elapsed / period * period)
+	default:
+		return false, last
+	}
+	return boundary.After(last), now
+}
+
+// lastResetTime 返回上次重置的时间，从未重置过时以创建时间为起点
+func lastResetTime(lastResetAt int64, createdAt int64, loc *time.Location) time.Time {
+	if lastResetAt > 0 {
+		return time.UnixMilli(lastResetAt).In(loc)
+	}
+	return time.UnixMilli(createdAt).In(loc)
+}
+
+func recordTrafficReset(tx *gorm.DB, inboundId int, email string, up int64, down int64, total int64, trigger string) error {
+	if up == 0 && down == 0 {
+		return nil
+	}
+	return tx.Create(&model.TrafficReset{
+		InboundId: inboundId,
+		Email:     email,
+		Up:        up,
+		Down:      down,
+		Total:     total,
+		Trigger:   trigger,
+		ResetAt:   time.Now().UnixMilli(),
+	}).Error
+}
+
+// resetInheritingClients 清零入站中未单独设置重置周期的用户流量，返回重置前的用户流量
+func resetInheritingClients(tx *gorm.DB, inboundId int, trigger string) ([]*xray.ClientTraffic, error) {
+	traffics := make([]*xray.ClientTraffic, 0)
+	err := tx.Model(xray.ClientTraffic{}).
+		Where("inbound_id = ? and (reset_policy is null or reset_policy in ?)", inboundId, []string{"", model.ResetNever}).
+		Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, traffic := range traffics {
+		err = recordTrafficReset(tx, inboundId, traffic.Email, traffic.Up, traffic.Down, traffic.Total, trigger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Model(xray.ClientTraffic{}).
+		Where("inbound_id = ? and (reset_policy is null or reset_policy in ?)", inboundId, []string{"", model.ResetNever}).
+		Updates(map[string]interface{}{"up": 0, "down": 0}).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffics, nil
+}
+
+// reenableQuotaClients 重新启用入站中随入站重置、因流量超出被禁用且未到期的用户，返回启用的用户数
+func reenableQuotaClients(tx *gorm.DB, inboundId int, now int64) (int64, error) {
+	result := tx.Model(xray.ClientTraffic{}).
+		Where("inbound_id = ? and (reset_policy is null or reset_policy in ?)", inboundId, []string{"", model.ResetNever}).
+		Where("enable = ? and disable_reason = ?", false, model.DisableReasonQuota).
+		Where("expiry_time <= 0 or expiry_time > ?", now).
+		Updates(map[string]interface{}{"enable": true, "disable_reason": ""})
+	return result.RowsAffected, result.Error
+}
+
+func (s *InboundService) GetTrafficResets(inboundId int) ([]*model.TrafficReset, error) {
+	db := database.GetDB()
+	resets := make([]*model.TrafficReset, 0)
+	err := db.Model(model.TrafficReset{}).Where("inbound_id = ?", inboundId).Order("id desc").Find(&resets).Error
+	if err != nil {
+		return nil, err
+	}
+	return resets, nil
+}
+
+// ResetScheduledTraffic 重置到期的入站和用户流量，返回重新启用的入站和用户数量，大于 0 时需要重启 xray
+func (s *InboundService) ResetScheduledTraffic() (int, error) {
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().In(loc)
+
+	db := database.GetDB()
+	inbounds := make([]*model.Inbound, 0)
+	err = db.Model(model.Inbound{}).Where("reset_policy not in ?", []string{"", model.ResetNever}).Find(&inbounds).Error
+	if err != nil {
+		return 0, err
+	}
+	enabled := 0
+	for _, inbound := range inbounds {
+		last := lastResetTime(inbound.LastResetAt, inbound.CreatedAt, loc)
+		due, resetAt := resetDue(inbound.ResetPolicy, inbound.ResetDay, last, now)
+		if !due {
+			continue
+		}
+		reenabled, err := s.resetInboundBySchedule(inbound, resetAt, now)
+		if err != nil {
+			logger.Warning("reset inbound traffic failed:", inbound.Id, err)
+			continue
+		}
+		if reenabled {
+			enabled++
+		}
+	}
+
+	traffics := make([]*xray.ClientTraffic, 0)
+	err = db.Model(xray.ClientTraffic{}).Where("reset_policy not in ?", []string{"", model.ResetNever}).Find(&traffics).Error
+	if err != nil {
+		return enabled, err
+	}
+	for _, traffic := range traffics {
+		last := lastResetTime(traffic.LastResetAt, traffic.CreatedAt, loc)
+		due, resetAt := resetDue(traffic.ResetPolicy, traffic.ResetDay, last, now)
+		if !due {
+			continue
+		}
+		reenabled, err := s.resetClientBySchedule(traffic, resetAt, now)
+		if err != nil {
+			logger.Warning("reset client traffic failed:", traffic.Email, err)
+			continue
+		}
+		if reenabled {
+			enabled++
+		}
+	}
+	return enabled, nil
+}
+
+// resetInboundBySchedule 重置入站和其中未单独设置重置周期的用户，因流量超出被禁用的入站和用户重新启用，
+// 返回是否有入站或用户被重新启用
+func (s *InboundService) resetInboundBySchedule(inbound *model.Inbound, resetAt time.Time, now time.Time) (bool, error) {
+	reenable := !inbound.Enable && inbound.DisableReason == model.DisableReasonQuota &&
+		(inbound.ExpiryTime == 0 || inbound.ExpiryTime > now.UnixMilli())
+	var traffics []*xray.ClientTraffic
+	var clients int64
+
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := recordTrafficReset(tx, inbound.Id, "", inbound.Up, inbound.Down, inbound.Total, model.ResetTriggerSchedule)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{
+			"up":            0,
+			"down":          0,
+			"last_reset_at": resetAt.UnixMilli(),
+		}
+		if reenable {
+			updates["enable"] = true
+			updates["disable_reason"] = ""
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+
+		traffics, err = resetInheritingClients(tx, inbound.Id, model.ResetTriggerSchedule)
+		if err != nil {
+			return err
+		}
+		clients, err = reenableQuotaClients(tx, inbound.Id, now.UnixMilli())
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	logger.Infof("inbound %v traffic reset by schedule %v", inbound.Id, inbound.ResetPolicy)
+	if clients > 0 {
+		logger.Infof("inbound %v reenabled %v clients disabled by quota", inbound.Id, clients)
+	}
+
+	data := inboundEventData(inbound)
+	data["trigger"] = model.ResetTriggerSchedule
+	s.webhookService.Emit(WebhookInboundReset, data)
+	for _, traffic := range traffics {
+		s.emitClientReset(traffic, model.ResetTriggerSchedule)
+	}
+	if reenable {
+		inbound.Enable = true
+		inbound.Up = 0
+		inbound.Down = 0
+		s.webhookService.Emit(WebhookInboundEnabled, inboundEventData(inbound))
+	}
+	return reenable || clients > 0, nil
+}
+
+// resetClientBySchedule 重置单独设置了重置周期的用户，因流量超出被禁用的用户重新启用，返回是否重新启用
+func (s *InboundService) resetClientBySchedule(traffic *xray.ClientTraffic, resetAt time.Time, now time.Time) (bool, error) {
+	updates := map[string]interface{}{
+		"up":            0,
+		"down":          0,
+		"last_reset_at": resetAt.UnixMilli(),
+	}
+	reenable := !traffic.Enable && traffic.DisableReason == model.DisableReasonQuota &&
+		(traffic.ExpiryTime <= 0 || traffic.ExpiryTime > now.UnixMilli())
+	if reenable {
+		updates["enable"] = true
+		updates["disable_reason"] = ""
+	}
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := recordTrafficReset(tx, traffic.InboundId, traffic.Email, traffic.Up, traffic.Down, traffic.Total, model.ResetTriggerSchedule)
+		if err != nil {
+			return err
+		}
+		return tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Updates(updates).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	logger.Infof("client %v traffic reset by schedule %v", traffic.Email, traffic.ResetPolicy)
+	s.emitClientReset(traffic, model.ResetTriggerSchedule)
+	return reenable, nil
+}
+
+func (s *InboundService) emitClientReset(traffic *xray.ClientTraffic, trigger string) {
+	s.webhookService.Emit(WebhookClientReset, map[string]interface{}{
+		"inboundId": traffic.InboundId,
+		"email":     traffic.Email,
+		"up":        traffic.Up,
+		"down":      traffic.Down,
+		"trigger":   trigger,
+	})
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+	"x-ui/database/model"
+)
+
+func TestMonthlyResetTime(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		day  int
+		want time.Time
+	}{
+		{time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), 15, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 2, 10, 12, 0, 0, 0, time.UTC), 31, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 2, 10, 12, 0, 0, 0, time.UTC), 30, time.Date(2023, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 4, 30, 23, 0, 0, 0, time.UTC), 31, time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got := monthlyResetTime(test.t, test.day)
+		if !got.Equal(test.want) {
+			t.Errorf("monthlyResetTime(%v, %d) = %v, want %v", test.t, test.day, got, test.want)
+		}
+	}
+}
+
+func TestResetDue(t *testing.T) {
+	date := func(month time.Month, day int, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name    string
+		policy  string
+		day     int
+		last    time.Time
+		now     time.Time
+		due     bool
+		resetAt time.Time
+	}{
+		{"never", model.ResetNever, 0, date(1, 1, 0), date(6, 1, 0), false, date(1, 1, 0)},
+		{"daily same day", model.ResetDaily, 0, date(3, 10, 1), date(3, 10, 23), false, date(3, 10, 1)},
+		{"daily next day", model.ResetDaily, 0, date(3, 10, 23), date(3, 11, 0), true, date(3, 11, 0)},
+		// 2024-03-10 是周日
+		{"weekly before weekday", model.ResetWeekly, 1, date(3, 4, 12), date(3, 10, 12), false, date(3, 4, 12)},
+		{"weekly on weekday", model.ResetWeekly, 1, date(3, 4, 12), date(3, 11, 1), true, date(3, 11, 1)},
+		{"weekly sunday", model.ResetWeekly, 0, date(3, 9, 12), date(3, 10, 0), true, date(3, 10, 0)},
+		{"monthly before day", model.ResetMonthly, 15, date(2, 15, 1), date(3, 14, 23), false, date(2, 15, 1)},
+		{"monthly on day", model.ResetMonthly, 15, date(2, 15, 1), date(3, 15, 1), true, date(3, 15, 1)},
+		{"monthly clamped to month end", model.ResetMonthly, 31, date(1, 31, 1), date(2, 29, 1), true, date(2, 29, 1)},
+		{"monthly clamped already reset", model.ResetMonthly, 31, date(2, 29, 1), date(3, 30, 1), false, date(2, 29, 1)},
+		{"interval not elapsed", model.ResetInterval, 3, date(3, 1, 6), date(3, 4, 5), false, date(3, 1, 6)},
+		{"interval elapsed keeps schedule", model.ResetInterval, 3, date(3, 1, 6), date(3, 4, 7), true, date(3, 4, 6)},
+		{"interval skips missed periods", model.ResetInterval, 3, date(3, 1, 6), date(3, 10, 8), true, date(3, 10, 6)},
+		{"interval invalid", model.ResetInterval, 0, date(3, 1, 6), date(3, 10, 8), false, date(3, 1, 6)},
+	}
+	for _, test := range tests {
+		due, resetAt := resetDue(test.policy, test.day, test.last, test.now)
+		// 不需要重置时返回的时间不会被使用
+		if due != test.due || (due && !resetAt.Equal(test.resetAt)) {
+			t.Errorf("%s: resetDue() = %v, %v, want %v, %v", test.name, due, resetAt, test.due, test.resetAt)
+		}
+	}
+}
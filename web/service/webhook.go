@@ -0,0 +1,320 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/random"
+	"x-ui/web/locale"
+)
+
+type WebhookEvent string
+
+const (
+	WebhookInboundCreated   WebhookEvent = "inbound.created"
+	WebhookInboundUpdated   WebhookEvent = "inbound.updated"
+	WebhookInboundDeleted   WebhookEvent = "inbound.deleted"
+	WebhookInboundEnabled   WebhookEvent = "inbound.enabled"
+	WebhookInboundDisabled  WebhookEvent = "inbound.disabled"
+	WebhookInboundReset     WebhookEvent = "inbound.reset"
+	WebhookInboundOverQuota WebhookEvent = "inbound.over_quota"
+	WebhookClientCreated    WebhookEvent = "client.created"
+	WebhookClientDeleted    WebhookEvent = "client.deleted"
+	WebhookClientReset      WebhookEvent = "client.reset"
+	WebhookXrayCrashed      WebhookEvent = "xray.crashed"
+	WebhookTest             WebhookEvent = "test"
+)
+
+// 第 n 次投递失败后等待多久再重试，用完后标记为失败
+var webhookRetryDelays = []time.Duration{
+	time.Second * 30,
+	time.Minute,
+	time.Minute * 5,
+	time.Minute * 15,
+	time.Hour,
+}
+
+const webhookDeliveryRetention = time.Hour * 24 * 30
+
+var webhookHttpClient = &http.Client{
+	Timeout: time.Second * 15,
+}
+
+// 正在投递的记录，防止首次投递和重试任务同时发送同一条
+var webhookInFlight sync.Map
+
+type WebhookPayload struct {
+	Id    string       `json:"id"`
+	Event WebhookEvent `json:"event"`
+	Time  int64        `json:"time"`
+	Data  interface{}  `json:"data"`
+}
+
+type WebhookService struct {
+}
+
+func (s *WebhookService) GetEndpoints() ([]*model.WebhookEndpoint, error) {
+	db := database.GetDB()
+	endpoints := make([]*model.WebhookEndpoint, 0)
+	err := db.Model(model.WebhookEndpoint{}).Find(&endpoints).Error
+	if err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func (s *WebhookService) checkEndpoint(endpoint *model.WebhookEndpoint) error {
+	if !strings.HasPrefix(endpoint.Url, "http://") && !strings.HasPrefix(endpoint.Url, "https://") {
+		return locale.NewError("webhook.urlScheme")
+	}
+	return nil
+}
+
+func (s *WebhookService) AddEndpoint(endpoint *model.WebhookEndpoint) error {
+	err := s.checkEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Create(endpoint).Error
+}
+
+func (s *WebhookService) UpdateEndpoint(endpoint *model.WebhookEndpoint) error {
+	err := s.checkEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Save(endpoint).Error
+}
+
+func (s *WebhookService) DelEndpoint(id int) error {
+	db := database.GetDB()
+	err := db.Where("endpoint_id = ?", id).Delete(model.WebhookDelivery{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(model.WebhookEndpoint{}, id).Error
+}
+
+func endpointHasEvent(endpoint *model.WebhookEndpoint, event WebhookEvent) bool {
+	if strings.TrimSpace(endpoint.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(endpoint.Events, ",") {
+		if strings.TrimSpace(e) == string(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit 为每个订阅了该事件的端点保存一条投递记录并立即尝试投递，失败的由 WebhookJob 重试
+func (s *WebhookService) Emit(event WebhookEvent, data interface{}) {
+	endpoints, err := s.GetEndpoints()
+	if err != nil {
+		logger.Warning("get webhook endpoints failed:", err)
+		return
+	}
+	payload := &WebhookPayload{
+		Id:    random.Seq(16),
+		Event: event,
+		Time:  time.Now().Unix(),
+		Data:  data,
+	}
+	var body []byte
+	for _, endpoint := range endpoints {
+		if !endpoint.Enable || !endpointHasEvent(endpoint, event) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(payload)
+			if err != nil {
+				logger.Warning("marshal webhook payload failed:", err)
+				return
+			}
+		}
+		s.enqueue(endpoint, payload, body)
+	}
+}
+
+func (s *WebhookService) enqueue(endpoint *model.WebhookEndpoint, payload *WebhookPayload, body []byte) {
+	now := time.Now()
+	delivery := &model.WebhookDelivery{
+		EndpointId:  endpoint.Id,
+		EventId:     payload.Id,
+		Event:       string(payload.Event),
+		Payload:     string(body),
+		Status:      model.WebhookPending,
+		CreatedAt:   now.Unix(),
+		NextRetryAt: now.Add(webhookRetryDelays[0]).Unix(),
+	}
+	db := database.GetDB()
+	err := db.Create(delivery).Error
+	if err != nil {
+		logger.Warning("save webhook delivery failed:", err)
+		return
+	}
+	go s.deliver(delivery.Id)
+}
+
+func (s *WebhookService) post(endpoint *model.WebhookEndpoint, delivery *model.WebhookDelivery) (int, error) {
+	data := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, endpoint.Url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-XUI-Event", delivery.Event)
+	req.Header.Set("X-XUI-Delivery", delivery.EventId)
+	if endpoint.Secret != "" {
+		req.Header.Set("X-XUI-Signature", signPayload(endpoint.Secret, data))
+	}
+	resp, err := webhookHttpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, common.NewErrorf("unexpected status %v: %s", resp.Status, string(body))
+	}
+	return resp.StatusCode, nil
+}
+
+// deliver 投递一次并更新记录状态
+func (s *WebhookService) deliver(id int) {
+	if _, loaded := webhookInFlight.LoadOrStore(id, true); loaded {
+		return
+	}
+	defer webhookInFlight.Delete(id)
+
+	db := database.GetDB()
+	delivery := &model.WebhookDelivery{}
+	err := db.First(delivery, id).Error
+	if err != nil {
+		logger.Warning("get webhook delivery failed:", err)
+		return
+	}
+	if delivery.Status != model.WebhookPending {
+		return
+	}
+	endpoint := &model.WebhookEndpoint{}
+	err = db.First(endpoint, delivery.EndpointId).Error
+	if err != nil {
+		delivery.Status = model.WebhookFailed
+		delivery.LastError = "endpoint not found"
+		db.Save(delivery)
+		return
+	}
+
+	code, err := s.post(endpoint, delivery)
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	if err == nil {
+		delivery.Status = model.WebhookSuccess
+		delivery.LastError = ""
+		delivery.DeliveredAt = now.Unix()
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts > len(webhookRetryDelays) {
+			delivery.Status = model.WebhookFailed
+			logger.Warningf("deliver webhook %s to %s failed, giving up: %v", delivery.Event, endpoint.Name, err)
+		} else {
+			delivery.NextRetryAt = now.Add(webhookRetryDelays[delivery.Attempts-1]).Unix()
+			logger.Debugf("deliver webhook %s to %s failed, retry later: %v", delivery.Event, endpoint.Name, err)
+		}
+	}
+	err = db.Save(delivery).Error
+	if err != nil {
+		logger.Warning("save webhook delivery failed:", err)
+	}
+}
+
+// RetryPending 重试到期的投递，并清理过期的记录
+func (s *WebhookService) RetryPending() {
+	db := database.GetDB()
+	now := time.Now()
+	ids := make([]int, 0)
+	err := db.Model(model.WebhookDelivery{}).
+		Where("status = ? and next_retry_at <= ?", model.WebhookPending, now.Unix()).
+		Pluck("id", &ids).Error
+	if err != nil {
+		logger.Warning("get pending webhook deliveries failed:", err)
+		return
+	}
+	for _, id := range ids {
+		s.deliver(id)
+	}
+	err = db.Where("created_at < ? and status != ?", now.Add(-webhookDeliveryRetention).Unix(), model.WebhookPending).
+		Delete(model.WebhookDelivery{}).Error
+	if err != nil {
+		logger.Warning("prune webhook deliveries failed:", err)
+	}
+}
+
+// Replay 重新投递一条记录，不论之前是否成功，尝试次数从头计算
+func (s *WebhookService) Replay(id int) error {
+	db := database.GetDB()
+	err := db.Model(model.WebhookDelivery{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":        model.WebhookPending,
+		"attempts":      0,
+		"next_retry_at": time.Now().Add(webhookRetryDelays[0]).Unix(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	go s.deliver(id)
+	return nil
+}
+
+// GetDeliveries 按时间倒序返回投递记录，status 为空表示全部
+func (s *WebhookService) GetDeliveries(endpointId int, status string, limit int) ([]*model.WebhookDelivery, error) {
+	db := database.GetDB()
+	deliveries := make([]*model.WebhookDelivery, 0)
+	query := db.Model(model.WebhookDelivery{})
+	if endpointId > 0 {
+		query = query.Where("endpoint_id = ?", endpointId)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// TestEndpoint 同步发送一条测试事件，不记录投递日志
+func (s *WebhookService) TestEndpoint(endpoint *model.WebhookEndpoint) error {
+	err := s.checkEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	payload := &WebhookPayload{
+		Id:    random.Seq(16),
+		Event: WebhookTest,
+		Time:  time.Now().Unix(),
+		Data:  map[string]string{"message": "x-ui webhook test"},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = s.post(endpoint, &model.WebhookDelivery{
+		EventId: payload.Id,
+		Event:   string(payload.Event),
+		Payload: string(body),
+	})
+	return err
+}
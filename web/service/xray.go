@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"sync"
+	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/util/json_util"
 	"x-ui/xray"
 
 	"go.uber.org/atomic"
@@ -13,11 +15,15 @@ import (
 var p *xray.Process
 var lock sync.Mutex
 var isNeedXrayRestart atomic.Bool
+var xrayRestartCount atomic.Int64
+var isXrayStopped atomic.Bool
 var result string
 
 type XrayService struct {
 	inboundService InboundService
 	settingService SettingService
+	webhookService WebhookService
+	decoyService   DecoyService
 }
 
 func (s *XrayService) IsXrayRunning() bool {
@@ -73,18 +79,75 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 			continue
 		}
 		inboundConfig := inbound.GenXrayInboundConfig()
+		err = removeDisabledClients(inboundConfig, inbound)
+		if err != nil {
+			return nil, err
+		}
 		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *inboundConfig)
 	}
+	applyFallbacks(xrayConfig, inbounds)
+	decoyDest, err := s.decoyService.FallbackDest()
+	if err != nil {
+		return nil, err
+	}
+	applyDecoyFallback(xrayConfig, decoyDest)
+	err = applyOverageRouting(xrayConfig, inbounds)
+	if err != nil {
+		return nil, err
+	}
 	return xrayConfig, nil
 }
 
-func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, error) {
+// removeDisabledClients 从入站配置中去掉被禁用的用户，如与节点共享的流量已超出的用户
+func removeDisabledClients(inboundConfig *xray.InboundConfig, inbound *model.Inbound) error {
+	disabled := map[string]bool{}
+	for _, traffic := range inbound.ClientStats {
+		if !traffic.Enable {
+			disabled[traffic.Email] = true
+		}
+	}
+	if len(disabled) == 0 {
+		return nil
+	}
+	settings := map[string]interface{}{}
+	err := json.Unmarshal(inboundConfig.Settings, &settings)
+	if err != nil {
+		return err
+	}
+	clients, ok := settings["clients"].([]interface{})
+	if !ok {
+		return nil
+	}
+	enabled := make([]interface{}, 0, len(clients))
+	for _, c := range clients {
+		if client, ok := c.(map[string]interface{}); ok {
+			if email, _ := client["email"].(string); disabled[email] {
+				continue
+			}
+		}
+		enabled = append(enabled, c)
+	}
+	settings["clients"] = enabled
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	inboundConfig.Settings = json_util.RawMessage(data)
+	return nil
+}
+
+func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic, error) {
 	if !s.IsXrayRunning() {
-		return nil, errors.New("xray is not running")
+		return nil, nil, errors.New("xray is not running")
 	}
 	return p.GetTraffic(true)
 }
 
+// GetXrayRestartCount 返回面板启动以来 xray 被重启的次数，首次启动不计入
+func (s *XrayService) GetXrayRestartCount() int64 {
+	return xrayRestartCount.Load()
+}
+
 func (s *XrayService) RestartXray(isForce bool) error {
 	lock.Lock()
 	defer lock.Unlock()
@@ -95,7 +158,9 @@ func (s *XrayService) RestartXray(isForce bool) error {
 		return err
 	}
 
-	if p != nil && p.IsRunning() {
+	// 停止前记录是否在运行，停止后 IsRunning 总是 false，不能用来判断是否意外退出
+	wasRunning := p != nil && p.IsRunning()
+	if wasRunning {
 		if !isForce && p.GetConfig().Equals(xrayConfig) {
 			logger.Debug("not need to restart xray")
 			return nil
@@ -103,6 +168,13 @@ func (s *XrayService) RestartXray(isForce bool) error {
 		p.Stop()
 	}
 
+	if p != nil {
+		xrayRestartCount.Inc()
+		if !wasRunning && !isXrayStopped.Load() {
+			s.emitCrashed()
+		}
+	}
+	isXrayStopped.Store(false)
 	p = xray.NewProcess(xrayConfig)
 	result = ""
 	return p.Start()
@@ -113,11 +185,25 @@ func (s *XrayService) StopXray() error {
 	defer lock.Unlock()
 	logger.Debug("stop xray")
 	if s.IsXrayRunning() {
+		isXrayStopped.Store(true)
 		return p.Stop()
 	}
 	return errors.New("xray is not running")
 }
 
+// emitCrashed 在重启前发现 xray 并非被主动停止而是意外退出时调用
+func (s *XrayService) emitCrashed() {
+	data := map[string]interface{}{
+		"version": p.GetVersion(),
+		"result":  p.GetResult(),
+	}
+	if err := p.GetErr(); err != nil {
+		data["error"] = err.Error()
+	}
+	logger.Warning("xray exited unexpectedly:", data["error"])
+	s.webhookService.Emit(WebhookXrayCrashed, data)
+}
+
 func (s *XrayService) SetToNeedRestart() {
 	isNeedXrayRestart.Store(true)
 }
@@ -0,0 +1,685 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/logger"
+	"x-ui/web/locale"
+	"x-ui/xray"
+)
+
+const (
+	xrayReleasesUrl = "https://api.github.com/repos/XTLS/Xray-core/releases"
+	xrayDownloadUrl = "https://github.com/XTLS/Xray-core/releases/download/%s/%s"
+	// 压缩包和解压后的 xray 都不会超过这个大小
+	xrayCoreMaxSize = 100 << 20
+	// 切换版本后等待这么久 xray 仍在运行才认为切换成功
+	xrayCoreCheckDelay = 5 * time.Second
+)
+
+var xrayVersionRegex = regexp.MustCompile(`^v[0-9A-Za-z.+-]+$`)
+
+// 安装、切换和删除版本时都会修改 bin 目录，同一时间只允许一个操作
+var xrayCoreLock sync.Mutex
+
+// XrayCoreVersion 是一个已安装的 xray 版本，每个版本保存在 bin/xray-versions/<版本号>/xray
+type XrayCoreVersion struct {
+	Version     string `json:"version"`
+	Current     bool   `json:"current"`
+	Previous    bool   `json:"previous"`
+	Size        int64  `json:"size"`
+	InstalledAt int64  `json:"installedAt"`
+}
+
+type XrayCoreService struct {
+	settingService SettingService
+	xrayService    XrayService
+}
+
+func normalizeXrayVersion(version string) string {
+	return "v" + strings.TrimPrefix(strings.TrimSpace(version), "v")
+}
+
+func checkXrayVersion(version string) (string, error) {
+	version = normalizeXrayVersion(version)
+	if !xrayVersionRegex.MatchString(version) {
+		return "", locale.NewError("xrayCore.invalidVersion", "Version", version)
+	}
+	return version, nil
+}
+
+func xrayVersionBinaryPath(version string) string {
+	return filepath.Join(xray.GetVersionsDir(), version, "xray")
+}
+
+// compareXrayVersion 按数字逐段比较版本号，如 v1.10.0 比 v1.9.1 新
+func compareXrayVersion(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr != nil || bErr != nil {
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+			continue
+		}
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// httpClient 返回访问 GitHub 使用的客户端，设置了代理时通过代理访问
+func (s *XrayCoreService) httpClient() (*http.Client, error) {
+	proxy, err := s.settingService.GetXrayDownloadProxy()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyUrl, err := url.Parse(proxy)
+		if err != nil {
+			return nil, locale.NewError("xrayCore.invalidProxy", "Error", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Minute,
+	}, nil
+}
+
+// mirrorUrl 设置了镜像时把镜像地址拼接在 GitHub 地址之前，其他地址不变
+func (s *XrayCoreService) mirrorUrl(u string) (string, error) {
+	mirror, err := s.settingService.GetXrayDownloadMirror()
+	if err != nil {
+		return "", err
+	}
+	if mirror == "" || !strings.HasPrefix(u, "https://github.com/") {
+		return u, nil
+	}
+	if !strings.HasSuffix(mirror, "/") {
+		mirror += "/"
+	}
+	return mirror + u, nil
+}
+
+func (s *XrayCoreService) download(client *http.Client, u string, w io.Writer) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return locale.NewError("file.downloadFailed", "Url", u, "Status", resp.Status)
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, xrayCoreMaxSize+1))
+	if err != nil {
+		return err
+	}
+	if n > xrayCoreMaxSize {
+		return locale.NewError("file.downloadTooLarge", "Url", u)
+	}
+	return nil
+}
+
+// GetReleases 返回 GitHub 上发布的 xray 版本
+func (s *XrayCoreService) GetReleases() ([]string, error) {
+	client, err := s.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 30 * time.Second
+	buffer := &bytes.Buffer{}
+	err = s.download(client, xrayReleasesUrl, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make([]Release, 0)
+	err = json.Unmarshal(buffer.Bytes(), &releases)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(releases))
+	for _, release := range releases {
+		versions = append(versions, release.TagName)
+	}
+	return versions, nil
+}
+
+// currentVersion 返回正在使用的 xray 的版本，xray 不存在或无法执行时返回空字符串
+func (s *XrayCoreService) currentVersion() string {
+	version, err := xray.GetBinaryVersion(xray.GetBinaryPath())
+	if err != nil {
+		return ""
+	}
+	return normalizeXrayVersion(version)
+}
+
+// saveCurrent 把正在使用的 xray 保存为一个已安装的版本，保证切换后还能切换回来
+func (s *XrayCoreService) saveCurrent() (string, error) {
+	current := s.currentVersion()
+	if current == "" || !xrayVersionRegex.MatchString(current) {
+		return "", nil
+	}
+	target := xrayVersionBinaryPath(current)
+	if _, err := os.Stat(target); err == nil {
+		return current, nil
+	}
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = copyXrayBinary(xray.GetBinaryPath(), target)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("saved current xray as installed version", current)
+	return current, nil
+}
+
+// copyXrayBinary 先写到临时文件再改名，避免覆盖时 xray 正在使用该文件或写到一半
+func copyXrayBinary(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// GetVersions 返回已安装的 xray 版本，新版本在前
+func (s *XrayCoreService) GetVersions() ([]*XrayCoreVersion, error) {
+	xrayCoreLock.Lock()
+	defer xrayCoreLock.Unlock()
+
+	current, err := s.saveCurrent()
+	if err != nil {
+		logger.Warning("save current xray failed:", err)
+	}
+	previous, err := s.settingService.GetXrayCorePrevious()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(xray.GetVersionsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	versions := make([]*XrayCoreVersion, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		stat, err := os.Stat(xrayVersionBinaryPath(entry.Name()))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, &XrayCoreVersion{
+			Version:     entry.Name(),
+			Current:     entry.Name() == current,
+			Previous:    entry.Name() == previous,
+			Size:        stat.Size(),
+			InstalledAt: stat.ModTime().UnixMilli(),
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareXrayVersion(versions[i].Version, versions[j].Version) > 0
+	})
+	return versions, nil
+}
+
+// parseDgst 解析发布文件附带的 .dgst 摘要，返回算法名到十六进制摘要的映射，
+// 格式为 "SHA2-256= xxx" 或 "SHA2-256(Xray-linux-64.zip)= xxx"
+func parseDgst(data []byte) map[string]string {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.LastIndex(line, "=")
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		if j := strings.Index(name, "("); j >= 0 {
+			name = strings.TrimSpace(name[:j])
+		}
+		sums[strings.ToUpper(name)] = strings.ToLower(strings.TrimSpace(line[i+1:]))
+	}
+	return sums
+}
+
+// verifyChecksum 校验文件内容，sum 是用户提供的 SHA256，dgst 是发布文件附带的摘要，都可以为空
+func verifyChecksum(r io.Reader, sum string, dgst []byte) error {
+	sum = strings.ToLower(strings.TrimSpace(sum))
+	if sum == "" && dgst == nil {
+		return nil
+	}
+	h256 := sha256.New()
+	h512 := sha512.New()
+	_, err := io.Copy(io.MultiWriter(h256, h512), r)
+	if err != nil {
+		return err
+	}
+	actual256 := hex.EncodeToString(h256.Sum(nil))
+	actual512 := hex.EncodeToString(h512.Sum(nil))
+	if sum != "" && sum != actual256 {
+		return locale.NewError("file.sha256Mismatch", "Actual", actual256)
+	}
+	if dgst != nil {
+		sums := parseDgst(dgst)
+		if expected, ok := sums["SHA2-256"]; ok {
+			if expected != actual256 {
+				return locale.NewError("xrayCore.dgstSha256Mismatch", "Actual", actual256)
+			}
+		} else if expected, ok := sums["SHA2-512"]; ok {
+			if expected != actual512 {
+				return locale.NewError("xrayCore.dgstSha512Mismatch")
+			}
+		} else {
+			return locale.NewError("xrayCore.dgstEmpty")
+		}
+	}
+	return nil
+}
+
+// installBinary 识别临时文件中 xray 的版本，并移动到该版本的目录
+func (s *XrayCoreService) installBinary(tmp string) (string, error) {
+	defer os.Remove(tmp)
+	version, err := xray.GetBinaryVersion(tmp)
+	if err != nil {
+		return "", locale.NewError("xrayCore.notExecutable", "Error", err)
+	}
+	version, err = checkXrayVersion(version)
+	if err != nil {
+		return "", err
+	}
+	target := xrayVersionBinaryPath(version)
+	dir := filepath.Dir(target)
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmp, target)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("xray installed:", version)
+	return version, nil
+}
+
+// createTemp 在版本目录中创建临时文件，保证之后可以直接改名到版本的目录
+func createTemp(pattern string) (*os.File, error) {
+	err := os.MkdirAll(xray.GetVersionsDir(), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(xray.GetVersionsDir(), pattern)
+}
+
+func (s *XrayCoreService) installZip(r io.ReaderAt, size int64) (string, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", locale.NewError("file.invalidZip", "Error", err)
+	}
+	var binary *zip.File
+	geoFiles := map[string]*zip.File{}
+	for _, f := range reader.File {
+		switch path.Base(f.Name) {
+		case "xray":
+			binary = f
+		case "geoip.dat", "geosite.dat":
+			geoFiles[path.Base(f.Name)] = f
+		}
+	}
+	if binary == nil {
+		return "", locale.NewError("xrayCore.noBinary")
+	}
+	if binary.UncompressedSize64 > xrayCoreMaxSize {
+		return "", locale.NewError("xrayCore.binaryTooLarge", "Size", binary.UncompressedSize64)
+	}
+
+	tmp, err := createTemp("xray-*")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	err = extractZipFile(binary, tmp.Name())
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	version, err := s.installBinary(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+
+	// geo 文件单独管理，这里只在还没有的时候补上，不覆盖已有的文件
+	for name, target := range map[string]string{
+		"geoip.dat":   xray.GetGeoipPath(),
+		"geosite.dat": xray.GetGeositePath(),
+	} {
+		f, ok := geoFiles[name]
+		if !ok || f.UncompressedSize64 > xrayCoreMaxSize {
+			continue
+		}
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+		err := extractZipFile(f, target)
+		if err != nil {
+			logger.Warning("extract", name, "failed:", err)
+		}
+	}
+	return version, nil
+}
+
+// InstallRelease 从 GitHub 或镜像下载指定版本，校验 .dgst 中的摘要后安装，已安装的版本不会重复下载
+func (s *XrayCoreService) InstallRelease(version string) (string, error) {
+	version, err := checkXrayVersion(version)
+	if err != nil {
+		return "", err
+	}
+	xrayCoreLock.Lock()
+	defer xrayCoreLock.Unlock()
+
+	if _, err := os.Stat(xrayVersionBinaryPath(version)); err == nil {
+		return version, nil
+	}
+
+	osName := runtime.GOOS
+	arch := runtime.GOARCH
+	switch osName {
+	case "darwin":
+		osName = "macos"
+	}
+
+	switch arch {
+	case "amd64":
+		arch = "64"
+	case "arm64":
+		arch = "arm64-v8a"
+	}
+
+	fileName := fmt.Sprintf("Xray-%s-%s.zip", osName, arch)
+	client, err := s.httpClient()
+	if err != nil {
+		return "", err
+	}
+	zipUrl, err := s.mirrorUrl(fmt.Sprintf(xrayDownloadUrl, version, fileName))
+	if err != nil {
+		return "", err
+	}
+	dgst := &bytes.Buffer{}
+	err = s.download(client, zipUrl+".dgst", dgst)
+	if err != nil {
+		return "", locale.NewError("file.checksumFetchFailed", "Error", err)
+	}
+
+	file, err := createTemp("download-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+	logger.Info("downloading xray from", zipUrl)
+	err = s.download(client, zipUrl, file)
+	if err != nil {
+		return "", err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	err = verifyChecksum(io.NewSectionReader(file, 0, size), "", dgst.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return s.installZip(file, size)
+}
+
+// InstallZip 从上传的 zip 压缩包安装，sum 不为空时先校验压缩包的 SHA256
+func (s *XrayCoreService) InstallZip(r io.ReaderAt, size int64, sum string) (string, error) {
+	if size > xrayCoreMaxSize {
+		return "", locale.NewError("file.archiveTooLarge", "Size", size)
+	}
+	xrayCoreLock.Lock()
+	defer xrayCoreLock.Unlock()
+
+	err := verifyChecksum(io.NewSectionReader(r, 0, size), sum, nil)
+	if err != nil {
+		return "", err
+	}
+	return s.installZip(r, size)
+}
+
+// InstallPath 从服务器上的 zip 压缩包或 xray 文件安装，压缩包旁边有 .dgst 文件时会一并校验
+func (s *XrayCoreService) InstallPath(filePath string, sum string) (string, error) {
+	filePath = strings.TrimSpace(filePath)
+	if filePath == "" {
+		return "", locale.NewError("xrayCore.emptyPath")
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !stat.Mode().IsRegular() {
+		return "", locale.NewError("xrayCore.notRegular", "Path", filePath)
+	}
+	if stat.Size() > xrayCoreMaxSize {
+		return "", locale.NewError("file.tooLarge", "Size", stat.Size())
+	}
+	xrayCoreLock.Lock()
+	defer xrayCoreLock.Unlock()
+
+	dgst, err := os.ReadFile(filePath + ".dgst")
+	if err != nil {
+		dgst = nil
+	}
+	err = verifyChecksum(io.NewSectionReader(file, 0, stat.Size()), sum, dgst)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(strings.ToLower(filePath), ".zip") {
+		return s.installZip(file, stat.Size())
+	}
+
+	tmp, err := createTemp("xray-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, io.NewSectionReader(file, 0, stat.Size()))
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return s.installBinary(tmp.Name())
+}
+
+// testConfig 用新版本检查当前生成的 xray 配置，避免切换后因为配置不兼容无法启动
+func (s *XrayCoreService) testConfig(binary string) error {
+	xrayConfig, err := s.xrayService.GetXrayConfig()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(xrayConfig)
+	if err != nil {
+		return err
+	}
+	tmp, err := createTemp("config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary, "-test", "-c", tmp.Name())
+	cmd.Env = xray.GetAssetEnv()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return locale.NewError("xrayCore.configTestFailed", "Error", err, "Output", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Switch 切换到已安装的版本，切换后 xray 无法正常运行时自动恢复到原来的版本
+func (s *XrayCoreService) Switch(version string) error {
+	version, err := checkXrayVersion(version)
+	if err != nil {
+		return err
+	}
+	xrayCoreLock.Lock()
+	defer xrayCoreLock.Unlock()
+
+	binary := xrayVersionBinaryPath(version)
+	if _, err := os.Stat(binary); err != nil {
+		return locale.NewError("xrayCore.notInstalled", "Version", version)
+	}
+	current, err := s.saveCurrent()
+	if err != nil {
+		return err
+	}
+	if current == version {
+		return locale.NewError("xrayCore.alreadyUsed", "Version", version)
+	}
+	err = s.testConfig(binary)
+	if err != nil {
+		return err
+	}
+
+	err = copyXrayBinary(binary, xray.GetBinaryPath())
+	if err != nil {
+		return err
+	}
+	err = s.xrayService.RestartXray(true)
+	if err == nil {
+		time.Sleep(xrayCoreCheckDelay)
+		if !s.xrayService.IsXrayRunning() {
+			err = locale.NewError("xrayCore.exited", "Result", s.xrayService.GetXrayResult())
+		}
+	}
+	if err != nil {
+		logger.Warning("xray", version, "failed to start:", err)
+		if current == "" {
+			return locale.NewError("xrayCore.startFailed", "Version", version, "Error", err)
+		}
+		if err1 := copyXrayBinary(xrayVersionBinaryPath(current), xray.GetBinaryPath()); err1 != nil {
+			return locale.NewError("xrayCore.revertFailed", "Version", version, "Error", err, "Current", current, "RevertError", err1)
+		}
+		if err1 := s.xrayService.RestartXray(true); err1 != nil {
+			logger.Warning("restart xray after revert failed:", err1)
+		}
+		return locale.NewError("xrayCore.reverted", "Version", version, "Current", current, "Error", err)
+	}
+
+	logger.Infof("xray switched from %s to %s", current, version)
+	if current != "" {
+		return s.settingService.SetXrayCorePrevious(current)
+	}
+	return nil
+}
+
+// Rollback 切换回上一次切换前使用的版本
+func (s *XrayCoreService) Rollback() (string, error) {
+	previous, err := s.settingService.GetXrayCorePrevious()
+	if err != nil {
+		return "", err
+	}
+	if previous == "" {
+		return "", locale.NewError("xrayCore.noPrevious")
+	}
+	return previous, s.Switch(previous)
+}
+
+// DelVersion 删除一个已安装的版本，正在使用的版本不能删除
+func (s *XrayCoreService) DelVersion(version string) error {
+	version, err := checkXrayVersion(version)
+	if err != nil {
+		return err
+	}
+	xrayCoreLock.Lock()
+	defer xrayCoreLock.Unlock()
+
+	if version == s.currentVersion() {
+		return locale.NewError("xrayCore.delCurrent", "Version", version)
+	}
+	err = os.RemoveAll(filepath.Dir(xrayVersionBinaryPath(version)))
+	if err != nil {
+		return err
+	}
+	previous, err := s.settingService.GetXrayCorePrevious()
+	if err == nil && previous == version {
+		return s.settingService.SetXrayCorePrevious("")
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDgst(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			"release format",
+			"MD5= 0A1B\nSHA1= 2c3d\nSHA2-256= 4E5F\nSHA2-512= 6a7b\n",
+			map[string]string{"MD5": "0a1b", "SHA1": "2c3d", "SHA2-256": "4e5f", "SHA2-512": "6a7b"},
+		},
+		{
+			"openssl format",
+			"SHA2-256(Xray-linux-64.zip)= abcd\r\nsha2-512(Xray-linux-64.zip) = EF01\n",
+			map[string]string{"SHA2-256": "abcd", "SHA2-512": "ef01"},
+		},
+		{
+			"ignores lines without digest",
+			"\nnot a digest\nSHA2-256= abcd\n",
+			map[string]string{"SHA2-256": "abcd"},
+		},
+		{"empty", "", map[string]string{}},
+	}
+	for _, test := range tests {
+		got := parseDgst([]byte(test.data))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseDgst() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompareXrayVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.5.8", "v1.5.8", 0},
+		{"v1.10.0", "v1.9.1", 1},
+		{"v1.8.4", "v1.8.10", -1},
+		{"1.8.4", "v1.8.4", 0},
+		{"v1.8", "v1.8.0", -1},
+		{"v2.0.0", "v1.99.99", 1},
+		{"v1.8.0-beta", "v1.8.0-alpha", 1},
+	}
+	for _, test := range tests {
+		got := compareXrayVersion(test.a, test.b)
+		if sign(got) != test.want {
+			t.Errorf("compareXrayVersion(%q, %q) = %d, want sign %d", test.a, test.b, got, test.want)
+		}
+		if reverse := compareXrayVersion(test.b, test.a); sign(reverse) != -test.want {
+			t.Errorf("compareXrayVersion(%q, %q) = %d, want sign %d", test.b, test.a, reverse, -test.want)
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
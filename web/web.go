@@ -18,16 +18,15 @@ import (
 	"x-ui/util/common"
 	"x-ui/web/controller"
 	"x-ui/web/job"
+	"x-ui/web/locale"
 	"x-ui/web/network"
 	"x-ui/web/service"
 
-	"github.com/BurntSushi/toml"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/robfig/cron/v3"
-	"golang.org/x/text/language"
 )
 
 //go:embed assets/*
@@ -43,8 +42,6 @@ var startTime = time.Now()
 
 var xuiBeginRunTime string
 
-var isTelegramEnable bool
-
 type wrapAssetsFS struct {
 	embed.FS
 }
@@ -89,14 +86,21 @@ type Server struct {
 	httpServer *http.Server
 	listener   net.Listener
 
-	index  *controller.IndexController
-	server *controller.ServerController
-	xui    *controller.XUIController
+	index   *controller.IndexController
+	server  *controller.ServerController
+	xui     *controller.XUIController
+	metrics *controller.MetricsController
+	sub     *controller.SubController
+	tgbot   *controller.TgBotController
+	node    *controller.NodeAgentController
 
 	xrayService     service.XrayService
 	settingService  service.SettingService
 	inboundService  service.InboundService
 	telegramService service.TelegramService
+	sshWatchService service.SSHWatchService
+	decoyService    service.DecoyService
+	ipBlockService  service.IpBlockService
 
 	cron *cron.Cron
 
@@ -165,6 +169,20 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 
 	engine := gin.Default()
 
+	// 只有通过伪装站点转发时才信任 X-Forwarded-For，否则访问者可以伪造请求头绕过 IP 封禁
+	panelBehindDecoy, err := s.decoyService.PanelBehindDecoy()
+	if err != nil {
+		return nil, err
+	}
+	if panelBehindDecoy {
+		err = engine.SetTrustedProxies([]string{"127.0.0.1", "::1"})
+	} else {
+		err = engine.SetTrustedProxies(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	secret, err := s.settingService.GetSecret()
 	if err != nil {
 		return nil, err
@@ -176,6 +194,13 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	}
 	assetsBasePath := basePath + "assets/"
 
+	// 封禁列表中的 IP 不能访问面板的任何页面和接口
+	engine.Use(func(c *gin.Context) {
+		if s.ipBlockService.IsBlocked(c.ClientIP()) {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	})
+
 	store := cookie.NewStore(secret)
 	engine.Use(sessions.Sessions("session", store))
 	engine.Use(func(c *gin.Context) {
@@ -215,30 +240,24 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	s.index = controller.NewIndexController(g)
 	s.server = controller.NewServerController(g)
 	s.xui = controller.NewXUIController(g)
+	s.metrics = controller.NewMetricsController(g)
+	s.sub = controller.NewSubController(g)
+	s.tgbot = controller.NewTgBotController(g)
+	s.node = controller.NewNodeAgentController(g)
 
 	return engine, nil
 }
 
 func (s *Server) initI18n(engine *gin.Engine) error {
-	bundle := i18n.NewBundle(language.SimplifiedChinese)
-	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
-	err := fs.WalkDir(i18nFS, "translation", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-		data, err := i18nFS.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		_, err = bundle.ParseMessageFileBytes(data, path)
+	err := locale.InitBundle(i18nFS, "translation")
+	if err != nil {
 		return err
-	})
+	}
+	tgLang, err := s.settingService.GetTgLang()
 	if err != nil {
 		return err
 	}
+	locale.SetBotLanguage(tgLang)
 
 	findI18nParamNames := func(key string) []string {
 		names := make([]string, 0)
@@ -280,7 +299,7 @@ func (s *Server) initI18n(engine *gin.Engine) error {
 
 	engine.Use(func(c *gin.Context) {
 		accept := c.GetHeader("Accept-Language")
-		localizer = i18n.NewLocalizer(bundle, accept)
+		localizer = locale.NewLocalizer(accept)
 		c.Set("localizer", localizer)
 		c.Next()
 	})
@@ -304,25 +323,37 @@ func (s *Server) startTask() {
 
 	// 每 30 秒检查一次 inbound 流量超出和到期的情况
 	s.cron.AddJob("@every 30s", job.NewCheckInboundJob())
-	//每2s检查一次SSH信息
-	s.cron.AddFunc("@every 2s", func() { job.NewStatsNotifyJob().SSHStatusLoginNotify(xuiBeginRunTime) })
+	// 每分钟检查一次是否到了入站和用户的流量重置时间
+	s.cron.AddJob("30 * * * * *", job.NewTrafficResetJob())
+	// 每 10 秒采集一次服务器状态，并按分钟、5 分钟、小时逐级聚合保存，错开几秒保证上一级已写入
+	s.cron.AddJob("@every 10s", job.NewServerStatJob())
+	s.cron.AddJob("0 * * * * *", job.NewServerStatRollupJob(service.StatResolutionMinute))
+	s.cron.AddJob("5 */5 * * * *", job.NewServerStatRollupJob(service.StatResolution5Min))
+	s.cron.AddJob("10 0 * * * *", job.NewServerStatRollupJob(service.StatResolutionHour))
+	// 每 30 秒评估一次告警规则
+	s.cron.AddJob("@every 30s", job.NewAlertJob())
+	// 每 30 秒重试到期的 webhook 投递
+	s.cron.AddJob("@every 30s", job.NewWebhookJob())
+	// 每 10 分钟检查一次绑定了电报的用户是否需要流量或到期提醒
+	s.cron.AddJob("@every 10m", job.NewClientWarnJob())
+	// 每 30 秒同步一次各节点上用户的流量和启用状态
+	s.cron.AddJob("@every 30s", job.NewNodeSyncJob())
+	// 每 30 秒汇总一次 SSH 失败登录，并清理到期的 IP 封禁
+	s.cron.AddJob("@every 30s", job.NewSSHLoginJob())
+	// 每 10 分钟检查一次 geo 文件是否到了自动更新的时间
+	s.cron.AddJob("@every 10m", job.NewGeoUpdateJob())
 	// 每一天提示一次流量情况,上海时间8点30
-	var entry cron.EntryID
-
-	if isTelegramEnable {
-		runtime, err := s.settingService.GetTgbotRuntime()
-		if err != nil || runtime == "" {
-			logger.Errorf("Add NewStatsNotifyJob error[%s],Runtime[%s] invalid,wil run default", err, runtime)
-			runtime = "@daily"
-		}
-		logger.Infof("Tg notify enabled,run at %s", runtime)
-		entry, err = s.cron.AddJob(runtime, job.NewStatsNotifyJob())
-		if err != nil {
-			logger.Warning("Add NewStatsNotifyJob error", err)
-			return
-		}
-	} else {
-		s.cron.Remove(entry)
+	// 任务总是注册，运行时再检查机器人是否启用，这样面板运行中开启机器人也能收到日报
+	runtime, err := s.settingService.GetTgbotRuntime()
+	if err != nil || runtime == "" {
+		logger.Errorf("Add NewStatsNotifyJob error[%s],Runtime[%s] invalid,wil run default", err, runtime)
+		runtime = "@daily"
+	}
+	logger.Infof("Tg notify run at %s", runtime)
+	_, err = s.cron.AddJob(runtime, job.NewStatsNotifyJob())
+	if err != nil {
+		logger.Warning("Add NewStatsNotifyJob error", err)
+		return
 	}
 }
 
@@ -362,6 +393,14 @@ func (s *Server) Start() (err error) {
 	if err != nil {
 		return err
 	}
+	panelBehindDecoy, err := s.decoyService.PanelBehindDecoy()
+	if err != nil {
+		return err
+	}
+	if panelBehindDecoy {
+		// 面板只能通过伪装站点访问
+		listen = "127.0.0.1"
+	}
 	listenAddr := net.JoinHostPort(listen, strconv.Itoa(port))
 	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
@@ -391,15 +430,25 @@ func (s *Server) Start() (err error) {
 
 	isTgbotenabled, err := s.settingService.GetTgbotenabled()
 	if (err == nil) && (isTgbotenabled) {
-		isTelegramEnable = true
-
 		go func() {
-			s.telegramService.StartRun()
-			time.Sleep(time.Second * 2)
+			if err := s.telegramService.Start(); err != nil {
+				logger.Warning("start telegram bot failed:", err)
+			}
 		}()
+	}
 
-	} else {
-		isTelegramEnable = false
+	sshWatchEnable, err := s.settingService.GetSSHWatchEnable()
+	if err == nil && sshWatchEnable {
+		if err := s.sshWatchService.Start(); err != nil {
+			logger.Warning("start ssh login watcher failed:", err)
+		}
+	}
+
+	decoyEnable, err := s.settingService.GetDecoyEnable()
+	if err == nil && decoyEnable {
+		if err := s.decoyService.Start(); err != nil {
+			logger.Warning("start decoy site failed:", err)
+		}
 	}
 
 	s.startTask()
@@ -417,9 +466,9 @@ func (s *Server) Start() (err error) {
 
 func (s *Server) Stop() error {
 	s.cancel()
-	if isTelegramEnable {
-		s.telegramService.StopRunAndClose()
-	}
+	s.telegramService.Stop()
+	s.sshWatchService.Stop()
+	s.decoyService.Stop()
 	s.xrayService.StopXray()
 	if s.cron != nil {
 		s.cron.Stop()
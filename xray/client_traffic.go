@@ -0,0 +1,24 @@
+package xray
+
+type ClientTraffic struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId  int    `json:"inboundId" form:"inboundId" gorm:"index"`
+	Enable     bool   `json:"enable" form:"enable"`
+	Email      string `json:"email" form:"email" gorm:"unique"`
+	Up         int64  `json:"up" form:"up"`
+	Down       int64  `json:"down" form:"down"`
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
+	Total      int64  `json:"total" form:"total"`
+	// 有效天数和剩余自动续期次数，含义与入站相同
+	ExpiryDays int `json:"expiryDays" form:"expiryDays"`
+	AutoRenew  int `json:"autoRenew" form:"autoRenew"`
+	// 订阅链接中用于识别用户的随机 token
+	SubToken string `json:"subToken" form:"subToken" gorm:"index"`
+	// 单独设置的流量重置周期，为空时随入站一起重置
+	ResetPolicy string `json:"resetPolicy" form:"resetPolicy"`
+	ResetDay    int    `json:"resetDay" form:"resetDay"`
+	LastResetAt int64  `json:"lastResetAt" form:"lastResetAt"`
+	CreatedAt   int64  `json:"createdAt" form:"createdAt" gorm:"autoCreateTime:milli"`
+	// 被禁用的原因，取值与入站相同，只有因流量超出或到期而禁用的用户会被自动重新启用
+	DisableReason string `json:"disableReason"`
+}
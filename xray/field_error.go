@@ -0,0 +1,67 @@
+package xray
+
+import (
+	"strings"
+	"x-ui/web/locale"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// FieldError 是入站配置中某个字段的错误，Field 为 JSON 路径，如 settings.clients[0].id，
+// Key 为 Message 的翻译 key，Message 默认按机器人语言翻译，接口返回前按请求的语言重新翻译
+type FieldError struct {
+	Field   string `json:"field"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+	params  []interface{}
+}
+
+// NewFieldError 创建字段错误，key 为 field 翻译分组下的 key，params 为成对的参数名和值
+func NewFieldError(field string, key string, params ...interface{}) FieldError {
+	key = "field." + key
+	return FieldError{Field: field, Key: key, Message: locale.Bot(key, params...), params: params}
+}
+
+// FieldErrors 收集一次检查中发现的全部字段错误
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Field+": "+err.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Localize 返回按 localizer 翻译了 Message 的副本
+func (e FieldErrors) Localize(localizer *i18n.Localizer) FieldErrors {
+	localized := make(FieldErrors, len(e))
+	for i, err := range e {
+		if err.Key != "" {
+			err.Message = locale.Localize(localizer, err.Key, err.params...)
+		}
+		localized[i] = err
+	}
+	return localized
+}
+
+func (e *FieldErrors) add(field string, key string, params ...interface{}) {
+	*e = append(*e, NewFieldError(field, key, params...))
+}
+
+// err 没有错误时返回 nil，避免返回值为空切片的非 nil error
+func (e FieldErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func oneOf(value string, values ...string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
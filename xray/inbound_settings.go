@@ -0,0 +1,439 @@
+package xray
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// InboundSettings 是各协议入站 settings 的结构，Validate 检查各字段，部分检查需要传输设置
+type InboundSettings interface {
+	Validate(stream *StreamSettings) FieldErrors
+}
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// checkId xray 的 id 可以是 UUID，也可以是 1-30 字节的字符串，由 xray 映射为 UUID
+func checkId(errs *FieldErrors, field string, id string) {
+	if id == "" {
+		errs.add(field, "empty")
+	} else if !uuidRegex.MatchString(id) && len(id) > 30 {
+		errs.add(field, "clientId")
+	}
+}
+
+// checkEmails 同一入站内的 email 不能重复
+func checkEmails(errs *FieldErrors, emails []string) {
+	seen := map[string]bool{}
+	for i, email := range emails {
+		if email == "" {
+			continue
+		}
+		if seen[email] {
+			errs.add(fmt.Sprintf("settings.clients[%d].email", i), "emailDuplicate", "Email", email)
+		}
+		seen[email] = true
+	}
+}
+
+func checkNetwork(errs *FieldErrors, field string, network string) {
+	if network == "" {
+		return
+	}
+	for _, n := range strings.Split(network, ",") {
+		if !oneOf(strings.TrimSpace(n), "tcp", "udp") {
+			errs.add(field, "network")
+			return
+		}
+	}
+}
+
+var xtlsFlows = []string{"", "xtls-rprx-origin", "xtls-rprx-origin-udp443", "xtls-rprx-direct", "xtls-rprx-direct-udp443"}
+
+// checkFlow 面板在未使用 xtls 时也会保留默认流控，此时 xray 不使用该字段，只检查取值
+func checkFlow(errs *FieldErrors, field string, flow string) {
+	if !oneOf(flow, xtlsFlows...) {
+		errs.add(field, "unsupportedFlow", "Value", flow)
+	}
+}
+
+// FallbackDest 可以是端口号、地址:端口或 unix socket 路径，JSON 中可以是数字或字符串
+type FallbackDest string
+
+func (d *FallbackDest) UnmarshalJSON(data []byte) error {
+	var port int
+	if json.Unmarshal(data, &port) == nil {
+		*d = FallbackDest(strconv.Itoa(port))
+		return nil
+	}
+	var dest string
+	err := json.Unmarshal(data, &dest)
+	if err != nil {
+		return err
+	}
+	*d = FallbackDest(dest)
+	return nil
+}
+
+// Fallback 设置了 Inbound 时回落到该标签的内部入站，dest 在生成 xray 配置时填入
+type Fallback struct {
+	Name    string       `json:"name"`
+	Alpn    string       `json:"alpn"`
+	Path    string       `json:"path"`
+	Dest    FallbackDest `json:"dest"`
+	Xver    int          `json:"xver"`
+	Inbound string       `json:"inbound,omitempty"`
+}
+
+func checkFallbacks(errs *FieldErrors, fallbacks []Fallback, stream *StreamSettings) {
+	if len(fallbacks) == 0 {
+		return
+	}
+	if stream.GetNetwork() != "tcp" {
+		errs.add("settings.fallbacks", "fallbackTcp")
+	}
+	for i, fallback := range fallbacks {
+		prefix := fmt.Sprintf("settings.fallbacks[%d]", i)
+		if fallback.Inbound == "" {
+			checkFallbackDest(errs, prefix+".dest", string(fallback.Dest))
+		}
+		if fallback.Path != "" && !strings.HasPrefix(fallback.Path, "/") {
+			errs.add(prefix+".path", "pathSlash")
+		}
+		if !oneOf(fallback.Alpn, "", "h2", "http/1.1") {
+			errs.add(prefix+".alpn", "alpn")
+		}
+		if fallback.Xver < 0 || fallback.Xver > 2 {
+			errs.add(prefix+".xver", "xver")
+		}
+	}
+}
+
+func checkFallbackDest(errs *FieldErrors, field string, dest string) {
+	if dest == "" {
+		errs.add(field, "empty")
+		return
+	}
+	if strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "@") {
+		return
+	}
+	if port, err := strconv.Atoi(dest); err == nil {
+		if port <= 0 || port > 65535 {
+			errs.add(field, "invalidPort", "Port", port)
+		}
+		return
+	}
+	_, portStr, err := net.SplitHostPort(dest)
+	if err != nil {
+		errs.add(field, "dest")
+		return
+	}
+	if port, err := strconv.Atoi(portStr); err != nil || port <= 0 || port > 65535 {
+		errs.add(field, "invalidPort", "Port", portStr)
+	}
+}
+
+type VmessClient struct {
+	Id      string `json:"id"`
+	AlterId int    `json:"alterId"`
+	Email   string `json:"email"`
+}
+
+type VmessSettings struct {
+	Clients                   []VmessClient `json:"clients"`
+	DisableInsecureEncryption bool          `json:"disableInsecureEncryption"`
+}
+
+func (s *VmessSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	emails := make([]string, 0, len(s.Clients))
+	for i, client := range s.Clients {
+		prefix := fmt.Sprintf("settings.clients[%d]", i)
+		checkId(&errs, prefix+".id", client.Id)
+		if client.AlterId < 0 || client.AlterId > 65535 {
+			errs.add(prefix+".alterId", "alterId")
+		}
+		emails = append(emails, client.Email)
+	}
+	checkEmails(&errs, emails)
+	return errs
+}
+
+type VlessClient struct {
+	Id    string `json:"id"`
+	Flow  string `json:"flow"`
+	Email string `json:"email"`
+}
+
+type VlessSettings struct {
+	Clients    []VlessClient `json:"clients"`
+	Decryption string        `json:"decryption"`
+	Fallbacks  []Fallback    `json:"fallbacks"`
+}
+
+func (s *VlessSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	if s.Decryption != "none" {
+		errs.add("settings.decryption", "decryption")
+	}
+	emails := make([]string, 0, len(s.Clients))
+	for i, client := range s.Clients {
+		prefix := fmt.Sprintf("settings.clients[%d]", i)
+		checkId(&errs, prefix+".id", client.Id)
+		checkFlow(&errs, prefix+".flow", client.Flow)
+		emails = append(emails, client.Email)
+	}
+	checkEmails(&errs, emails)
+	checkFallbacks(&errs, s.Fallbacks, stream)
+	return errs
+}
+
+type TrojanClient struct {
+	Password string `json:"password"`
+	Flow     string `json:"flow"`
+	Email    string `json:"email"`
+}
+
+type TrojanSettings struct {
+	Clients   []TrojanClient `json:"clients"`
+	Fallbacks []Fallback     `json:"fallbacks"`
+}
+
+func (s *TrojanSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	emails := make([]string, 0, len(s.Clients))
+	for i, client := range s.Clients {
+		prefix := fmt.Sprintf("settings.clients[%d]", i)
+		if client.Password == "" {
+			errs.add(prefix+".password", "empty")
+		}
+		checkFlow(&errs, prefix+".flow", client.Flow)
+		emails = append(emails, client.Email)
+	}
+	checkEmails(&errs, emails)
+	checkFallbacks(&errs, s.Fallbacks, stream)
+	return errs
+}
+
+// ssKeySizes 是 2022 系列加密方式要求的密钥字节数，密码为 base64 编码的密钥
+var ssKeySizes = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+var ssMethods = []string{"aes-128-gcm", "aes-256-gcm", "chacha20-poly1305", "chacha20-ietf-poly1305", "xchacha20-poly1305", "xchacha20-ietf-poly1305", "none", "plain", "aead_aes_128_gcm", "aead_aes_256_gcm", "aead_chacha20_poly1305", "aead_xchacha20_poly1305"}
+
+type ShadowsocksSettings struct {
+	Method   string `json:"method"`
+	Password string `json:"password"`
+	Network  string `json:"network"`
+	Email    string `json:"email"`
+}
+
+func (s *ShadowsocksSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	if size, ok := ssKeySizes[s.Method]; ok {
+		key, err := base64.StdEncoding.DecodeString(s.Password)
+		if err != nil || len(key) != size {
+			errs.add("settings.password", "ssKey", "Method", s.Method, "Size", size)
+		}
+	} else if !oneOf(s.Method, ssMethods...) {
+		errs.add("settings.method", "unsupportedMethod", "Value", s.Method)
+	} else if s.Password == "" {
+		errs.add("settings.password", "empty")
+	}
+	checkNetwork(&errs, "settings.network", s.Network)
+	return errs
+}
+
+type DokodemoSettings struct {
+	Address        string `json:"address"`
+	Port           int    `json:"port"`
+	Network        string `json:"network"`
+	FollowRedirect bool   `json:"followRedirect"`
+}
+
+func (s *DokodemoSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	if !s.FollowRedirect {
+		if s.Address == "" {
+			errs.add("settings.address", "empty")
+		}
+		if s.Port <= 0 || s.Port > 65535 {
+			errs.add("settings.port", "invalidPort", "Port", s.Port)
+		}
+	} else if s.Port < 0 || s.Port > 65535 {
+		errs.add("settings.port", "invalidPort", "Port", s.Port)
+	}
+	checkNetwork(&errs, "settings.network", s.Network)
+	return errs
+}
+
+type Account struct {
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+func checkAccounts(errs *FieldErrors, accounts []Account) {
+	for i, account := range accounts {
+		prefix := fmt.Sprintf("settings.accounts[%d]", i)
+		if account.User == "" {
+			errs.add(prefix+".user", "empty")
+		}
+		if account.Pass == "" {
+			errs.add(prefix+".pass", "empty")
+		}
+	}
+}
+
+type SocksSettings struct {
+	Auth     string    `json:"auth"`
+	Accounts []Account `json:"accounts"`
+	Udp      bool      `json:"udp"`
+	Ip       string    `json:"ip"`
+}
+
+func (s *SocksSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	switch s.Auth {
+	case "", "noauth":
+	case "password":
+		if len(s.Accounts) == 0 {
+			errs.add("settings.accounts", "accounts")
+		}
+		checkAccounts(&errs, s.Accounts)
+	default:
+		errs.add("settings.auth", "auth")
+	}
+	if s.Udp && s.Ip != "" && net.ParseIP(s.Ip) == nil {
+		errs.add("settings.ip", "invalidIp", "Ip", s.Ip)
+	}
+	return errs
+}
+
+type HttpSettings struct {
+	Accounts         []Account `json:"accounts"`
+	AllowTransparent bool      `json:"allowTransparent"`
+}
+
+func (s *HttpSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	checkAccounts(&errs, s.Accounts)
+	return errs
+}
+
+type MtprotoUser struct {
+	Email  string `json:"email"`
+	Level  int    `json:"level"`
+	Secret string `json:"secret"`
+}
+
+type MtprotoSettings struct {
+	Users []MtprotoUser `json:"users"`
+}
+
+func (s *MtprotoSettings) Validate(stream *StreamSettings) FieldErrors {
+	errs := FieldErrors{}
+	for i, user := range s.Users {
+		if _, err := hex.DecodeString(user.Secret); err != nil || len(user.Secret) != 32 {
+			errs.add(fmt.Sprintf("settings.users[%d].secret", i), "secret")
+		}
+	}
+	return errs
+}
+
+// NewInboundSettings 返回协议对应的 settings 结构，不支持的协议返回 nil
+func NewInboundSettings(protocol string) InboundSettings {
+	switch protocol {
+	case "vmess":
+		return &VmessSettings{}
+	case "vless":
+		return &VlessSettings{}
+	case "trojan":
+		return &TrojanSettings{}
+	case "shadowsocks":
+		return &ShadowsocksSettings{}
+	case "dokodemo-door":
+		return &DokodemoSettings{}
+	case "socks":
+		return &SocksSettings{}
+	case "http":
+		return &HttpSettings{}
+	case "mtproto":
+		return &MtprotoSettings{}
+	}
+	return nil
+}
+
+// ParseInboundSettings 按协议解析入站 settings
+func ParseInboundSettings(protocol string, settings string) (InboundSettings, error) {
+	s := NewInboundSettings(protocol)
+	if s == nil {
+		return nil, FieldErrors{NewFieldError("protocol", "unsupportedProtocol", "Value", protocol)}
+	}
+	if settings == "" {
+		return s, nil
+	}
+	err := json.Unmarshal([]byte(settings), s)
+	if err != nil {
+		return nil, FieldErrors{jsonFieldError("settings", err)}
+	}
+	return s, nil
+}
+
+// ParseStreamSettings 解析入站 streamSettings，为空时为 tcp 不加密
+func ParseStreamSettings(streamSettings string) (*StreamSettings, error) {
+	stream := &StreamSettings{}
+	if streamSettings == "" {
+		return stream, nil
+	}
+	err := json.Unmarshal([]byte(streamSettings), stream)
+	if err != nil {
+		return nil, FieldErrors{jsonFieldError("streamSettings", err)}
+	}
+	return stream, nil
+}
+
+// jsonFieldError 类型错误时定位到具体字段
+func jsonFieldError(field string, err error) FieldError {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+		return NewFieldError(field+"."+typeErr.Field, "type", "Type", typeErr.Type)
+	}
+	return NewFieldError(field, "json", "Error", err)
+}
+
+// ValidateInbound 检查入站的 settings、streamSettings 和 sniffing，返回全部字段错误
+func ValidateInbound(protocol string, settings string, streamSettings string, sniffing string) error {
+	errs := FieldErrors{}
+	stream, err := ParseStreamSettings(streamSettings)
+	if err != nil {
+		errs = append(errs, err.(FieldErrors)...)
+		stream = &StreamSettings{}
+	} else {
+		errs = append(errs, stream.Validate()...)
+	}
+
+	s, err := ParseInboundSettings(protocol, settings)
+	if err != nil {
+		errs = append(errs, err.(FieldErrors)...)
+	} else {
+		errs = append(errs, s.Validate(stream)...)
+	}
+
+	if sniffing != "" {
+		sniff := &SniffingSettings{}
+		err = json.Unmarshal([]byte(sniffing), sniff)
+		if err != nil {
+			errs = append(errs, jsonFieldError("sniffing", err))
+		} else {
+			errs = append(errs, sniff.Validate()...)
+		}
+	}
+	return errs.err()
+}
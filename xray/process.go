@@ -10,6 +10,7 @@ import (
 	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
@@ -22,6 +23,7 @@ import (
 )
 
 var trafficRegex = regexp.MustCompile("(inbound|outbound)>>>([^>]+)>>>traffic>>>(downlink|uplink)")
+var clientTrafficRegex = regexp.MustCompile("user>>>([^>]+)>>>traffic>>>(downlink|uplink)")
 
 func GetBinaryName() string {
 	return fmt.Sprintf("xray-%s-%s", runtime.GOOS, runtime.GOARCH)
@@ -35,12 +37,35 @@ func GetConfigPath() string {
 	return "bin/config.json"
 }
 
+// GetVersionsDir 返回已安装的各个 xray 版本所在的目录，每个版本一个子目录
+func GetVersionsDir() string {
+	return "bin/xray-versions"
+}
+
+// GetAssetDir 返回 geo 数据文件所在的目录，路由规则中的 ext:文件名:标签 也从这里查找
+func GetAssetDir() string {
+	return "bin"
+}
+
+func GetAssetPath(name string) string {
+	return GetAssetDir() + "/" + name
+}
+
 func GetGeositePath() string {
-	return "bin/geosite.dat"
+	return GetAssetPath("geosite.dat")
 }
 
 func GetGeoipPath() string {
-	return "bin/geoip.dat"
+	return GetAssetPath("geoip.dat")
+}
+
+// GetAssetEnv 返回运行 xray 时指定 geo 文件目录的环境变量，否则 xray 会在程序所在目录中查找
+func GetAssetEnv() []string {
+	dir, err := filepath.Abs(GetAssetDir())
+	if err != nil {
+		dir = GetAssetDir()
+	}
+	return append(os.Environ(), "XRAY_LOCATION_ASSET="+dir)
 }
 
 func stopProcess(p *Process) {
@@ -125,18 +150,26 @@ func (p *process) refreshAPIPort() {
 	}
 }
 
-func (p *process) refreshVersion() {
-	cmd := exec.Command(GetBinaryPath(), "-version")
+// GetBinaryVersion 执行 xray -version 获取版本号，如 1.8.4
+func GetBinaryVersion(binaryPath string) (string, error) {
+	cmd := exec.Command(binaryPath, "-version")
 	data, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	datas := bytes.Split(data, []byte(" "))
+	if len(datas) <= 1 {
+		return "", errors.New("unknown xray version output")
+	}
+	return string(datas[1]), nil
+}
+
+func (p *process) refreshVersion() {
+	version, err := GetBinaryVersion(GetBinaryPath())
 	if err != nil {
 		p.version = "Unknown"
 	} else {
-		datas := bytes.Split(data, []byte(" "))
-		if len(datas) <= 1 {
-			p.version = "Unknown"
-		} else {
-			p.version = string(datas[1])
-		}
+		p.version = version
 	}
 }
 
@@ -162,6 +195,7 @@ func (p *process) Start() (err error) {
 	}
 
 	cmd := exec.Command(GetBinaryPath(), "-c", configPath)
+	cmd.Env = GetAssetEnv()
 	p.cmd = cmd
 
 	stdReader, err := cmd.StdoutPipe()
@@ -229,13 +263,13 @@ func (p *process) Stop() error {
 	return p.cmd.Process.Kill()
 }
 
-func (p *process) GetTraffic(reset bool) ([]*Traffic, error) {
+func (p *process) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
 	if p.apiPort == 0 {
-		return nil, common.NewError("xray api port wrong:", p.apiPort)
+		return nil, nil, common.NewError("xray api port wrong:", p.apiPort)
 	}
 	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%v", p.apiPort), grpc.WithInsecure())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer conn.Close()
 
@@ -247,12 +281,35 @@ func (p *process) GetTraffic(reset bool) ([]*Traffic, error) {
 	}
 	resp, err := client.QueryStats(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	tagTrafficMap := map[string]*Traffic{}
+	emailTrafficMap := map[string]*ClientTraffic{}
 	traffics := make([]*Traffic, 0)
+	clientTraffics := make([]*ClientTraffic, 0)
 	for _, stat := range resp.GetStat() {
+		if matchs := clientTrafficRegex.FindStringSubmatch(stat.Name); len(matchs) == 3 {
+			email := matchs[1]
+			isDown := matchs[2] == "downlink"
+			traffic, ok := emailTrafficMap[email]
+			if !ok {
+				traffic = &ClientTraffic{
+					Email: email,
+				}
+				emailTrafficMap[email] = traffic
+				clientTraffics = append(clientTraffics, traffic)
+			}
+			if isDown {
+				traffic.Down = stat.Value
+			} else {
+				traffic.Up = stat.Value
+			}
+			continue
+		}
 		matchs := trafficRegex.FindStringSubmatch(stat.Name)
+		if len(matchs) < 4 {
+			continue
+		}
 		isInbound := matchs[1] == "inbound"
 		tag := matchs[2]
 		isDown := matchs[3] == "downlink"
@@ -275,5 +332,5 @@ func (p *process) GetTraffic(reset bool) ([]*Traffic, error) {
 		}
 	}
 
-	return traffics, nil
+	return traffics, clientTraffics, nil
 }
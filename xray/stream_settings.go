@@ -0,0 +1,246 @@
+package xray
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type StreamHeader struct {
+	Type    string `json:"type"`
+	Request struct {
+		Path    []string               `json:"path"`
+		Headers map[string]interface{} `json:"headers"`
+	} `json:"request"`
+}
+
+type TLSCertificate struct {
+	CertificateFile string   `json:"certificateFile"`
+	KeyFile         string   `json:"keyFile"`
+	Certificate     []string `json:"certificate"`
+	Key             []string `json:"key"`
+}
+
+// TLSSettings 同时用于 tlsSettings 和 xtlsSettings
+type TLSSettings struct {
+	ServerName   string           `json:"serverName"`
+	Alpn         []string         `json:"alpn"`
+	Certificates []TLSCertificate `json:"certificates"`
+}
+
+type TCPSettings struct {
+	AcceptProxyProtocol bool         `json:"acceptProxyProtocol"`
+	Header              StreamHeader `json:"header"`
+}
+
+type KCPSettings struct {
+	Mtu              int          `json:"mtu"`
+	Tti              int          `json:"tti"`
+	UplinkCapacity   int          `json:"uplinkCapacity"`
+	DownlinkCapacity int          `json:"downlinkCapacity"`
+	Congestion       bool         `json:"congestion"`
+	ReadBufferSize   int          `json:"readBufferSize"`
+	WriteBufferSize  int          `json:"writeBufferSize"`
+	Header           StreamHeader `json:"header"`
+	Seed             string       `json:"seed"`
+}
+
+type WSSettings struct {
+	AcceptProxyProtocol bool                   `json:"acceptProxyProtocol"`
+	Path                string                 `json:"path"`
+	Headers             map[string]interface{} `json:"headers"`
+}
+
+type HTTPSettings struct {
+	Path string   `json:"path"`
+	Host []string `json:"host"`
+}
+
+type QUICSettings struct {
+	Security string       `json:"security"`
+	Key      string       `json:"key"`
+	Header   StreamHeader `json:"header"`
+}
+
+type GRPCSettings struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type StreamSettings struct {
+	Network      string        `json:"network"`
+	Security     string        `json:"security"`
+	TLSSettings  *TLSSettings  `json:"tlsSettings"`
+	XTLSSettings *TLSSettings  `json:"xtlsSettings"`
+	TCPSettings  *TCPSettings  `json:"tcpSettings"`
+	KCPSettings  *KCPSettings  `json:"kcpSettings"`
+	WSSettings   *WSSettings   `json:"wsSettings"`
+	HTTPSettings *HTTPSettings `json:"httpSettings"`
+	QUICSettings *QUICSettings `json:"quicSettings"`
+	GRPCSettings *GRPCSettings `json:"grpcSettings"`
+}
+
+// networkAliases 是 xray 接受的传输方式别名
+var networkAliases = map[string]string{
+	"":          "tcp",
+	"mkcp":      "kcp",
+	"websocket": "ws",
+	"h2":        "http",
+	"gun":       "grpc",
+	"ds":        "domainsocket",
+}
+
+// GetNetwork 返回传输方式，别名转换为标准名称，未设置时为 tcp
+func (s *StreamSettings) GetNetwork() string {
+	if network, ok := networkAliases[strings.ToLower(s.Network)]; ok {
+		return network
+	}
+	return strings.ToLower(s.Network)
+}
+
+// GetSecurity 返回传输层加密方式，未设置时为 none
+func (s *StreamSettings) GetSecurity() string {
+	if s.Security == "" {
+		return "none"
+	}
+	return s.Security
+}
+
+// GetTLSSettings 返回当前加密方式对应的 tls 或 xtls 设置，不加密时为 nil
+func (s *StreamSettings) GetTLSSettings() *TLSSettings {
+	switch s.Security {
+	case "tls":
+		return s.TLSSettings
+	case "xtls":
+		return s.XTLSSettings
+	}
+	return nil
+}
+
+// ServerName 返回 tls 或 xtls 的 serverName
+func (s *StreamSettings) ServerName() string {
+	if tls := s.GetTLSSettings(); tls != nil {
+		return tls.ServerName
+	}
+	return ""
+}
+
+var headerTypes = []string{"none", "srtp", "utp", "wechat-video", "dtls", "wireguard"}
+
+func (s *StreamSettings) Validate() FieldErrors {
+	errs := FieldErrors{}
+	network := s.GetNetwork()
+	switch network {
+	case "tcp":
+		if s.TCPSettings != nil && !oneOf(s.TCPSettings.Header.Type, "", "none", "http") {
+			errs.add("streamSettings.tcpSettings.header.type", "unsupportedHeader", "Value", s.TCPSettings.Header.Type)
+		}
+	case "kcp":
+		if kcp := s.KCPSettings; kcp != nil {
+			if kcp.Mtu != 0 && (kcp.Mtu < 576 || kcp.Mtu > 1460) {
+				errs.add("streamSettings.kcpSettings.mtu", "mtu")
+			}
+			if kcp.Tti != 0 && (kcp.Tti < 10 || kcp.Tti > 100) {
+				errs.add("streamSettings.kcpSettings.tti", "tti")
+			}
+			if kcp.UplinkCapacity < 0 {
+				errs.add("streamSettings.kcpSettings.uplinkCapacity", "negative")
+			}
+			if kcp.DownlinkCapacity < 0 {
+				errs.add("streamSettings.kcpSettings.downlinkCapacity", "negative")
+			}
+			if !oneOf(kcp.Header.Type, append(headerTypes, "")...) {
+				errs.add("streamSettings.kcpSettings.header.type", "unsupportedHeader", "Value", kcp.Header.Type)
+			}
+		}
+	case "ws":
+		if s.WSSettings != nil && s.WSSettings.Path != "" && !strings.HasPrefix(s.WSSettings.Path, "/") {
+			errs.add("streamSettings.wsSettings.path", "pathSlash")
+		}
+	case "http":
+		if s.HTTPSettings != nil && s.HTTPSettings.Path != "" && !strings.HasPrefix(s.HTTPSettings.Path, "/") {
+			errs.add("streamSettings.httpSettings.path", "pathSlash")
+		}
+	case "quic":
+		if quic := s.QUICSettings; quic != nil {
+			if !oneOf(quic.Security, "", "none", "aes-128-gcm", "chacha20-poly1305") {
+				errs.add("streamSettings.quicSettings.security", "unsupportedMethod", "Value", quic.Security)
+			} else if quic.Security != "" && quic.Security != "none" && quic.Key == "" {
+				errs.add("streamSettings.quicSettings.key", "quicKey")
+			}
+			if !oneOf(quic.Header.Type, append(headerTypes, "")...) {
+				errs.add("streamSettings.quicSettings.header.type", "unsupportedHeader", "Value", quic.Header.Type)
+			}
+		}
+	case "grpc":
+		if s.GRPCSettings == nil || s.GRPCSettings.ServiceName == "" {
+			errs.add("streamSettings.grpcSettings.serviceName", "empty")
+		}
+	case "domainsocket":
+	default:
+		errs.add("streamSettings.network", "unsupportedNetwork", "Value", s.Network)
+	}
+
+	switch s.GetSecurity() {
+	case "none":
+	case "tls":
+		validateTLS(&errs, "streamSettings.tlsSettings", s.TLSSettings)
+	case "xtls":
+		if !oneOf(network, "tcp", "kcp") {
+			errs.add("streamSettings.security", "xtlsNetwork")
+		}
+		validateTLS(&errs, "streamSettings.xtlsSettings", s.XTLSSettings)
+	default:
+		errs.add("streamSettings.security", "unsupportedSecurity", "Value", s.Security)
+	}
+	return errs
+}
+
+func validateTLS(errs *FieldErrors, field string, tls *TLSSettings) {
+	if tls == nil || len(tls.Certificates) == 0 {
+		errs.add(field+".certificates", "certificates")
+		return
+	}
+	for i, cert := range tls.Certificates {
+		prefix := fmt.Sprintf("%v.certificates[%d]", field, i)
+		if len(cert.Certificate) > 0 || len(cert.Key) > 0 {
+			if len(cert.Certificate) == 0 {
+				errs.add(prefix+".certificate", "empty")
+			}
+			if len(cert.Key) == 0 {
+				errs.add(prefix+".key", "empty")
+			}
+			continue
+		}
+		checkFile(errs, prefix+".certificateFile", cert.CertificateFile)
+		checkFile(errs, prefix+".keyFile", cert.KeyFile)
+	}
+}
+
+// checkFile 证书由本机的 xray 读取，文件必须在本机存在
+func checkFile(errs *FieldErrors, field string, path string) {
+	if path == "" {
+		errs.add(field, "empty")
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		errs.add(field, "fileUnreadable", "Path", path)
+	} else if info.IsDir() {
+		errs.add(field, "isDir", "Path", path)
+	}
+}
+
+type SniffingSettings struct {
+	Enabled      bool     `json:"enabled"`
+	DestOverride []string `json:"destOverride"`
+}
+
+func (s *SniffingSettings) Validate() FieldErrors {
+	errs := FieldErrors{}
+	for i, dest := range s.DestOverride {
+		if !oneOf(dest, "http", "tls", "quic", "fakedns", "fakedns+others") {
+			errs.add(fmt.Sprintf("sniffing.destOverride[%d]", i), "unsupportedSniffing", "Value", dest)
+		}
+	}
+	return errs
+}